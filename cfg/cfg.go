@@ -64,7 +64,7 @@ func (c *Config) Check() {
 		_ = f.Close()
 		file, err = ini.ShadowLoad(c.filename)
 		if err != nil {
-			log.L.Fatal(fmt.Sprintf("加载配置文件[ %s ]失败: %s", c.Filename, err))
+			log.L.Fatal(fmt.Sprintf("加载配置文件[ %s ]失败: %s", c.filename, err))
 		}
 	}
 	c.File = file
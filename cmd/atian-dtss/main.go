@@ -2,116 +2,99 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"github.com/robfig/cron/v3"
 	"github.com/zing-dev/atian-tools/source/atian/dts"
-	"github.com/zing-dev/atian-tools/source/device"
 	"log"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
-	"time"
 )
 
 type Core struct {
-	ctx    context.Context
-	cancel context.CancelFunc
-
-	apps   map[string]*dts.App
-	DTS    []dts.DTS
-	config dts.Config
-
-	locker sync.Mutex
-
-	CoordinateZones map[string]map[string]dts.Zones
+	fleet *dts.Fleet
 }
 
 func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	core := Core{
-		ctx:    ctx,
-		cancel: cancel,
-		apps:   map[string]*dts.App{},
-		DTS: []dts.DTS{
-			{Id: 0, Name: "", Host: "192.168.0.86"},
-			{Id: 0, Name: "", Host: "192.168.0.215"},
-		},
-		config:          dts.Config{ChannelNum: 4},
-		CoordinateZones: map[string]map[string]dts.Zones{},
+		fleet: dts.NewFleet(ctx, dts.Config{ChannelNum: 4, Coordinate: true}),
 	}
-	for _, d := range core.DTS {
-		d := d
-		go func(d dts.DTS) {
-			app := dts.New(core.ctx, d, &core.config)
-			app.Cron = cron.New(cron.WithSeconds())
-			core.locker.Lock()
-			core.apps[d.Host] = app
-			core.locker.Unlock()
-			id, err := app.Cron.AddFunc("*/10 * * * * *", func() {
-				log.Println("cron ", d.Host)
-			})
-			if err != nil {
-				return
-			}
-			app.CronIds[byte(id)] = id
-			app.Cron.Start()
-			app.Run()
-			for {
-				select {
-				case <-app.Context.Done():
-					app.Client.Close()
-					fmt.Println("out")
+	core.fleet.Cron = cron.New(cron.WithSeconds())
+	core.fleet.Cron.Start()
+	_, err := core.fleet.Cron.AddFunc("*/10 * * * * *", core.coordinate)
+	if err != nil {
+		log.Fatal(err)
+	}
+	core.fleet.CallTypes = []dts.CallType{dts.CallAlarm, dts.CallTemp, dts.CallSignal, dts.CallEvent}
+
+	var (
+		statuses = core.fleet.Subscribe(dts.CallStatus, 10, dts.DropOldest)
+		temps    = core.fleet.Subscribe(dts.CallTemp, 10, dts.DropOldest)
+		signals  = core.fleet.Subscribe(dts.CallSignal, 10, dts.DropOldest)
+		events   = core.fleet.Subscribe(dts.CallEvent, 10, dts.DropOldest)
+		alarms   = core.fleet.Subscribe(dts.CallAlarm, 10, dts.Block)
+	)
+	go func() {
+		for {
+			select {
+			case value, ok := <-statuses.C:
+				if !ok {
+					return
+				}
+				status := value.(dts.HostStatus)
+				log.Println("status", status.DTS.Host, status.Status.String())
+			case value, ok := <-temps.C:
+				if !ok {
+					return
+				}
+				log.Println("temp", value.(dts.ZonesTemp).Host)
+			case value, ok := <-signals.C:
+				if !ok {
+					return
+				}
+				sign := value.(dts.ChannelSignal)
+				log.Println("sign", sign.Host, sign.ChannelId)
+			case value, ok := <-events.C:
+				if !ok {
+					return
+				}
+				log.Println("event", value.(dts.ChannelEvent).Host)
+			case value, ok := <-alarms.C:
+				if !ok {
 					return
-				case status := <-app.ChanStatus:
-					log.Println("status", status.String())
-					if status == device.Connected {
-						app.Register()
-						app.SyncZones()
-						log.Println("开始配置新能源防区结构层级...")
-						core.locker.Lock()
-						for _, zone := range app.Zones {
-							if zone.Coordinate.Warehouse == "" || zone.Coordinate.Group == "" {
-								continue
-							}
-							if len(core.CoordinateZones[zone.Coordinate.Warehouse]) == 0 {
-								core.CoordinateZones[zone.Coordinate.Warehouse] = map[string]dts.Zones{}
-							}
-							if len(core.CoordinateZones[zone.Coordinate.Warehouse][zone.Coordinate.Group]) == 0 {
-								core.CoordinateZones[zone.Coordinate.Warehouse][zone.Coordinate.Group] = dts.Zones{}
-							}
-							core.CoordinateZones[zone.Coordinate.Warehouse][zone.Coordinate.Group] =
-								append(core.CoordinateZones[zone.Coordinate.Warehouse][zone.Coordinate.Group], zone)
-						}
-						core.locker.Unlock()
-						log.Println("配置新能源防区结构层级结束...")
-					}
-				case temp := <-app.ChanZonesTemp:
-					log.Println("temp", temp.Host)
-				case sign := <-app.ChanChannelSignal:
-					log.Println("sign", sign.Host, sign.ChannelId)
-				case event := <-app.ChanChannelEvent:
-					log.Println("event", event.Host)
-				case alarm := <-app.ChanZonesAlarm:
-					log.Println("alarm start", alarm.Host, dts.GetAlarmTypeString(alarm.Zones[0].Alarm.State))
-					time.Sleep(time.Second)
-					log.Println("alarm over", alarm.Host, dts.GetAlarmTypeString(alarm.Zones[0].Alarm.State))
 				}
+				alarm := value.(dts.ZonesAlarm)
+				log.Println("alarm", alarm.Host, dts.GetAlarmTypeString(alarm.Zones[0].Alarm.State))
 			}
-		}(d)
+		}
+	}()
+
+	for _, d := range []dts.DTS{
+		{Id: 0, Name: "", Host: "192.168.0.86"},
+		{Id: 0, Name: "", Host: "192.168.0.215"},
+	} {
+		if _, err := core.fleet.Add(d); err != nil {
+			log.Println("add", d.Host, err)
+		}
 	}
 
-	stop := make(chan os.Signal)
+	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGABRT)
 	select {
 	case <-stop:
 		log.Println("stop the word")
-		core.cancel()
-		time.Sleep(time.Second)
-		return
-	case <-core.ctx.Done():
+	case <-ctx.Done():
 		log.Println("done the word")
-		time.Sleep(time.Second)
-		return
+	}
+	cancel()
+	_ = core.fleet.Close()
+	core.fleet.Cron.Stop()
+}
+
+// coordinate 按防区坐标统计每个仓库每个组的温度
+func (c *Core) coordinate() {
+	for _, aggregate := range c.fleet.Index.Aggregates(dts.Coordinate{}, dts.CoordinateAll, dts.CoordinateGroup) {
+		log.Println("group", aggregate.Coordinate.Warehouse, aggregate.Coordinate.Group,
+			aggregate.Zones, aggregate.Max, aggregate.Avg, aggregate.Min)
 	}
 }
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/Atian-OE/DTSSDK_Golang/dtssdk/model"
+	"github.com/zing-dev/atian-tools/log"
+	"github.com/zing-dev/atian-tools/source/atian/dts/simulator"
+	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var (
+	addr     = flag.String("addr", fmt.Sprintf(":%d", simulator.Port), "监听地址,DTSSDK 客户端固定连接 17083 端口")
+	deviceId = flag.String("device", "DTS-SIMULATOR", "设备编码")
+	channels = flag.Int("channels", 4, "通道数")
+	zones    = flag.Int("zones", 10, "每个通道的防区数")
+	length   = flag.Float64("length", 50, "每个防区的长度 米")
+	scale    = flag.Float64("scale", 0.5, "温度信号的采样间隔 米")
+	base     = flag.Float64("base", 25, "基准温度")
+	tempSec  = flag.Int("temp", 5, "防区温度推送间隔 秒")
+	signSec  = flag.Int("signal", 10, "通道信号推送间隔 秒")
+	alarmSec = flag.Int("alarm", 30, "随机防区报警推送间隔 秒,0 为不推送")
+	script   = flag.String("script", "", "推送脚本 json 文件,指定后不再推送随机数据")
+)
+
+func main() {
+	flag.Parse()
+	log.Init()
+	ctx, cancel := context.WithCancel(context.Background())
+	server := simulator.New(ctx, simulator.Config{
+		Addr:     *addr,
+		DeviceId: *deviceId,
+		Zones:    simulator.NewZones(*channels, *zones, float32(*length)),
+	})
+	if err := server.Listen(); err != nil {
+		log.L.Fatal(fmt.Sprintf("DTS 模拟器监听失败: %s", err))
+	}
+
+	if *script != "" {
+		s, err := simulator.LoadScript(*script)
+		if err != nil {
+			log.L.Fatal(err)
+		}
+		go func() {
+			if err := server.Play(ctx, s); err != nil && err != context.Canceled {
+				log.L.Error(fmt.Sprintf("推送脚本失败: %s", err))
+			}
+			log.L.Info("推送脚本结束")
+		}()
+	} else {
+		go run(ctx, server)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+	cancel()
+	_ = server.Close()
+	log.L.Info("DTS 模拟器已关闭")
+}
+
+// run 周期推送随机的防区温度,通道信号和防区报警
+func run(ctx context.Context, server *simulator.Server) {
+	tick := func(sec int) <-chan time.Time {
+		if sec <= 0 {
+			return nil
+		}
+		return time.NewTicker(time.Duration(sec) * time.Second).C
+	}
+	temp, sign, alarm := tick(*tempSec), tick(*signSec), tick(*alarmSec)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-temp:
+			n, err := server.NotifyZoneTemp(&model.ZoneTempNotify{Zones: simulator.ZoneTemps(server.Zones(), float32(*base))})
+			if err != nil {
+				log.L.Error(fmt.Sprintf("推送防区温度失败: %s", err))
+			}
+			log.L.Info(fmt.Sprintf("推送防区温度到 %d 个客户端", n))
+		case <-sign:
+			for c := 1; c <= *channels; c++ {
+				total := float32(*zones) * float32(*length)
+				_, err := server.NotifyTempSignal(&model.TempSignalNotify{
+					ChannelID:  int32(c),
+					RealLength: total,
+					Signal:     simulator.Signal(total, float32(*scale), float32(*base)),
+				})
+				if err != nil {
+					log.L.Error(fmt.Sprintf("推送通道 %d 信号失败: %s", c, err))
+				}
+			}
+		case <-alarm:
+			all := server.Zones()
+			if len(all) == 0 {
+				continue
+			}
+			zone := simulator.ZoneTemps(all[rand.Intn(len(all)):][:1], float32(*base)+50)[0]
+			zone.AlarmType = model.DefenceAreaState_AlarmTemp
+			zone.AlarmLoc = (zone.Start + zone.Finish) / 2
+			if _, err := server.NotifyZoneAlarm(&model.ZoneAlarmNotify{Zones: []*model.DefenceZone{zone}}); err != nil {
+				log.L.Error(fmt.Sprintf("推送防区报警失败: %s", err))
+			}
+			log.L.Warn(fmt.Sprintf("推送防区 %s 报警", zone.ZoneName))
+		}
+	}
+}
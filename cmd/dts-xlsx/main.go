@@ -6,7 +6,6 @@ import (
 	"github.com/zing-dev/atian-tools/log"
 	"github.com/zing-dev/atian-tools/protocol/xlsx"
 	"github.com/zing-dev/atian-tools/source/atian/dts"
-	"github.com/zing-dev/atian-tools/source/device"
 	"time"
 )
 
@@ -27,7 +26,7 @@ func main() {
 		MinSaveHour:   6,
 	})
 
-	app := dts.New(ctx, dts.DTS{Id: 1, Host: host}, &dts.Config{ChannelNum: 4, ZonesTempSec: 6})
+	app := dts.New(ctx, dts.DTS{Id: 1, Host: host}, &dts.Config{ChannelNum: 4, ZonesTempSec: 6}, dts.WithAuto())
 	time.AfterFunc(time.Hour*240, cancel)
 	app.CallTypes = []dts.CallType{dts.CallTemp, dts.CallSignal}
 	err := app.Run()
@@ -37,24 +36,19 @@ func main() {
 	for {
 		select {
 		case <-app.Context.Done():
-			app.Client.Close()
+			_ = app.Close()
 			fmt.Println("out")
 			return
-		case status := <-app.ChanStatus:
-			log.L.Info(status)
-			if status == device.Connected {
-				app.SyncZones()
-				err := app.Register()
-				if err != nil {
-					log.L.Fatal(err)
-				} else {
-					log.L.Info("注册成功")
-				}
+		case temp, ok := <-app.ChanZonesTemp:
+			if !ok {
+				return
 			}
-		case temp := <-app.ChanZonesTemp:
 			log.L.Info("temp", temp.DeviceId)
 			store.Store(temp)
-		case data := <-app.ChanChannelSignal:
+		case data, ok := <-app.ChanChannelSignal:
+			if !ok {
+				return
+			}
 			log.L.Info("signal:", data.DeviceId)
 			signalStore.Store(data)
 		}
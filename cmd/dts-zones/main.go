@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/360EntSecGroup-Skylar/excelize/v2"
+	"github.com/zing-dev/atian-tools/log"
+	"github.com/zing-dev/atian-tools/source/atian/dts"
+	"github.com/zing-dev/atian-tools/source/device"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	host     = flag.String("host", "192.168.0.86", "DTS 主机地址")
+	channels = flag.Int("channels", 4, "通道数")
+	gap      = flag.Float64("gap", 1, "相邻防区之间允许的最大间隔 米")
+	dir      = flag.String("dir", "./zones", "报告保存目录")
+	timeout  = flag.Duration("timeout", time.Second*30, "连接主机的超时时间")
+)
+
+type (
+	// Channel 通道的检查结果
+	Channel struct {
+		ChannelId byte   `json:"channel_id"`
+		Zones     int    `json:"zones"`
+		Issues    int    `json:"issues"`
+		Error     string `json:"error,omitempty"`
+	}
+
+	// Report 主机防区的调试报告
+	Report struct {
+		Host      string              `json:"host"`
+		DeviceId  string              `json:"device_id"`
+		At        device.TimeLocal    `json:"at"`
+		Gap       float32             `json:"gap"`
+		Channels  []Channel           `json:"channels"`
+		Issues    []*dts.ZoneIssue    `json:"issues"`
+		Zones     dts.Zones           `json:"zones"`
+		TagErrors []*dts.ZoneTagError `json:"tag_errors"`
+	}
+)
+
+func main() {
+	flag.Parse()
+	log.Init()
+	report, err := inspect()
+	if err != nil {
+		log.L.Fatal(err)
+	}
+	name := filepath.Join(*dir, fmt.Sprintf("%s-%s", report.Host, report.At.Format("20060102150405")))
+	if err := os.MkdirAll(*dir, os.ModePerm); err != nil {
+		log.L.Fatal(err)
+	}
+	if err := report.JSON(name + ".json"); err != nil {
+		log.L.Fatal(fmt.Sprintf("保存 json 报告失败: %s", err))
+	}
+	if err := report.XLSX(name + ".xlsx"); err != nil {
+		log.L.Fatal(fmt.Sprintf("保存 xlsx 报告失败: %s", err))
+	}
+	log.L.Info(fmt.Sprintf("主机 %s 防区 %d 个,问题 %d 个,报告保存为 %s.json 和 %s.xlsx",
+		report.Host, len(report.Zones), len(report.Issues), name, name))
+}
+
+// inspect 连接主机,逐个通道同步防区并检查
+func inspect() (*Report, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := dts.New(ctx, dts.DTS{Id: 1, Name: *host, Host: *host}, &dts.Config{
+		ChannelNum: byte(*channels),
+		Coordinate: true,
+		Relay:      true,
+	})
+	defer func() {
+		_ = app.Close()
+	}()
+	statuses := app.Subscribe(dts.CallStatus, 4, dts.DropOldest)
+	if err := app.Run(); err != nil {
+		return nil, err
+	}
+	deadline := time.After(*timeout)
+	for connected := false; !connected; {
+		select {
+		case value, ok := <-statuses.C:
+			if !ok {
+				return nil, errors.New("主机已经关闭")
+			}
+			connected = value.(dts.HostStatus).Status == device.Connected
+		case <-deadline:
+			return nil, errors.New(fmt.Sprintf("连接主机 %s 超时", *host))
+		}
+	}
+
+	report := &Report{
+		Host: *host,
+		At:   device.TimeLocal{Time: time.Now()},
+		Gap:  float32(*gap),
+	}
+	code, err := app.GetDeviceCode()
+	if err != nil {
+		log.L.Warn(fmt.Sprintf("获取主机 %s 设备编码失败: %s", *host, err))
+	}
+	report.DeviceId = code
+	for i := byte(1); i <= byte(*channels); i++ {
+		channel := Channel{ChannelId: i}
+		zones, err := app.GetSyncChannelZones(i)
+		if err != nil {
+			channel.Error = err.Error()
+			log.L.Error(fmt.Sprintf("获取主机 %s 通道 %d 防区失败: %s", *host, i, err))
+		}
+		channel.Zones = len(zones)
+		report.Channels = append(report.Channels, channel)
+		report.Zones = append(report.Zones, zones...)
+	}
+	report.TagErrors = app.TagErrors()
+	report.Issues = append(dts.TagIssues(report.TagErrors), dts.CheckZones(report.Zones, report.Gap)...)
+	for k := range report.Channels {
+		for _, issue := range report.Issues {
+			if issue.ChannelId == report.Channels[k].ChannelId {
+				report.Channels[k].Issues++
+			}
+		}
+	}
+	sort.SliceStable(report.Zones, func(i, j int) bool {
+		if report.Zones[i].ChannelId == report.Zones[j].ChannelId {
+			return report.Zones[i].Start < report.Zones[j].Start
+		}
+		return report.Zones[i].ChannelId < report.Zones[j].ChannelId
+	})
+	return report, nil
+}
+
+// JSON 保存 json 报告
+func (r *Report) JSON(filename string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+// XLSX 保存 xlsx 报告,包含汇总,问题和防区三个工作表
+func (r *Report) XLSX(filename string) error {
+	file := excelize.NewFile()
+	file.SetSheetName("Sheet1", "汇总")
+	file.NewSheet("问题")
+	file.NewSheet("防区")
+
+	summary := [][]interface{}{
+		{"主机", r.Host},
+		{"设备编码", r.DeviceId},
+		{"时间", r.At.Format(device.LocalDateTimeFormat)},
+		{"允许间隔 米", r.Gap},
+		{"防区数", len(r.Zones)},
+		{"问题数", len(r.Issues)},
+		{},
+		{"通道", "防区数", "问题数", "错误"},
+	}
+	for _, c := range r.Channels {
+		summary = append(summary, []interface{}{c.ChannelId, c.Zones, c.Issues, c.Error})
+	}
+
+	issues := [][]interface{}{{"类型", "通道", "防区", "说明"}}
+	for _, issue := range r.Issues {
+		ids := make([]string, len(issue.Zones))
+		for k, id := range issue.Zones {
+			ids[k] = fmt.Sprint(id)
+		}
+		issues = append(issues, []interface{}{issue.Type.String(), issue.ChannelId, strings.Join(ids, ","), issue.Message})
+	}
+
+	zones := [][]interface{}{{"Id", "名称", "通道", "开始位置", "结束位置", "仓库", "组", "行", "列", "层", "继电器", "标签"}}
+	for _, zone := range r.Zones {
+		row := []interface{}{zone.Id, zone.Name, zone.ChannelId, zone.Start, zone.Finish}
+		if c := zone.Coordinate; c != nil {
+			row = append(row, c.Warehouse, c.Group, c.Row, c.Column, c.Layer)
+		} else {
+			row = append(row, "", "", "", "", "")
+		}
+		tag, _ := dts.EncodeTags(zone.Tag)
+		row = append(row, dts.EncodeRelay(zone.Relay), tag)
+		zones = append(zones, row)
+	}
+
+	for _, sheet := range []struct {
+		name  string
+		rows  [][]interface{}
+		width float64
+	}{{"汇总", summary, 16}, {"问题", issues, 16}, {"防区", zones, 14}} {
+		for k, row := range sheet.rows {
+			axis, _ := excelize.CoordinatesToCellName(1, k+1)
+			if err := file.SetSheetRow(sheet.name, axis, &row); err != nil {
+				return err
+			}
+		}
+		_ = file.SetColWidth(sheet.name, "A", "L", sheet.width)
+	}
+	_ = file.SetColWidth("问题", "D", "D", 80)
+	return file.SaveAs(filename)
+}
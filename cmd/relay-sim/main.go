@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/zing-dev/atian-tools/log"
+	protocol "github.com/zing-dev/atian-tools/protocol/modbus"
+	"github.com/zing-dev/atian-tools/source/device/simulator"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+var (
+	addr     = flag.String("addr", ":8090", "监听地址")
+	branches = flag.Int("branches", simulator.DefaultBranches, "继电器路数")
+	latency  = flag.Int("latency", 0, "所有接口的响应延迟 毫秒")
+	fail     = flag.String("fail", "", "返回 500 的接口路径前缀,如 /api/on")
+	modbus   = flag.String("modbus", "", "Modbus 继电器模拟器的监听地址,如 :5020,为空时不启动")
+	mode     = flag.String("mode", "tcp", "Modbus 通信方式 tcp 或 rtu-tcp")
+)
+
+func main() {
+	flag.Parse()
+	log.Init()
+	ctx, cancel := context.WithCancel(context.Background())
+	relay := simulator.NewRelay(ctx, simulator.Config{Addr: *addr, Branches: *branches})
+	//故障按添加顺序匹配,失败的接口在前
+	if *fail != "" {
+		relay.AddFault(simulator.Fault{Path: *fail, Latency: *latency, Status: 500})
+	}
+	if *latency > 0 {
+		relay.AddFault(simulator.Fault{Latency: *latency})
+	}
+	if err := relay.Listen(); err != nil {
+		log.L.Fatal(fmt.Sprintf("继电器模拟器监听失败: %s", err))
+	}
+	log.L.Info(fmt.Sprintf("查看状态 %s%s, 注入故障 POST %s%s", relay.URL(), simulator.ApiState, relay.URL(), simulator.ApiFault))
+	if *modbus != "" {
+		m := simulator.NewModbus(ctx, simulator.ModbusConfig{Addr: *modbus, Mode: protocol.Mode(*mode), Coils: *branches})
+		if err := m.Listen(); err != nil {
+			log.L.Fatal(fmt.Sprintf("Modbus 模拟器监听失败: %s", err))
+		}
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+	cancel()
+	_ = relay.Close()
+	log.L.Info("继电器模拟器已关闭")
+}
@@ -8,6 +8,7 @@ require (
 	github.com/aceld/zinx v1.0.0
 	github.com/aliyun/alibaba-cloud-sdk-go v1.61.1117
 	github.com/eclipse/paho.mqtt.golang v1.3.4
+	github.com/golang/protobuf v1.4.3
 	github.com/gorilla/websocket v1.4.2
 	github.com/hooklift/gowsdl v0.5.0
 	github.com/iris-contrib/go.uuid v2.0.0+incompatible
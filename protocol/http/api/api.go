@@ -2,7 +2,9 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/robfig/cron/v3"
 	"github.com/zing-dev/atian-tools/source/atian/dts"
@@ -34,6 +36,14 @@ type Request struct {
 	Fiber *dts.ChannelEvent  `json:"fiber,omitempty"` //单个通道的光纤状态
 }
 
+// Params Api 的构造参数
+type Params struct {
+	URL string `json:"url"`
+}
+
+// Factory Api 的设备工厂,注册到 device.Registry 的 device.TypeApi
+type Factory struct{}
+
 type Response struct {
 	Code   Type        `json:"code,omitempty"`
 	Status bool        `json:"status"`
@@ -62,13 +72,19 @@ type Api struct {
 	status device.StatusType
 }
 
+// New 实例化 Api,每次调用返回新的实例
 func New(url string) *Api {
+	return &Api{
+		URL:    url,
+		Client: http.Client{Timeout: 3 * time.Second},
+		locker: sync.Mutex{},
+	}
+}
+
+// Default 获取默认的 Api,仅首次调用时的 url 有效
+func Default(url string) *Api {
 	once.Do(func() {
-		api = &Api{
-			URL:    url,
-			Client: http.Client{Timeout: 3 * time.Second},
-			locker: sync.Mutex{},
-		}
+		api = New(url)
 	})
 	return api
 }
@@ -153,3 +169,22 @@ func (a *Api) Close() error {
 	a.cron.Remove(a.CronId)
 	return nil
 }
+
+func (Factory) Params(d device.Device) (interface{}, error) {
+	a, ok := d.(*Api)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("不支持的 API 设备 %T", d))
+	}
+	return Params{URL: a.URL}, nil
+}
+
+func (Factory) New(_ context.Context, data []byte) (device.Device, error) {
+	var params Params
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, err
+	}
+	if params.URL == "" {
+		return nil, errors.New("API 地址为空")
+	}
+	return New(params.URL), nil
+}
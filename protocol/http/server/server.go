@@ -0,0 +1,330 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/zing-dev/atian-tools/protocol/websocket"
+	"github.com/zing-dev/atian-tools/source/atian/dts"
+	"github.com/zing-dev/atian-tools/source/device"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ApiDevices 设备列表和添加设备,单个设备为 /api/devices/{id},运行和关闭为 /api/devices/{id}/run 和 /api/devices/{id}/close
+	ApiDevices = "/api/devices"
+	// ApiStatus 所有设备的状态
+	ApiStatus = "/api/status"
+	// ApiDTS DTS 主机列表,单个主机的数据为 /api/dts/{host}/zones,temps,alarms 和 signals
+	ApiDTS = "/api/dts"
+	// ApiConstants 常量表
+	ApiConstants = "/api/constants"
+)
+
+type (
+	// Response 接口的响应
+	Response struct {
+		Status bool        `json:"status"`
+		Msg    string      `json:"msg,omitempty"`
+		Data   interface{} `json:"data,omitempty"`
+	}
+
+	// DeviceRequest 添加设备的请求,Params 为设备类型工厂的构造参数
+	DeviceRequest struct {
+		Type   device.Type     `json:"type"`
+		Params json.RawMessage `json:"params"`
+		Run    bool            `json:"run,omitempty"` //添加后是否运行
+	}
+
+	// Host DTS 主机的状态
+	Host struct {
+		DTS    dts.DTS           `json:"dts"`
+		Status device.StatusType `json:"status"`
+		Zones  int               `json:"zones"`
+	}
+
+	// Constants 常量表
+	Constants struct {
+		AlarmType     []device.Constant `json:"alarm_type"`
+		EventType     []device.Constant `json:"event_type"`
+		Connect       []device.Constant `json:"connect"`
+		Device        []device.Constant `json:"device"`
+		WebsocketType []device.Constant `json:"websocket_type"`
+	}
+
+	// Server 设备管理和 DTS 数据的 HTTP 接口,基于标准库 net/http,可直接用于 httptest
+	// 设备的运行和关闭与设备管理器一致,由 EventRun 和 EventClose 的监听完成
+	Server struct {
+		manger   *device.Manger
+		registry *device.Registry
+		mux      *http.ServeMux
+	}
+)
+
+var (
+	errNotFoundDTS = errors.New("未找到当前 DTS 主机")
+)
+
+// New 实例化 HTTP 接口,registry 为空时不支持添加设备
+func New(manger *device.Manger, registry *device.Registry) *Server {
+	s := &Server{
+		manger:   manger,
+		registry: registry,
+		mux:      http.NewServeMux(),
+	}
+	s.mux.HandleFunc(ApiDevices, s.devices)
+	s.mux.HandleFunc(ApiStatus, s.get(func(*http.Request) (interface{}, error) {
+		status := s.manger.GetStatus()
+		sort.Slice(status, func(i, j int) bool {
+			return status[i].Id < status[j].Id
+		})
+		return status, nil
+	}))
+	s.mux.HandleFunc(ApiDTS, s.get(func(*http.Request) (interface{}, error) {
+		return s.hosts(), nil
+	}))
+	s.mux.HandleFunc(ApiConstants, s.get(func(*http.Request) (interface{}, error) {
+		return GetConstants(), nil
+	}))
+	return s
+}
+
+// GetConstants 获取所有常量表
+func GetConstants() Constants {
+	return Constants{
+		AlarmType:     dts.GetAlarmTypeMap(),
+		EventType:     dts.GetEventTypeMap(),
+		Connect:       device.GetConnectMap(),
+		Device:        device.GetDeviceMap(),
+		WebsocketType: websocket.GetWebsocketTypeMap(),
+	}
+}
+
+// ServeHTTP 单个设备和主机的接口按编码后的路径处理,避免 ServeMux 清理设备 Id 中的 /
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.EscapedPath()
+	switch {
+	case strings.HasPrefix(path, ApiDevices+"/"):
+		s.device(w, r)
+	case strings.HasPrefix(path, ApiDTS+"/"):
+		s.dts(w, r)
+	default:
+		s.mux.ServeHTTP(w, r)
+	}
+}
+
+// devices GET 设备列表,POST 添加设备
+func (s *Server) devices(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		status := s.manger.GetStatus()
+		sort.Slice(status, func(i, j int) bool {
+			return status[i].Id < status[j].Id
+		})
+		reply(w, http.StatusOK, status)
+	case http.MethodPost:
+		if s.registry == nil {
+			fail(w, http.StatusNotImplemented, errors.New("未配置设备注册表, 不支持添加设备"))
+			return
+		}
+		var request DeviceRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			fail(w, http.StatusBadRequest, errors.New(fmt.Sprintf("解析请求失败: %s", err)))
+			return
+		}
+		d, err := s.registry.Build(s.manger.Context, request.Type, request.Params)
+		if err != nil {
+			fail(w, http.StatusBadRequest, err)
+			return
+		}
+		//构造的设备未添加时关闭,释放其占用的资源
+		if err := s.manger.AddIfAbsent(d); err != nil {
+			_ = d.Close()
+			fail(w, http.StatusConflict, errors.New(fmt.Sprintf("设备 %s 已经存在", d.GetId())))
+			return
+		}
+		if request.Run {
+			if err := s.manger.Run(d.GetId()); err != nil {
+				fail(w, http.StatusInternalServerError, errors.New(fmt.Sprintf("设备 %s 已添加, 运行失败: %s", d.GetId(), err)))
+				return
+			}
+		}
+		reply(w, http.StatusCreated, device.Status{Id: d.GetId(), Type: d.GetType(), Status: d.GetStatus()})
+	default:
+		fail(w, http.StatusMethodNotAllowed, errors.New(r.Method))
+	}
+}
+
+// device GET 获取设备,DELETE 删除设备,POST run 运行设备,POST close 关闭设备
+func (s *Server) device(w http.ResponseWriter, r *http.Request) {
+	segments, err := split(r, ApiDevices+"/")
+	if err != nil || len(segments) == 0 || len(segments) > 2 {
+		fail(w, http.StatusNotFound, errors.New(r.URL.Path))
+		return
+	}
+	id := segments[0]
+	d := s.manger.GetDevice(id)
+	if d == nil {
+		fail(w, http.StatusNotFound, device.NotFoundDeviceError)
+		return
+	}
+	action := ""
+	if len(segments) == 2 {
+		action = segments[1]
+	}
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		reply(w, http.StatusOK, device.Status{Id: id, Type: d.GetType(), Status: d.GetStatus()})
+	case action == "" && r.Method == http.MethodDelete:
+		result(w, s.manger.Delete(id))
+	case action == "run" && r.Method == http.MethodPost:
+		result(w, s.manger.Run(id))
+	case action == "close" && r.Method == http.MethodPost:
+		result(w, s.manger.Close(id))
+	case action != "" && action != "run" && action != "close":
+		fail(w, http.StatusNotFound, errors.New(r.URL.Path))
+	default:
+		fail(w, http.StatusMethodNotAllowed, errors.New(r.Method))
+	}
+}
+
+// dts DTS 主机的防区,温度,报警和信号
+func (s *Server) dts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		fail(w, http.StatusMethodNotAllowed, errors.New(r.Method))
+		return
+	}
+	segments, err := split(r, ApiDTS+"/")
+	if err != nil || len(segments) != 2 {
+		fail(w, http.StatusNotFound, errors.New(r.URL.Path))
+		return
+	}
+	app := s.app(segments[0])
+	if app == nil {
+		fail(w, http.StatusNotFound, errNotFoundDTS)
+		return
+	}
+	switch segments[1] {
+	case "zones":
+		zones := make(dts.Zones, 0)
+		for _, zone := range app.GetZones() {
+			zones = append(zones, zone.Clone())
+		}
+		sort.Slice(zones, func(i, j int) bool {
+			return zones[i].Id < zones[j].Id
+		})
+		reply(w, http.StatusOK, zones)
+	case "temps":
+		reply(w, http.StatusOK, app.LastTemp())
+	case "alarms":
+		reply(w, http.StatusOK, app.Tracker.Incidents())
+	case "signals":
+		signals := app.LastSignals()
+		if value := r.URL.Query().Get("channel"); value != "" {
+			channel, err := strconv.Atoi(value)
+			if err != nil {
+				fail(w, http.StatusBadRequest, errors.New(fmt.Sprintf("非法的通道 %s", value)))
+				return
+			}
+			filtered := make([]dts.ChannelSignal, 0, 1)
+			for _, signal := range signals {
+				if signal.ChannelId == int32(channel) {
+					filtered = append(filtered, signal)
+				}
+			}
+			signals = filtered
+		}
+		reply(w, http.StatusOK, signals)
+	default:
+		fail(w, http.StatusNotFound, errors.New(r.URL.Path))
+	}
+}
+
+// app 根据主机地址获取 DTS 设备
+func (s *Server) app(host string) *dts.App {
+	app, ok := s.manger.GetDevice(host).(*dts.App)
+	if !ok {
+		return nil
+	}
+	return app
+}
+
+// hosts 获取所有 DTS 主机,按 Id 排序
+func (s *Server) hosts() []Host {
+	hosts := make([]Host, 0)
+	s.manger.Range(func(_ string, d device.Device) {
+		if app, ok := d.(*dts.App); ok {
+			hosts = append(hosts, Host{DTS: app.DTS, Status: app.GetStatus(), Zones: len(app.GetZones())})
+		}
+	})
+	sort.Slice(hosts, func(i, j int) bool {
+		if hosts[i].DTS.Id != hosts[j].DTS.Id {
+			return hosts[i].DTS.Id < hosts[j].DTS.Id
+		}
+		return hosts[i].DTS.Host < hosts[j].DTS.Host
+	})
+	return hosts
+}
+
+// get 只支持 GET 的接口
+func (s *Server) get(handle func(*http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			fail(w, http.StatusMethodNotAllowed, errors.New(r.Method))
+			return
+		}
+		data, err := handle(r)
+		if err != nil {
+			fail(w, http.StatusInternalServerError, err)
+			return
+		}
+		reply(w, http.StatusOK, data)
+	}
+}
+
+// split 将前缀之后的路径按 / 分割并解码,设备 Id 中的 / 需编码为 %2F
+func split(r *http.Request, prefix string) ([]string, error) {
+	path := strings.TrimPrefix(strings.TrimSuffix(r.URL.EscapedPath(), "/"), prefix)
+	if path == "" {
+		return nil, nil
+	}
+	segments := strings.Split(path, "/")
+	for k, segment := range segments {
+		value, err := url.PathUnescape(segment)
+		if err != nil {
+			return nil, err
+		}
+		segments[k] = value
+	}
+	return segments, nil
+}
+
+func result(w http.ResponseWriter, err error) {
+	if errors.Is(err, device.NotFoundDeviceError) {
+		fail(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		fail(w, http.StatusInternalServerError, err)
+		return
+	}
+	reply(w, http.StatusOK, nil)
+}
+
+func reply(w http.ResponseWriter, status int, data interface{}) {
+	write(w, status, Response{Status: true, Data: data})
+}
+
+func fail(w http.ResponseWriter, status int, err error) {
+	write(w, status, Response{Status: false, Msg: err.Error()})
+}
+
+func write(w http.ResponseWriter, status int, response Response) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,297 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"github.com/zing-dev/atian-tools/source/device"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type (
+	// testDevice 记录运行和关闭次数的设备
+	testDevice struct {
+		id     string
+		locker sync.Mutex
+		runs   int
+		closes int
+		status device.StatusType
+	}
+
+	// testFactory 构造 testDevice 并记录所有构造的设备
+	testFactory struct {
+		locker  sync.Mutex
+		devices []*testDevice
+	}
+)
+
+func (d *testDevice) GetId() string        { return d.id }
+func (d *testDevice) GetType() device.Type { return device.TypeRelay }
+func (d *testDevice) SetCron(*cron.Cron)   {}
+
+func (d *testDevice) GetStatus() device.StatusType {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	return d.status
+}
+
+func (d *testDevice) Run() error {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	d.runs++
+	d.status = device.Connected
+	return nil
+}
+
+func (d *testDevice) Close() error {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	d.closes++
+	d.status = device.UnConnect
+	return nil
+}
+
+func (d *testDevice) counts() (int, int) {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	return d.runs, d.closes
+}
+
+func (f *testFactory) Params(d device.Device) (interface{}, error) {
+	return map[string]string{"id": d.GetId()}, nil
+}
+
+func (f *testFactory) New(_ context.Context, data []byte) (device.Device, error) {
+	var params map[string]string
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, err
+	}
+	d := &testDevice{id: params["id"], status: device.UnConnect}
+	f.locker.Lock()
+	f.devices = append(f.devices, d)
+	f.locker.Unlock()
+	return d, nil
+}
+
+func (f *testFactory) built(k int) *testDevice {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	return f.devices[k]
+}
+
+func newServer(t *testing.T) (*Server, *device.Manger, *testFactory) {
+	m := device.NewManger(context.Background())
+	t.Cleanup(m.Stop)
+	m.Subscribe(device.EventRun, m.RunDevice)
+	m.Register(device.EventClose, func(d device.Device) {
+		_ = d.Close()
+	})
+	factory := &testFactory{}
+	registry := device.NewRegistry(m, "")
+	registry.Register(device.TypeRelay, factory)
+	return New(m, registry), m, factory
+}
+
+func serve(s *Server, method, target string, body interface{}) (*httptest.ResponseRecorder, Response) {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(method, target, bytes.NewReader(data)))
+	var response Response
+	_ = json.Unmarshal(w.Body.Bytes(), &response)
+	return w, response
+}
+
+func request(id string, run bool) DeviceRequest {
+	params, _ := json.Marshal(map[string]string{"id": id})
+	return DeviceRequest{Type: device.TypeRelay, Params: params, Run: run}
+}
+
+// waitFor 等待条件满足
+func waitFor(t *testing.T, message string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDevices(t *testing.T) {
+	s, m, factory := newServer(t)
+
+	w, response := serve(s, http.MethodGet, ApiDevices, nil)
+	if w.Code != http.StatusOK || !response.Status {
+		t.Fatalf("设备列表 %d %+v", w.Code, response)
+	}
+
+	w, response = serve(s, http.MethodPost, ApiDevices, request("relay-a", false))
+	if w.Code != http.StatusCreated || !response.Status {
+		t.Fatalf("添加设备 %d %+v", w.Code, response)
+	}
+	if m.GetDevice("relay-a") == nil {
+		t.Fatal("设备未添加到设备管理器")
+	}
+
+	//相同 Id 的设备冲突,新构造的设备被关闭,原设备不变
+	w, response = serve(s, http.MethodPost, ApiDevices, request("relay-a", false))
+	if w.Code != http.StatusConflict || response.Status {
+		t.Fatalf("重复添加设备 %d %+v", w.Code, response)
+	}
+	if _, closes := factory.built(1).counts(); closes != 1 {
+		t.Fatalf("冲突时构造的设备关闭 %d 次, 期望 1 次", closes)
+	}
+	if m.GetDevice("relay-a") != factory.built(0) {
+		t.Fatal("冲突时原设备被替换")
+	}
+
+	w, response = serve(s, http.MethodPost, ApiDevices, json.RawMessage(`{"type":`))
+	if w.Code != http.StatusBadRequest || response.Status {
+		t.Fatalf("非法请求 %d %+v", w.Code, response)
+	}
+
+	w, _ = serve(s, http.MethodPut, ApiDevices, nil)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("不支持的方法 %d", w.Code)
+	}
+
+	w, response = serve(s, http.MethodGet, ApiDevices, nil)
+	data, _ := json.Marshal(response.Data)
+	var status []device.Status
+	_ = json.Unmarshal(data, &status)
+	if w.Code != http.StatusOK || len(status) != 1 || status[0].Id != "relay-a" {
+		t.Fatalf("设备列表 %d %s", w.Code, data)
+	}
+}
+
+func TestDevice(t *testing.T) {
+	s, m, factory := newServer(t)
+
+	//设备 Id 中的 / 编码为 %2F
+	w, response := serve(s, http.MethodPost, ApiDevices, request("relay/a", true))
+	if w.Code != http.StatusCreated || !response.Status {
+		t.Fatalf("添加并运行设备 %d %+v", w.Code, response)
+	}
+	d := factory.built(0)
+	waitFor(t, "添加后设备未运行", func() bool {
+		runs, _ := d.counts()
+		return runs == 1
+	})
+
+	target := ApiDevices + "/relay%2Fa"
+	w, response = serve(s, http.MethodGet, target, nil)
+	if w.Code != http.StatusOK || !response.Status {
+		t.Fatalf("获取设备 %d %+v", w.Code, response)
+	}
+
+	w, _ = serve(s, http.MethodPost, target+"/close", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("关闭设备 %d", w.Code)
+	}
+	waitFor(t, "设备未关闭", func() bool {
+		_, closes := d.counts()
+		return closes == 1
+	})
+
+	w, _ = serve(s, http.MethodPost, target+"/run", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("运行设备 %d", w.Code)
+	}
+	waitFor(t, "设备未再次运行", func() bool {
+		runs, _ := d.counts()
+		return runs == 2
+	})
+
+	w, _ = serve(s, http.MethodGet, target+"/run", nil)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET 运行设备 %d, 期望 405", w.Code)
+	}
+	w, _ = serve(s, http.MethodPost, target+"/reset", nil)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("未知的操作 %d, 期望 404", w.Code)
+	}
+	//未编码的 / 不是同一设备
+	w, _ = serve(s, http.MethodGet, ApiDevices+"/relay/a", nil)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("未编码的设备 Id %d, 期望 404", w.Code)
+	}
+
+	w, _ = serve(s, http.MethodDelete, target, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("删除设备 %d", w.Code)
+	}
+	if m.GetDevice("relay/a") != nil {
+		t.Fatal("设备未删除")
+	}
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		w, response = serve(s, method, target, nil)
+		if w.Code != http.StatusNotFound || response.Msg != device.NotFoundDeviceError.Error() {
+			t.Fatalf("%s 已删除的设备 %d %+v", method, w.Code, response)
+		}
+	}
+	w, _ = serve(s, http.MethodPost, ApiDevices+"/missing/run", nil)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("运行不存在的设备 %d, 期望 404", w.Code)
+	}
+}
+
+// TestDevicesConcurrent 添加设备的同时获取设备列表和状态,需使用 go test -race 运行
+func TestDevicesConcurrent(t *testing.T) {
+	s, m, _ := newServer(t)
+	const (
+		adders = 4
+		n      = 100
+	)
+	var (
+		adding sync.WaitGroup
+		wg     sync.WaitGroup
+		done   = make(chan struct{})
+	)
+	for a := 0; a < adders; a++ {
+		adding.Add(1)
+		go func(a int) {
+			defer adding.Done()
+			for i := 0; i < n; i++ {
+				w, response := serve(s, http.MethodPost, ApiDevices, request(fmt.Sprintf("relay-%d-%d", a, i), false))
+				if w.Code != http.StatusCreated {
+					t.Errorf("添加设备 %d %+v", w.Code, response)
+					return
+				}
+			}
+		}(a)
+	}
+	for _, target := range []string{ApiDevices, ApiStatus} {
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if w, response := serve(s, http.MethodGet, target, nil); w.Code != http.StatusOK {
+					t.Errorf("%s %d %+v", target, w.Code, response)
+					return
+				}
+				m.Devices()
+			}
+		}(target)
+	}
+	adding.Wait()
+	close(done)
+	wg.Wait()
+	if m.Length() != adders*n {
+		t.Fatalf("得到 %d 个设备, 期望 %d", m.Length(), adders*n)
+	}
+}
@@ -0,0 +1,410 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"go.bug.st/serial"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	FuncReadCoils          byte = 0x01 //读线圈
+	FuncWriteSingleCoil    byte = 0x05 //写单个线圈
+	FuncWriteMultipleCoils byte = 0x0F //写多个线圈
+)
+
+const (
+	ExceptionIllegalFunction byte = 0x01 //非法功能码
+	ExceptionIllegalAddress  byte = 0x02 //非法数据地址
+	ExceptionIllegalValue    byte = 0x03 //非法数据值
+	ExceptionDeviceFailure   byte = 0x04 //从站设备故障
+)
+
+const (
+	ModeTCP    Mode = "tcp"     //Modbus TCP
+	ModeRTU    Mode = "rtu"     //Modbus RTU 串口
+	ModeRTUTCP Mode = "rtu-tcp" //Modbus RTU over TCP,如串口服务器透传
+)
+
+const (
+	// MaxCoils 一次读写的最大线圈数
+	MaxCoils = 1968
+	// tcpHeaderLength MBAP 报文头长度
+	tcpHeaderLength = 7
+)
+
+type (
+	// Mode 通信方式
+	Mode string
+
+	// Config Modbus 客户端配置
+	Config struct {
+		Mode     Mode            `json:"mode"`                //通信方式
+		Addr     string          `json:"addr"`                //TCP 地址 host:port 或串口名称,如 /dev/ttyUSB0,COM3
+		SlaveId  byte            `json:"slave_id"`            //从站地址
+		BaudRate int             `json:"baud_rate,omitempty"` //串口波特率,默认 9600
+		DataBits int             `json:"data_bits,omitempty"` //串口数据位,默认 8
+		Parity   serial.Parity   `json:"parity,omitempty"`    //串口校验位,默认无校验
+		StopBits serial.StopBits `json:"stop_bits,omitempty"` //串口停止位,默认 1 位
+		Timeout  int             `json:"timeout,omitempty"`   //请求超时时间 毫秒,默认 1000
+	}
+
+	// Exception 从站返回的异常响应
+	Exception struct {
+		Function byte
+		Code     byte
+	}
+
+	// Client Modbus 主站客户端,同一时间只有一个请求,连接在首次请求或出错后重新建立
+	Client struct {
+		config      Config
+		conn        io.ReadWriteCloser
+		transaction uint16
+		locker      sync.Mutex
+	}
+)
+
+func (e *Exception) Error() string {
+	var message string
+	switch e.Code {
+	case ExceptionIllegalFunction:
+		message = "非法功能码"
+	case ExceptionIllegalAddress:
+		message = "非法数据地址"
+	case ExceptionIllegalValue:
+		message = "非法数据值"
+	case ExceptionDeviceFailure:
+		message = "从站设备故障"
+	default:
+		message = "未知异常"
+	}
+	return fmt.Sprintf("功能码 0x%02X 异常 0x%02X: %s", e.Function, e.Code, message)
+}
+
+func NewClient(config Config) *Client {
+	if config.Mode == "" {
+		config.Mode = ModeTCP
+	}
+	if config.BaudRate == 0 {
+		config.BaudRate = 9600
+	}
+	if config.DataBits == 0 {
+		config.DataBits = 8
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 1000
+	}
+	return &Client{config: config}
+}
+
+// Connect 建立连接,已连接时直接返回
+func (c *Client) Connect() error {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	return c.connect()
+}
+
+// Close 关闭连接
+func (c *Client) Close() error {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	return c.close()
+}
+
+// ReadCoils 读取从 address 开始的 quantity 个线圈
+func (c *Client) ReadCoils(address, quantity uint16) ([]bool, error) {
+	if quantity == 0 || quantity > MaxCoils {
+		return nil, errors.New(fmt.Sprintf("线圈数量 %d 超出范围 1-%d", quantity, MaxCoils))
+	}
+	pdu := make([]byte, 5)
+	pdu[0] = FuncReadCoils
+	binary.BigEndian.PutUint16(pdu[1:], address)
+	binary.BigEndian.PutUint16(pdu[3:], quantity)
+	response, err := c.Send(pdu)
+	if err != nil {
+		return nil, err
+	}
+	if len(response) < 2 || int(response[1]) != (int(quantity)+7)/8 || len(response) != 2+int(response[1]) {
+		return nil, errors.New(fmt.Sprintf("读线圈响应长度 %d 错误", len(response)))
+	}
+	return DecodeCoils(response[2:], int(quantity)), nil
+}
+
+// WriteSingleCoil 写单个线圈
+func (c *Client) WriteSingleCoil(address uint16, on bool) error {
+	pdu := make([]byte, 5)
+	pdu[0] = FuncWriteSingleCoil
+	binary.BigEndian.PutUint16(pdu[1:], address)
+	if on {
+		pdu[3] = 0xFF
+	}
+	response, err := c.Send(pdu)
+	if err != nil {
+		return err
+	}
+	if len(response) != len(pdu) || string(response) != string(pdu) {
+		return errors.New("写单个线圈响应与请求不一致")
+	}
+	return nil
+}
+
+// WriteMultipleCoils 写从 address 开始的多个线圈
+func (c *Client) WriteMultipleCoils(address uint16, values []bool) error {
+	if len(values) == 0 || len(values) > MaxCoils {
+		return errors.New(fmt.Sprintf("线圈数量 %d 超出范围 1-%d", len(values), MaxCoils))
+	}
+	data := EncodeCoils(values)
+	pdu := make([]byte, 6, 6+len(data))
+	pdu[0] = FuncWriteMultipleCoils
+	binary.BigEndian.PutUint16(pdu[1:], address)
+	binary.BigEndian.PutUint16(pdu[3:], uint16(len(values)))
+	pdu[5] = byte(len(data))
+	pdu = append(pdu, data...)
+	response, err := c.Send(pdu)
+	if err != nil {
+		return err
+	}
+	if len(response) != 5 || string(response) != string(pdu[:5]) {
+		return errors.New("写多个线圈响应与请求不一致")
+	}
+	return nil
+}
+
+// Send 发送请求 PDU 并返回响应 PDU,从站返回异常时返回 *Exception,通信出错时断开连接
+func (c *Client) Send(pdu []byte) ([]byte, error) {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	response, err := c.exchange(pdu)
+	if err != nil {
+		_ = c.close()
+		return nil, err
+	}
+	if response[0] == pdu[0]|0x80 {
+		if len(response) < 2 {
+			return nil, errors.New("异常响应长度错误")
+		}
+		return nil, &Exception{Function: pdu[0], Code: response[1]}
+	}
+	if response[0] != pdu[0] {
+		return nil, errors.New(fmt.Sprintf("响应功能码 0x%02X 与请求 0x%02X 不一致", response[0], pdu[0]))
+	}
+	return response, nil
+}
+
+func (c *Client) connect() (err error) {
+	if c.conn != nil {
+		return nil
+	}
+	switch c.config.Mode {
+	case ModeTCP, ModeRTUTCP:
+		c.conn, err = net.DialTimeout("tcp", c.config.Addr, c.timeout())
+	case ModeRTU:
+		c.conn, err = serial.Open(c.config.Addr, &serial.Mode{
+			BaudRate: c.config.BaudRate,
+			DataBits: c.config.DataBits,
+			Parity:   c.config.Parity,
+			StopBits: c.config.StopBits,
+		})
+	default:
+		return errors.New(fmt.Sprintf("不支持的通信方式 %s", c.config.Mode))
+	}
+	if err != nil {
+		c.conn = nil
+	}
+	return err
+}
+
+// Config 获取客户端配置
+func (c *Client) Config() Config {
+	return c.config
+}
+
+func (c *Client) timeout() time.Duration {
+	return time.Millisecond * time.Duration(c.config.Timeout)
+}
+
+func (c *Client) close() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// exchange 在超时时间内完成一次请求和响应
+// 串口不支持读超时,超时后关闭串口使读取返回
+func (c *Client) exchange(pdu []byte) ([]byte, error) {
+	if conn, ok := c.conn.(net.Conn); ok {
+		_ = conn.SetDeadline(time.Now().Add(c.timeout()))
+		return c.roundTrip(conn, pdu)
+	}
+	type result struct {
+		pdu []byte
+		err error
+	}
+	done := make(chan result, 1)
+	conn := c.conn
+	go func() {
+		response, err := c.roundTrip(conn, pdu)
+		done <- result{response, err}
+	}()
+	select {
+	case r := <-done:
+		return r.pdu, r.err
+	case <-time.After(c.timeout()):
+		_ = conn.Close()
+		<-done
+		return nil, errors.New(fmt.Sprintf("请求超时 %s", c.timeout()))
+	}
+}
+
+func (c *Client) roundTrip(conn io.ReadWriter, pdu []byte) ([]byte, error) {
+	if c.config.Mode == ModeTCP {
+		c.transaction++
+		if _, err := conn.Write(EncodeTCP(c.transaction, c.config.SlaveId, pdu)); err != nil {
+			return nil, err
+		}
+		for {
+			transaction, _, response, err := ReadTCP(conn)
+			if err != nil {
+				return nil, err
+			}
+			//丢弃之前超时请求的响应
+			if transaction == c.transaction {
+				return response, nil
+			}
+		}
+	}
+	if _, err := conn.Write(EncodeRTU(c.config.SlaveId, pdu)); err != nil {
+		return nil, err
+	}
+	slave, response, err := ReadRTU(conn, false)
+	if err != nil {
+		return nil, err
+	}
+	if slave != c.config.SlaveId {
+		return nil, errors.New(fmt.Sprintf("响应从站地址 %d 与请求 %d 不一致", slave, c.config.SlaveId))
+	}
+	return response, nil
+}
+
+// EncodeCoils 将线圈状态按位编码,低位在前
+func EncodeCoils(values []bool) []byte {
+	data := make([]byte, (len(values)+7)/8)
+	for i, v := range values {
+		if v {
+			data[i/8] |= 1 << (i % 8)
+		}
+	}
+	return data
+}
+
+// DecodeCoils 解码 quantity 个线圈状态
+func DecodeCoils(data []byte, quantity int) []bool {
+	values := make([]bool, quantity)
+	for i := range values {
+		if i/8 < len(data) {
+			values[i] = data[i/8]&(1<<(i%8)) != 0
+		}
+	}
+	return values
+}
+
+// EncodeTCP 编码 Modbus TCP 报文 MBAP 报文头 + PDU
+func EncodeTCP(transaction uint16, unit byte, pdu []byte) []byte {
+	frame := make([]byte, tcpHeaderLength, tcpHeaderLength+len(pdu))
+	binary.BigEndian.PutUint16(frame[0:], transaction)
+	binary.BigEndian.PutUint16(frame[4:], uint16(len(pdu)+1))
+	frame[6] = unit
+	return append(frame, pdu...)
+}
+
+// ReadTCP 读取一个 Modbus TCP 报文
+func ReadTCP(r io.Reader) (transaction uint16, unit byte, pdu []byte, err error) {
+	header := make([]byte, tcpHeaderLength)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return
+	}
+	length := int(binary.BigEndian.Uint16(header[4:]))
+	if binary.BigEndian.Uint16(header[2:]) != 0 || length < 2 || length > 254 {
+		err = errors.New(fmt.Sprintf("MBAP 报文头错误 % X", header))
+		return
+	}
+	pdu = make([]byte, length-1)
+	if _, err = io.ReadFull(r, pdu); err != nil {
+		return
+	}
+	return binary.BigEndian.Uint16(header[0:]), header[6], pdu, nil
+}
+
+// EncodeRTU 编码 Modbus RTU 报文 从站地址 + PDU + CRC
+func EncodeRTU(slave byte, pdu []byte) []byte {
+	frame := make([]byte, 1, len(pdu)+3)
+	frame[0] = slave
+	frame = append(frame, pdu...)
+	crc := CRC16(frame)
+	return append(frame, byte(crc), byte(crc>>8))
+}
+
+// ReadRTU 读取一个 Modbus RTU 报文,request 为 true 时按请求报文解析长度,否则按响应报文
+func ReadRTU(r io.Reader, request bool) (slave byte, pdu []byte, err error) {
+	frame := make([]byte, 3, 256)
+	if _, err = io.ReadFull(r, frame); err != nil {
+		return
+	}
+	function := frame[1]
+	var length int //报文总长度,包含 CRC
+	switch {
+	case function&0x80 != 0:
+		length = 5
+	case function == FuncReadCoils && !request:
+		length = 5 + int(frame[2])
+	case function == FuncWriteMultipleCoils && request:
+		header := make([]byte, 4)
+		if _, err = io.ReadFull(r, header); err != nil {
+			return
+		}
+		frame = append(frame, header...)
+		length = 9 + int(header[3])
+	case function == FuncReadCoils, function == FuncWriteSingleCoil, function == FuncWriteMultipleCoils:
+		length = 8
+	default:
+		err = errors.New(fmt.Sprintf("不支持的功能码 0x%02X", function))
+		return
+	}
+	rest := make([]byte, length-len(frame))
+	if _, err = io.ReadFull(r, rest); err != nil {
+		return
+	}
+	frame = append(frame, rest...)
+	crc := CRC16(frame[:length-2])
+	if frame[length-2] != byte(crc) || frame[length-1] != byte(crc>>8) {
+		err = errors.New(fmt.Sprintf("CRC 校验错误 % X", frame))
+		return
+	}
+	return frame[0], frame[1 : length-2], nil
+}
+
+// CRC16 Modbus CRC16 校验
+func CRC16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,111 @@
+package modbus
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCRC16(t *testing.T) {
+	tests := []struct {
+		frame []byte
+		crc   uint16
+	}{
+		{frame: []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x0A}, crc: 0xCDC5},
+		{frame: []byte{0x01, 0x05, 0x00, 0x00, 0xFF, 0x00}, crc: 0x3A8C},
+		{frame: []byte{}, crc: 0xFFFF},
+	}
+	for _, test := range tests {
+		if crc := CRC16(test.frame); crc != test.crc {
+			t.Fatalf("% X 的 CRC 为 0x%04X, 期望 0x%04X", test.frame, crc, test.crc)
+		}
+	}
+}
+
+func TestCoils(t *testing.T) {
+	values := []bool{true, false, true, true, false, false, false, false, false, true}
+	data := EncodeCoils(values)
+	if !bytes.Equal(data, []byte{0x0D, 0x02}) {
+		t.Fatalf("编码为 % X", data)
+	}
+	if decoded := DecodeCoils(data, len(values)); !reflect.DeepEqual(decoded, values) {
+		t.Fatalf("解码为 %v", decoded)
+	}
+	//数据不足时其余线圈为关闭
+	if decoded := DecodeCoils([]byte{0xFF}, 10); !reflect.DeepEqual(decoded[7:], []bool{true, false, false}) {
+		t.Fatalf("解码为 %v", decoded)
+	}
+}
+
+func TestTCPFrame(t *testing.T) {
+	pdu := []byte{FuncWriteSingleCoil, 0x00, 0x03, 0xFF, 0x00}
+	frame := EncodeTCP(0x1234, 0x11, pdu)
+	if !bytes.Equal(frame, []byte{0x12, 0x34, 0x00, 0x00, 0x00, 0x06, 0x11, 0x05, 0x00, 0x03, 0xFF, 0x00}) {
+		t.Fatalf("编码为 % X", frame)
+	}
+	transaction, unit, decoded, err := ReadTCP(bytes.NewReader(frame))
+	if err != nil || transaction != 0x1234 || unit != 0x11 || !bytes.Equal(decoded, pdu) {
+		t.Fatalf("解码为 %d %d % X %v", transaction, unit, decoded, err)
+	}
+	//协议标识不为 0
+	frame[2] = 0x01
+	if _, _, _, err := ReadTCP(bytes.NewReader(frame)); err == nil || !strings.Contains(err.Error(), "MBAP") {
+		t.Fatalf("得到 %v", err)
+	}
+	//报文不完整
+	if _, _, _, err := ReadTCP(bytes.NewReader(EncodeTCP(1, 1, pdu)[:9])); err == nil {
+		t.Fatal("不完整的报文应返回错误")
+	}
+}
+
+func TestRTUFrame(t *testing.T) {
+	tests := []struct {
+		name    string
+		pdu     []byte
+		request bool
+	}{
+		{name: "读线圈请求", pdu: []byte{FuncReadCoils, 0x00, 0x00, 0x00, 0x0A}, request: true},
+		{name: "读线圈响应", pdu: []byte{FuncReadCoils, 0x02, 0x0D, 0x02}},
+		{name: "写单个线圈", pdu: []byte{FuncWriteSingleCoil, 0x00, 0x03, 0xFF, 0x00}},
+		{name: "写多个线圈请求", pdu: []byte{FuncWriteMultipleCoils, 0x00, 0x00, 0x00, 0x0A, 0x02, 0x0D, 0x02}, request: true},
+		{name: "写多个线圈响应", pdu: []byte{FuncWriteMultipleCoils, 0x00, 0x00, 0x00, 0x0A}},
+		{name: "异常响应", pdu: ExceptionResponse(FuncWriteSingleCoil, ExceptionIllegalAddress)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			frame := EncodeRTU(0x11, test.pdu)
+			//之后的报文不影响当前报文的解析
+			r := bytes.NewReader(append(frame, EncodeRTU(0x11, test.pdu)...))
+			slave, pdu, err := ReadRTU(r, test.request)
+			if err != nil || slave != 0x11 || !bytes.Equal(pdu, test.pdu) {
+				t.Fatalf("解码为 %d % X %v", slave, pdu, err)
+			}
+			if r.Len() != len(frame) {
+				t.Fatalf("读取了 %d 字节, 期望 %d", len(frame)*2-r.Len(), len(frame))
+			}
+			frame[len(frame)-1] ^= 0xFF
+			if _, _, err := ReadRTU(bytes.NewReader(frame), test.request); err == nil || !strings.Contains(err.Error(), "CRC") {
+				t.Fatalf("CRC 错误时得到 %v", err)
+			}
+		})
+	}
+	if _, _, err := ReadRTU(bytes.NewReader(EncodeRTU(1, []byte{0x03, 0x00, 0x00, 0x00, 0x01})), true); err == nil {
+		t.Fatal("不支持的功能码应返回错误")
+	}
+}
+
+func TestException(t *testing.T) {
+	var err error = &Exception{Function: FuncReadCoils, Code: ExceptionIllegalAddress}
+	var exception *Exception
+	if !errors.As(err, &exception) || exception.Code != ExceptionIllegalAddress {
+		t.Fatalf("得到 %v", err)
+	}
+	if err.Error() != "功能码 0x01 异常 0x02: 非法数据地址" {
+		t.Fatalf("得到 %s", err)
+	}
+	if message := (&Exception{Function: 0x05, Code: 0x0B}).Error(); !strings.Contains(message, "未知异常") {
+		t.Fatalf("得到 %s", message)
+	}
+}
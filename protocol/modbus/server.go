@@ -0,0 +1,50 @@
+package modbus
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Handler 处理从站收到的请求 PDU,返回响应 PDU,返回 nil 时不响应
+type Handler func(unit byte, pdu []byte) []byte
+
+// Serve 作为从站处理连接上的请求,直到读取出错,用于模拟 Modbus 设备
+func Serve(conn io.ReadWriter, mode Mode, handle Handler) error {
+	for {
+		var (
+			transaction uint16
+			unit        byte
+			pdu         []byte
+			err         error
+		)
+		switch mode {
+		case ModeTCP:
+			transaction, unit, pdu, err = ReadTCP(conn)
+		case ModeRTU, ModeRTUTCP:
+			unit, pdu, err = ReadRTU(conn, true)
+		default:
+			return errors.New(fmt.Sprintf("不支持的通信方式 %s", mode))
+		}
+		if err != nil {
+			return err
+		}
+		response := handle(unit, pdu)
+		if response == nil {
+			continue
+		}
+		if mode == ModeTCP {
+			_, err = conn.Write(EncodeTCP(transaction, unit, response))
+		} else {
+			_, err = conn.Write(EncodeRTU(unit, response))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ExceptionResponse 生成异常响应 PDU
+func ExceptionResponse(function, code byte) []byte {
+	return []byte{function | 0x80, code}
+}
@@ -0,0 +1,384 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/zing-dev/atian-tools/log"
+	"github.com/zing-dev/atian-tools/source/atian/dts"
+	"github.com/zing-dev/atian-tools/source/device"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultQueueSize 每个客户端默认的发送队列大小
+	DefaultQueueSize = 64
+	// DefaultWriteTimeout 默认的单条消息写超时
+	DefaultWriteTimeout = time.Second * 10
+
+	pingPeriod = time.Second * 30
+	readLimit  = 4096
+)
+
+type (
+	// Filter 客户端的订阅,各条件均为空时接收所有消息
+	// 消息不包含的条件不过滤,如设备状态不按通道过滤,防区温度和报警按通道和防区裁剪后发送
+	Filter struct {
+		Types    []Type   `json:"types,omitempty"`    //消息类型
+		Hosts    []string `json:"hosts,omitempty"`    //DTS 主机地址,设备状态为设备 Id
+		Channels []int32  `json:"channels,omitempty"` //通道
+		Zones    []uint   `json:"zones,omitempty"`    //防区 Id
+	}
+
+	// Message 推送的消息
+	Message struct {
+		Type     Type
+		Host     string                      //所属主机,为空时不按主机过滤
+		Channels []int32                     //涉及的通道,为空时不按通道过滤
+		Zones    dts.Zones                   //涉及的防区,订阅了通道或防区时按订阅裁剪
+		Data     interface{}                 //推送的数据
+		Trim     func(dts.Zones) interface{} //根据裁剪后的防区生成推送的数据,为空时不裁剪
+	}
+
+	// Source DTS 数据来源,*dts.App,*dts.Fleet 和 *dts.ManagerSource 均实现该接口
+	Source interface {
+		Subscribe(t dts.CallType, size int, policy dts.DropPolicy) *dts.Subscription
+	}
+
+	// HubConfig 推送服务配置
+	HubConfig struct {
+		QueueSize    int                      //每个客户端的发送队列大小,已满时断开该客户端,默认 DefaultQueueSize
+		WriteTimeout time.Duration            //单条消息的写超时,默认 DefaultWriteTimeout
+		CheckOrigin  func(*http.Request) bool //校验请求来源,为空时只允许同源请求
+	}
+
+	// Hub 按客户端订阅推送消息的 websocket 服务,每个客户端有独立的发送队列,发送过慢的客户端被断开
+	Hub struct {
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		evicted  uint64 //因发送队列已满断开的客户端数
+		config   HubConfig
+		upgrader websocket.Upgrader
+		clients  map[*Client]struct{}
+		locker   sync.RWMutex
+	}
+
+	// Client 推送服务的客户端
+	Client struct {
+		hub    *Hub
+		conn   *websocket.Conn
+		filter Filter
+		queue  chan []byte
+		done   chan struct{}
+		once   sync.Once
+		locker sync.Mutex
+	}
+)
+
+// NewHub 实例化推送服务,上级 ctx 结束时断开所有客户端
+func NewHub(ctx context.Context, config HubConfig) *Hub {
+	ctx, cancel := context.WithCancel(ctx)
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultQueueSize
+	}
+	if config.WriteTimeout <= 0 {
+		config.WriteTimeout = DefaultWriteTimeout
+	}
+	h := &Hub{
+		ctx:      ctx,
+		cancel:   cancel,
+		config:   config,
+		upgrader: websocket.Upgrader{CheckOrigin: config.CheckOrigin},
+		clients:  map[*Client]struct{}{},
+	}
+	go func() {
+		<-h.ctx.Done()
+		h.Close()
+	}()
+	return h
+}
+
+// ServeHTTP 升级为 websocket 连接,客户端发送 Filter 的 json 替换订阅,订阅成功后收到 TypeSubscribe 的确认
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.ctx.Err() != nil {
+		http.Error(w, "推送服务已经关闭", http.StatusServiceUnavailable)
+		return
+	}
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.L.Error(fmt.Sprintf("websocket 连接失败: %s", err))
+		return
+	}
+	c := &Client{
+		hub:   h,
+		conn:  conn,
+		queue: make(chan []byte, h.config.QueueSize),
+		done:  make(chan struct{}),
+	}
+	h.locker.Lock()
+	h.clients[c] = struct{}{}
+	h.locker.Unlock()
+	go c.write()
+	go c.read()
+}
+
+// Publish 按订阅推送消息,发送队列已满的客户端被断开
+func (h *Hub) Publish(message Message) {
+	var body []byte //未裁剪的消息只编码一次
+	h.locker.RLock()
+	defer h.locker.RUnlock()
+	for c := range h.clients {
+		zones, ok := c.Filter().match(message)
+		if !ok {
+			continue
+		}
+		var payload []byte
+		if zones != nil {
+			payload = encode(message.Type, message.Trim(zones))
+		} else {
+			if body == nil {
+				body = encode(message.Type, message.Data)
+			}
+			payload = body
+		}
+		if payload == nil {
+			continue
+		}
+		c.send(payload)
+	}
+}
+
+// Feed 将 DTS 的报警,温度,信号,光纤事件和主机状态推送给客户端,阻塞直到 ctx 结束或来源关闭
+// 订阅使用 DropOldest,推送过慢时丢弃最旧的数据,不阻塞 DTS 数据的处理
+func (h *Hub) Feed(ctx context.Context, source Source) {
+	types := []dts.CallType{dts.CallAlarm, dts.CallTemp, dts.CallSignal, dts.CallEvent, dts.CallStatus}
+	subscriptions := make([]*dts.Subscription, len(types))
+	for k, t := range types {
+		subscriptions[k] = source.Subscribe(t, h.config.QueueSize, dts.DropOldest)
+		defer subscriptions[k].Close()
+	}
+	alarm, temp, signal, event, status := subscriptions[0].C, subscriptions[1].C, subscriptions[2].C, subscriptions[3].C, subscriptions[4].C
+	for {
+		var (
+			value interface{}
+			ok    bool
+		)
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.ctx.Done():
+			return
+		case value, ok = <-alarm:
+		case value, ok = <-temp:
+		case value, ok = <-signal:
+		case value, ok = <-event:
+		case value, ok = <-status:
+		}
+		if !ok {
+			return
+		}
+		if message, ok := NewMessage(value); ok {
+			h.Publish(message)
+		}
+	}
+}
+
+// Watch 将设备管理器中设备的状态变化推送给客户端,返回取消推送的函数
+func (h *Hub) Watch(manger *device.Manger) device.Unregister {
+	return manger.Subscribe(device.EventStatus, func(event device.Event) {
+		id := event.Device.GetId()
+		h.Publish(Message{
+			Type: TypeDevice,
+			Host: id,
+			Data: device.Status{Id: id, Type: event.Device.GetType(), Status: event.Status},
+		})
+	})
+}
+
+// Clients 获取当前的客户端数
+func (h *Hub) Clients() int {
+	h.locker.RLock()
+	defer h.locker.RUnlock()
+	return len(h.clients)
+}
+
+// Evicted 获取因发送队列已满断开的客户端数
+func (h *Hub) Evicted() uint64 {
+	return atomic.LoadUint64(&h.evicted)
+}
+
+// Close 关闭推送服务,断开所有客户端
+func (h *Hub) Close() {
+	h.cancel()
+	h.locker.RLock()
+	clients := make([]*Client, 0, len(h.clients))
+	for c := range h.clients {
+		clients = append(clients, c)
+	}
+	h.locker.RUnlock()
+	for _, c := range clients {
+		c.close()
+	}
+}
+
+func (h *Hub) remove(c *Client) {
+	h.locker.Lock()
+	defer h.locker.Unlock()
+	delete(h.clients, c)
+}
+
+// NewMessage 根据 DTS 的订阅数据生成推送的消息,不支持的数据类型返回 false
+func NewMessage(value interface{}) (Message, bool) {
+	switch v := value.(type) {
+	case dts.ZonesAlarm:
+		return Message{Type: TypeAlarm, Host: v.Host, Zones: v.Zones, Data: v, Trim: func(zones dts.Zones) interface{} {
+			trimmed := v
+			trimmed.Zones = zones
+			return trimmed
+		}}, true
+	case dts.ZonesTemp:
+		return Message{Type: TypeTemp, Host: v.Host, Zones: v.Zones, Data: v, Trim: func(zones dts.Zones) interface{} {
+			trimmed := v
+			trimmed.Zones = zones
+			return trimmed
+		}}, true
+	case dts.ChannelSignal:
+		return Message{Type: TypeChannelSign, Host: v.Host, Channels: []int32{v.ChannelId}, Data: v}, true
+	case dts.ChannelEvent:
+		return Message{Type: TypeEvent, Host: v.Host, Channels: []int32{v.ChannelId}, Data: v}, true
+	case dts.HostStatus:
+		return Message{Type: TypeDevice, Host: v.DTS.Host, Data: v}, true
+	default:
+		return Message{}, false
+	}
+}
+
+// match 判断消息是否符合订阅,需要裁剪时返回裁剪后的防区,裁剪后为空时不符合
+func (f Filter) match(message Message) (dts.Zones, bool) {
+	if len(f.Types) > 0 && !contains(len(f.Types), func(i int) bool { return f.Types[i] == message.Type }) {
+		return nil, false
+	}
+	if len(f.Hosts) > 0 && message.Host != "" && !contains(len(f.Hosts), func(i int) bool { return f.Hosts[i] == message.Host }) {
+		return nil, false
+	}
+	if len(f.Channels) > 0 && len(message.Channels) > 0 && !contains(len(message.Channels), func(i int) bool { return f.channel(message.Channels[i]) }) {
+		return nil, false
+	}
+	if len(message.Zones) == 0 || message.Trim == nil || (len(f.Channels) == 0 && len(f.Zones) == 0) {
+		return nil, true
+	}
+	zones := make(dts.Zones, 0, len(message.Zones))
+	for _, zone := range message.Zones {
+		if len(f.Channels) > 0 && !f.channel(int32(zone.ChannelId)) {
+			continue
+		}
+		if len(f.Zones) > 0 && !contains(len(f.Zones), func(i int) bool { return f.Zones[i] == zone.Id }) {
+			continue
+		}
+		zones = append(zones, zone)
+	}
+	if len(zones) == 0 {
+		return nil, false
+	}
+	return zones, true
+}
+
+func (f Filter) channel(channel int32) bool {
+	return contains(len(f.Channels), func(i int) bool { return f.Channels[i] == channel })
+}
+
+func contains(n int, equal func(int) bool) bool {
+	for i := 0; i < n; i++ {
+		if equal(i) {
+			return true
+		}
+	}
+	return false
+}
+
+func encode(t Type, data interface{}) []byte {
+	body, err := json.Marshal(Response{Success: true, Type: t, Data: data})
+	if err != nil {
+		log.L.Error(fmt.Sprintf("编码推送消息失败: %s", err))
+		return nil
+	}
+	return body
+}
+
+// Filter 获取客户端当前的订阅
+func (c *Client) Filter() Filter {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	return c.filter
+}
+
+// send 加入发送队列,队列已满时断开客户端
+func (c *Client) send(body []byte) {
+	select {
+	case <-c.done:
+	case c.queue <- body:
+	default:
+		atomic.AddUint64(&c.hub.evicted, 1)
+		log.L.Warn(fmt.Sprintf("websocket 客户端 %s 发送过慢, 断开连接", c.conn.RemoteAddr()))
+		c.close()
+	}
+}
+
+// read 读取客户端的订阅,连接断开时关闭客户端
+func (c *Client) read() {
+	defer c.close()
+	c.conn.SetReadLimit(readLimit)
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var filter Filter
+		if err = json.Unmarshal(data, &filter); err != nil {
+			body, _ := json.Marshal(Response{Success: false, Type: TypeSubscribe, Data: fmt.Sprintf("解析订阅失败: %s", err)})
+			c.send(body)
+			continue
+		}
+		c.locker.Lock()
+		c.filter = filter
+		c.locker.Unlock()
+		c.send(encode(TypeSubscribe, filter))
+	}
+}
+
+// write 发送队列中的消息并定时发送心跳
+func (c *Client) write() {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	defer c.close()
+	timeout := c.hub.config.WriteTimeout
+	for {
+		select {
+		case <-c.done:
+			return
+		case body := <-c.queue:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(timeout))
+			if err := c.conn.WriteMessage(websocket.TextMessage, body); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(timeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// close 断开连接并从推送服务中删除
+func (c *Client) close() {
+	c.once.Do(func() {
+		close(c.done)
+		_ = c.conn.Close()
+		go c.hub.remove(c)
+	})
+}
@@ -0,0 +1,223 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/zing-dev/atian-tools/source/atian/dts"
+	"github.com/zing-dev/atian-tools/source/device"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func zone(id uint, channel byte) *dts.Zone {
+	return &dts.Zone{BaseZone: dts.BaseZone{Id: id, ChannelId: channel, Host: "h1"}}
+}
+
+func ids(zones dts.Zones) []uint {
+	result := make([]uint, 0, len(zones))
+	for _, zone := range zones {
+		result = append(result, zone.Id)
+	}
+	return result
+}
+
+func TestFilterMatch(t *testing.T) {
+	temp, _ := NewMessage(dts.ZonesTemp{Host: "h1", Zones: dts.Zones{zone(1, 1), zone(2, 1), zone(3, 2)}})
+	signal, _ := NewMessage(dts.ChannelSignal{Host: "h1", ChannelId: 2})
+	status, _ := NewMessage(dts.HostStatus{DTS: dts.DTS{Host: "h2"}, Status: device.Connected})
+	log := Message{Type: TypeLog, Data: "日志"}
+	tests := []struct {
+		name    string
+		filter  Filter
+		message Message
+		match   bool
+		zones   []uint //裁剪后的防区,为空时不裁剪
+	}{
+		{name: "空订阅", filter: Filter{}, message: temp, match: true},
+		{name: "类型匹配", filter: Filter{Types: []Type{TypeTemp, TypeAlarm}}, message: temp, match: true},
+		{name: "类型不匹配", filter: Filter{Types: []Type{TypeAlarm}}, message: temp},
+		{name: "主机匹配", filter: Filter{Hosts: []string{"h1"}}, message: temp, match: true},
+		{name: "主机不匹配", filter: Filter{Hosts: []string{"h1"}}, message: status},
+		{name: "无主机的消息不按主机过滤", filter: Filter{Hosts: []string{"h1"}}, message: log, match: true},
+		{name: "通道匹配", filter: Filter{Channels: []int32{2}}, message: signal, match: true},
+		{name: "通道不匹配", filter: Filter{Channels: []int32{1}}, message: signal},
+		{name: "设备状态不按通道过滤", filter: Filter{Channels: []int32{1}}, message: status, match: true},
+		{name: "按通道裁剪", filter: Filter{Channels: []int32{1}}, message: temp, match: true, zones: []uint{1, 2}},
+		{name: "按防区裁剪", filter: Filter{Zones: []uint{2, 3}}, message: temp, match: true, zones: []uint{2, 3}},
+		{name: "按通道和防区裁剪", filter: Filter{Channels: []int32{1}, Zones: []uint{2, 3}}, message: temp, match: true, zones: []uint{2}},
+		{name: "裁剪后为空", filter: Filter{Zones: []uint{4}}, message: temp},
+		{name: "信号不按防区过滤", filter: Filter{Zones: []uint{4}}, message: signal, match: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			zones, ok := test.filter.match(test.message)
+			if ok != test.match {
+				t.Fatalf("匹配 %v, 期望 %v", ok, test.match)
+			}
+			if test.zones == nil {
+				if zones != nil {
+					t.Fatalf("不应裁剪, 得到 %v", ids(zones))
+				}
+				return
+			}
+			if !reflect.DeepEqual(ids(zones), test.zones) {
+				t.Fatalf("裁剪为 %v, 期望 %v", ids(zones), test.zones)
+			}
+		})
+	}
+}
+
+func TestNewMessage(t *testing.T) {
+	alarm := dts.ZonesAlarm{Host: "h1", DeviceId: "d", Zones: dts.Zones{zone(1, 1), zone(2, 2)}}
+	tests := []struct {
+		value    interface{}
+		t        Type
+		host     string
+		channels []int32
+		zones    int
+	}{
+		{value: alarm, t: TypeAlarm, host: "h1", zones: 2},
+		{value: dts.ZonesTemp{Host: "h1", Zones: dts.Zones{zone(1, 1)}}, t: TypeTemp, host: "h1", zones: 1},
+		{value: dts.ChannelSignal{Host: "h1", ChannelId: 3}, t: TypeChannelSign, host: "h1", channels: []int32{3}},
+		{value: dts.ChannelEvent{Host: "h1", ChannelId: 4}, t: TypeEvent, host: "h1", channels: []int32{4}},
+		{value: dts.HostStatus{DTS: dts.DTS{Host: "h2"}}, t: TypeDevice, host: "h2"},
+	}
+	for _, test := range tests {
+		message, ok := NewMessage(test.value)
+		if !ok || message.Type != test.t || message.Host != test.host ||
+			!reflect.DeepEqual(message.Channels, test.channels) || len(message.Zones) != test.zones {
+			t.Fatalf("%T 得到 %+v", test.value, message)
+		}
+	}
+	if _, ok := NewMessage("unknown"); ok {
+		t.Fatal("不支持的数据类型应返回 false")
+	}
+	//裁剪不修改原数据
+	message, _ := NewMessage(alarm)
+	trimmed := message.Trim(dts.Zones{alarm.Zones[1]}).(dts.ZonesAlarm)
+	if len(trimmed.Zones) != 1 || trimmed.Zones[0].Id != 2 || trimmed.DeviceId != "d" || len(alarm.Zones) != 2 {
+		t.Fatalf("得到 %+v", trimmed)
+	}
+}
+
+func dial(t *testing.T, server *httptest.Server) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// next 读取下一条消息
+func next(t *testing.T, conn *websocket.Conn) (Type, json.RawMessage) {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+	var response struct {
+		Success bool            `json:"success"`
+		Type    Type            `json:"type"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := conn.ReadJSON(&response); err != nil {
+		t.Fatal(err)
+	}
+	return response.Type, response.Data
+}
+
+func waitFor(t *testing.T, message string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 5)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+// TestHubSubscribe 客户端订阅后按订阅接收裁剪后的消息
+func TestHubSubscribe(t *testing.T) {
+	hub := NewHub(context.Background(), HubConfig{})
+	defer hub.Close()
+	server := httptest.NewServer(hub)
+	defer server.Close()
+	conn := dial(t, server)
+	defer conn.Close()
+	waitFor(t, "客户端未连接", func() bool {
+		return hub.Clients() == 1
+	})
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"types":[3],"zones":[2]}`)); err != nil {
+		t.Fatal(err)
+	}
+	if typ, _ := next(t, conn); typ != TypeSubscribe {
+		t.Fatalf("期望订阅确认, 得到 %d", typ)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"types":`)); err != nil {
+		t.Fatal(err)
+	}
+	if typ, _ := next(t, conn); typ != TypeSubscribe {
+		t.Fatalf("期望订阅失败的回复, 得到 %d", typ)
+	}
+
+	signal, _ := NewMessage(dts.ChannelSignal{Host: "h1", ChannelId: 1})
+	hub.Publish(signal)
+	temp, _ := NewMessage(dts.ZonesTemp{Host: "h1", Zones: dts.Zones{zone(1, 1), zone(2, 1)}})
+	hub.Publish(temp)
+	typ, data := next(t, conn)
+	if typ != TypeTemp {
+		t.Fatalf("未订阅的类型不应推送, 得到 %d", typ)
+	}
+	var received dts.ZonesTemp
+	if err := json.Unmarshal(data, &received); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids(received.Zones), []uint{2}) {
+		t.Fatalf("得到防区 %v", ids(received.Zones))
+	}
+
+	conn.Close()
+	waitFor(t, "断开的客户端未删除", func() bool {
+		return hub.Clients() == 0
+	})
+}
+
+// TestHubEvict 不读取消息的客户端在发送队列已满时被断开,不影响其他客户端
+func TestHubEvict(t *testing.T) {
+	hub := NewHub(context.Background(), HubConfig{QueueSize: 1, WriteTimeout: time.Second * 10})
+	defer hub.Close()
+	server := httptest.NewServer(hub)
+	defer server.Close()
+	slow := dial(t, server)
+	defer slow.Close()
+	waitFor(t, "客户端未连接", func() bool {
+		return hub.Clients() == 1
+	})
+
+	//不读取的客户端填满连接的缓冲后发送阻塞,之后的消息使发送队列已满
+	message := Message{Type: TypeLog, Data: strings.Repeat("x", 1<<20)}
+	for i := 0; i < 256 && hub.Evicted() == 0; i++ {
+		hub.Publish(message)
+	}
+	if hub.Evicted() != 1 {
+		t.Fatalf("断开 %d 个客户端, 期望 1 个", hub.Evicted())
+	}
+	waitFor(t, "被断开的客户端未删除", func() bool {
+		return hub.Clients() == 0
+	})
+
+	//被断开后其他客户端正常接收
+	conn := dial(t, server)
+	defer conn.Close()
+	waitFor(t, "客户端未连接", func() bool {
+		return hub.Clients() == 1
+	})
+	hub.Publish(Message{Type: TypeLog, Data: "日志"})
+	if typ, _ := next(t, conn); typ != TypeLog {
+		t.Fatalf("得到 %d", typ)
+	}
+}
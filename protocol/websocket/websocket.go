@@ -6,6 +6,7 @@ import (
 	"github.com/kataras/neffos"
 	"github.com/zing-dev/atian-tools/source/device"
 	"log"
+	"strconv"
 )
 
 const (
@@ -16,6 +17,7 @@ const (
 	TypeChannelSign
 	TypeEvent
 	TypeDevice
+	TypeSubscribe //订阅确认
 )
 
 type Type byte
@@ -34,13 +36,20 @@ func (t *Type) String() string {
 		return "通道光纤事件"
 	case TypeDevice:
 		return "设备"
+	case TypeSubscribe:
+		return "订阅"
 	default:
 		return "未知"
 	}
 }
 
+// MarshalJSON 编码为数字,Type 的底层类型为 byte,[]Type 默认会编码为 base64
+func (t Type) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Itoa(int(t))), nil
+}
+
 func GetWebsocketTypeMap() []device.Constant {
-	t := []Type{TypeLog, TypeAlarm, TypeTemp, TypeChannelSign, TypeEvent, TypeDevice}
+	t := []Type{TypeLog, TypeAlarm, TypeTemp, TypeChannelSign, TypeEvent, TypeDevice, TypeSubscribe}
 	constant := make([]device.Constant, len(t))
 	for i, state := range t {
 		constant[i] = device.Constant{Name: state.String(), Value: byte(state)}
@@ -4,12 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"github.com/Atian-OE/DTSSDK_Golang/dtssdk"
 	"github.com/Atian-OE/DTSSDK_Golang/dtssdk/model"
 	"github.com/robfig/cron/v3"
 	"github.com/sirupsen/logrus"
 	"github.com/zing-dev/atian-tools/log"
 	"github.com/zing-dev/atian-tools/source/device"
+	"sort"
 	"sync"
 	"time"
 )
@@ -19,8 +19,17 @@ const (
 	CallTemp                   //注册温度更新回调
 	CallSignal                 //注册光纤通道信号回调
 	CallEvent                  //注册光纤事件回调
+
+	CallAlarmEvent //报警生命周期事件,由报警和温度更新产生,只能订阅,无需注册
+	CallZoneChange //防区变化事件,由防区同步产生,只能订阅,无需注册
+	CallProfile    //由通道信号计算的防区温度 ZonesTemp,需注册 CallSignal 并启用 Config.Profile
+	CallHotSpot    //通道信号中的热点事件 HotSpotEvent,需注册 CallSignal 并启用 WithHotSpot
+	CallStatus     //主机状态变化 HostStatus,只能订阅,无需注册
 )
 
+// CronSyncZones 定时同步防区的任务在 CronIds 中的键
+const CronSyncZones byte = 0xff
+
 type CallType byte //回调类型
 
 // Config DTS设备的配置
@@ -31,15 +40,28 @@ type Config struct {
 	ZonesAlarmSec byte   //防区温度间隔 秒
 	ZonesTempSec  uint16 //报警温度间隔 秒
 	ChanSignSec   uint16 //通道信号间隔 秒
+	AlarmClearSec uint16 //报警恢复时间 秒,最近一次报警后超过该时间且温度正常则视为恢复
+	ZonesSyncSec  uint16 //防区定时同步间隔 秒,0 为不定时同步
+	Profile       bool   //是否根据通道信号计算防区温度
+	HistorySec    uint32 //防区温度历史保留时间 秒,与 HistorySize 均为 0 时不记录历史
+	HistorySize   uint16 //每个防区保留的最多温度数,0 为 DefaultHistorySize
+}
+
+// HostStatus 主机状态变化
+type HostStatus struct {
+	DTS    DTS               `json:"dts"`
+	Status device.StatusType `json:"status"`
+	At     *device.TimeLocal `json:"at"`
 }
 
 type App struct {
 	Context context.Context
 	cancel  context.CancelFunc
 
-	Client *dtssdk.Client
-	config *Config
-	DTS    DTS
+	Client    Client
+	newClient ClientFactory
+	config    *Config
+	DTS       DTS
 
 	CallTypes []CallType
 
@@ -49,6 +71,10 @@ type App struct {
 	status     device.StatusType
 	ChanStatus chan device.StatusType
 
+	bus       *Bus          //数据订阅总线
+	auto      bool          //是否自动注册回调和同步防区
+	connected chan struct{} //连接成功的信号,自动模式使用
+
 	ChanMessage       chan device.Message
 	ChanZonesTemp     chan ZonesTemp
 	ChanChannelSignal chan ChannelSignal
@@ -56,15 +82,33 @@ type App struct {
 	ChanZonesAlarm    chan ZonesAlarm
 	Zones             map[uint]*Zone
 
+	AlarmLimiter       *AlarmLimiter            //防区报警限流
+	Tracker            *AlarmTracker            //防区报警跟踪
+	History            *History                 //防区温度历史
+	Index              *Index                   //防区坐标索引
+	schema             *Schema                  //防区标签模式
+	tagErrors          map[byte][]*ZoneTagError //每个通道最近一次同步的防区标签错误
+	lastTemp           *ZonesTemp               //最近一次的防区温度
+	lastSignals        map[int32]ChannelSignal  //每个通道最近一次的信号
+	HotSpots           *HotSpotDetector         //热点检测,为空时不检测
 	ZonesChannelSignal sync.Map
 	ZonesTemp          sync.Map
 	ZonesAlarm         sync.Map
 	locker             sync.Mutex
+	syncLocker         sync.Mutex //防区同步锁,保证同一时间只有一个同步
+
+	gate          sync.RWMutex   //关闭门闩,保护 closing 和 destroyed
+	closing       bool           //正在关闭,不再处理回调
+	destroyed     bool           //数据通道已关闭,不再发送
+	closeOnce     sync.Once      //保证只关闭一次
+	lifecycleOnce sync.Once      //保证自动模式的生命周期协程只启动一次
+	producers     sync.WaitGroup //正在处理的回调
+	workers       sync.WaitGroup //后台协程,如自动模式的生命周期
 }
 
-func New(ctx context.Context, dts DTS, config *Config) *App {
+func New(ctx context.Context, dts DTS, config *Config, options ...Option) *App {
 	ctx, cancel := context.WithCancel(ctx)
-	return &App{
+	app := &App{
 		Context:     ctx,
 		cancel:      cancel,
 		config:      config,
@@ -72,10 +116,46 @@ func New(ctx context.Context, dts DTS, config *Config) *App {
 		status:      device.UnConnect,
 		ChanMessage: make(chan device.Message, 0),
 		ChanStatus:  make(chan device.StatusType, 0),
+
+		ChanZonesAlarm:    make(chan ZonesAlarm, 10),
+		ChanZonesTemp:     make(chan ZonesTemp, 30),
+		ChanChannelSignal: make(chan ChannelSignal, 30),
+		ChanChannelEvent:  make(chan ChannelEvent, 10),
+
 		Zones:       map[uint]*Zone{},
 		CronIds:     map[byte]cron.EntryID{},
 		locker:      sync.Mutex{},
+		newClient:   NewDTSClient,
+		schema:      DefaultSchema(),
+		tagErrors:   map[byte][]*ZoneTagError{},
+		lastSignals: map[int32]ChannelSignal{},
+		connected:   make(chan struct{}, 1),
+		bus:         NewBus(),
+	}
+	app.AlarmLimiter = NewAlarmLimiter(func() time.Duration {
+		return time.Second * time.Duration(app.GetConfig().ZonesAlarmSec)
+	})
+	app.Tracker = NewAlarmTracker(dts, func() time.Duration {
+		if sec := app.GetConfig().AlarmClearSec; sec > 0 {
+			return time.Second * time.Duration(sec)
+		}
+		return time.Minute
+	})
+	app.Index = NewIndex()
+	app.History = NewHistory(func() time.Duration {
+		return time.Second * time.Duration(app.GetConfig().HistorySec)
+	}, func() int {
+		return int(app.GetConfig().HistorySize)
+	})
+	for _, option := range options {
+		option(app)
 	}
+	//上级 ctx 结束时自动关闭
+	go func() {
+		<-app.Context.Done()
+		_ = app.Close()
+	}()
+	return app
 }
 
 func (a *App) GetId() string {
@@ -91,6 +171,9 @@ func (a *App) GetStatus() device.StatusType {
 }
 
 func (a *App) Run() error {
+	if a.isClosing() {
+		return errors.New(fmt.Sprintf("设备 %s 已经关闭", a.DTS.Host))
+	}
 	status := a.GetStatus()
 	if status == device.Connecting || status == device.Connected {
 		return errors.New(fmt.Sprintf("设备 %s 已经正在运行中", a.DTS.Host))
@@ -98,312 +181,470 @@ func (a *App) Run() error {
 	if len(a.CallTypes) == 0 {
 		a.CallTypes = []CallType{CallAlarm, CallTemp}
 	}
-	a.Client = dtssdk.NewDTSClient(a.DTS.Host)
+	//再次运行时关闭之前的客户端,WithClient 每次返回同一个客户端时继续使用
+	client := a.newClient(a.DTS.Host)
+	if a.Client != nil && a.Client != client {
+		a.Client.Close()
+	}
+	a.Client = client
 	a.setStatus(device.Connecting)
 	a.Client.CallConnected(func(s string) {
+		if !a.enter() {
+			return
+		}
+		defer a.leave()
 		a.setStatus(device.Connected)
 		a.setMessage(fmt.Sprintf("主机为 %s 的dts连接成功", s), logrus.InfoLevel)
+		if a.auto {
+			select {
+			case a.connected <- struct{}{}:
+			default:
+			}
+		}
 	})
 
 	a.Client.OnTimeout(func(s string) {
+		if !a.enter() {
+			return
+		}
+		defer a.leave()
 		a.setMessage(fmt.Sprintf("主机为 %s 的dts连接超时", s), logrus.WarnLevel)
 		a.setStatus(device.Connecting)
 	})
 
 	a.Client.CallDisconnected(func(s string) {
+		if !a.enter() {
+			return
+		}
+		defer a.leave()
 		a.setMessage(fmt.Sprintf("主机为 %s 的dts断开连接", s), logrus.WarnLevel)
 		a.setStatus(device.Disconnect)
 	})
+	//生命周期协程只启动一次,再次运行时继续等待新客户端的连接信号
+	if a.auto {
+		a.lifecycleOnce.Do(func() {
+			a.workers.Add(1)
+			go func() {
+				defer a.workers.Done()
+				a.lifecycle()
+			}()
+		})
+	}
+	if sec := a.GetConfig().ZonesSyncSec; sec > 0 && a.Cron != nil {
+		if id, ok := a.CronIds[CronSyncZones]; ok {
+			a.Cron.Remove(id)
+		}
+		id, err := a.Cron.AddFunc(fmt.Sprintf("@every %ds", sec), func() {
+			if !a.enter() {
+				return
+			}
+			defer a.leave()
+			if a.Status() != device.Connected {
+				return
+			}
+			_ = a.SyncZones()
+		})
+		if err != nil {
+			return err
+		}
+		a.CronIds[CronSyncZones] = id
+	}
 	return nil
 }
 
 // Register 在连接成功后回调该函数!!!
-func (a *App) Register() (err error) {
-	err = errors.New("")
-	start := time.Now()
-START:
-	for err != nil {
-		if time.Now().Sub(start) > time.Minute {
-			a.setMessage(fmt.Sprintf("主机为 %s 的 dts 回调数据失败", a.DTS.Host), logrus.ErrorLevel)
-			break
-		}
-		for _, t := range a.CallTypes {
-			switch t {
-			case CallAlarm: //防区报警
-				a.ChanZonesAlarm = make(chan ZonesAlarm, 10)
-				err = a.Client.CallZoneAlarmNotify(func(notify *model.ZoneAlarmNotify, err error) {
-					var (
-						index = 0                                                  //报警防区索引,最后一个索引即为当前报警防区的长度
-						zones = make([]*model.DefenceZone, len(notify.GetZones())) //实际可用的报警防区
-					)
-					for _, zone := range notify.GetZones() {
-						//若当前报警防区已存在缓存中,且当前报警时间与上一个报警时间的间隔小于报警阈值,则不处理
-						value, ok := a.ZonesTemp.LoadOrStore(fmt.Sprintf("%s-%d", notify.GetDeviceID(), Id(a.DTS.Id, uint(zone.ID))), notify.GetTimestamp())
-						if ok && time.Now().Sub(time.Unix(value.(int64)/1000, 0)) < time.Second*time.Duration(a.GetConfig().ZonesAlarmSec) {
-							return
-						}
-						//缓存当前报警防区
-						a.ZonesTemp.Store(fmt.Sprintf("%s-%d", notify.GetDeviceID(), Id(a.DTS.Id, uint(zone.ID))), notify.GetTimestamp())
-						zones[index] = zone
-						index++ // 可用,索引递增
-					}
-
-					//当索引为0时则表明无可用的报警防区
-					if index == 0 {
-						a.setMessage(fmt.Sprintf("主机为 %s 的dts更新防区温度数量为空!", a.DTS.Host), logrus.ErrorLevel)
-						return
-					}
-					//当防区数量大于10时,进行防区的温度校验
-					if index > 10 {
-						divide := index / 5
-						if zones[0].AverageTemperature == 0 && zones[divide*1].AverageTemperature == 0 &&
-							zones[divide*2].AverageTemperature == 0 && zones[divide*3].AverageTemperature == 0 &&
-							zones[divide*4].AverageTemperature == 0 && zones[divide*5-1].AverageTemperature == 0 {
-							a.setMessage(fmt.Sprintf("主机为 %s 的dts更新防区温度异常!", a.DTS.Host), logrus.ErrorLevel)
-							return
-						}
-					}
-
-					//获取可用的防区
-					zones = zones[:index]
-
-					//对于可用的报警防区进行重新组装
-					alarms := make(Zones, len(zones))
-					for k, v := range zones {
-						id := Id(a.DTS.Id, uint(v.GetID()))
-						zone := a.GetZone(id)
-						if zone == nil {
-							a.setMessage(fmt.Sprintf("主机为 %s 的dts %d 防区未找到", a.DTS.Host, id), logrus.ErrorLevel)
-							continue
-						}
-						alarms[k] = zone
-						alarms[k].Temperature = &Temperature{
-							Max: v.GetMaxTemperature(),
-							Avg: v.GetAverageTemperature(),
-							Min: v.GetMinTemperature(),
-							At:  &device.TimeLocal{Time: time.Unix(notify.GetTimestamp()/1000, 0)},
-						}
-						alarms[k].Alarm = &Alarm{
-							At:       &device.TimeLocal{Time: time.Unix(notify.GetTimestamp()/1000, 0)},
-							Location: v.GetAlarmLoc(),
-							State:    v.GetAlarmType(), //报警类型
-						}
-					}
-					select {
-					case a.ChanZonesAlarm <- ZonesAlarm{
-						DTS:       a.DTS,
-						Zones:     alarms,
-						Host:      a.DTS.Host,
-						DeviceId:  notify.GetDeviceID(),
-						CreatedAt: &device.TimeLocal{Time: time.Unix(notify.GetTimestamp()/1000, 0)},
-					}:
-					default:
-						log.L.Warn(fmt.Sprintf("主机 %s 报警防区缓冲已满,忽略当前报警...", a.DTS.Host))
-					}
-				})
-				if err != nil {
-					a.setMessage(fmt.Sprintf("主机为 %s 的 dts 注册报警回调失败: %s", a.DTS.Host, err), logrus.ErrorLevel)
-					time.Sleep(time.Second * 3)
-					break START
-				} else {
-					a.setMessage(fmt.Sprintf("主机为 %s 的 dts 注册报警回调", a.DTS.Host), logrus.InfoLevel)
+// 注册 CallTypes 中的回调,只能订阅的类型无需注册而跳过,任一回调注册失败时返回错误,由调用者重试
+func (a *App) Register() error {
+	if err := a.Context.Err(); err != nil {
+		return err
+	}
+	for _, t := range a.CallTypes {
+		switch t {
+		case CallAlarm: //防区报警
+			err := a.Client.CallZoneAlarmNotify(func(notify *model.ZoneAlarmNotify, err error) {
+				if !a.enter() {
+					return
 				}
-			case CallTemp:
-				a.setMessage(fmt.Sprintf("主机为 %s 的 dts 注册温度更新回调", a.DTS.Host), logrus.InfoLevel)
-				a.ChanZonesTemp = make(chan ZonesTemp, 30)
-				err = a.Client.CallZoneTempNotify(func(notify *model.ZoneTempNotify, err error) {
-
-					//若当前温度更新防区已存在缓存中,且当前温度更新时间与上一个温度更新时间的间隔小于温度更新阈值,则不处理
-					value, ok := a.ZonesTemp.LoadOrStore(notify.GetDeviceID(), notify)
-					if ok && time.Now().Sub(time.Unix(value.(*model.ZoneTempNotify).GetTimestamp()/1000, 0)) < time.Second*time.Duration(a.GetConfig().ZonesTempSec) {
-						return
-					}
-					a.ZonesTemp.Store(notify.GetDeviceID(), notify)
-
-					var (
-						index = 0                                   //温度更新防区索引,最后一个索引即为当前温度更新防区的长度
-						zones = make(Zones, len(notify.GetZones())) //实际可用的温度更新防区
-					)
-					for _, zone := range notify.GetZones() {
-						//对温度校验,当最大温度,平均温度,最小温度均为0时,则当前温度更新防区无效
-						if zone.GetMaxTemperature() == 0 && zone.GetAverageTemperature() == 0 && zone.GetMinTemperature() == 0 {
-							continue
-						}
-						id := Id(a.DTS.Id, uint(zone.GetID()))
-						z := a.GetZone(id)
-						if z == nil {
-							a.setMessage(fmt.Sprintf("主机为 %s 的dts %d 防区未找到", a.DTS.Host, id), logrus.ErrorLevel)
-							continue
-						}
-
-						zones[index] = &Zone{
-							BaseZone: BaseZone{Id: id, ChannelId: z.ChannelId, Name: z.Name},
-							Temperature: &Temperature{
-								Max: zone.GetMaxTemperature(),
-								Avg: zone.GetAverageTemperature(),
-								Min: zone.GetMinTemperature(),
-							},
-						}
-						index++
-					}
-					zones = zones[:index]
-					if len(zones) == 0 {
-						a.setMessage(fmt.Sprintf("更新温度, 主机为 %s 的dts防区为空", a.DTS.Host), logrus.ErrorLevel)
-						return
-					}
-					select {
-					case a.ChanZonesTemp <- ZonesTemp{
-						DTS:       a.DTS,
-						Zones:     zones,
-						Host:      a.DTS.Host,
-						DeviceId:  notify.GetDeviceID(),
-						CreatedAt: &device.TimeLocal{Time: time.Unix(notify.GetTimestamp()/1000, 0)},
-					}:
-					default:
-						log.L.Warn(fmt.Sprintf("主机 %s 温度更新防区缓冲已满,忽略当前更新...", a.DTS.Host))
-					}
-				})
-				if err != nil {
-					a.setMessage(fmt.Sprintf("主机为 %s 的 dts 注册实时温度更新回调失败: %s", a.DTS.Host, err), logrus.ErrorLevel)
-					break START
-				} else {
-					a.setMessage(fmt.Sprintf("主机为 %s 的 dts 注册实时温度更新回调", a.DTS.Host), logrus.InfoLevel)
+				defer a.leave()
+				a.onZoneAlarm(notify)
+			})
+			if err != nil {
+				a.setMessage(fmt.Sprintf("主机为 %s 的 dts 注册报警回调失败: %s", a.DTS.Host, err), logrus.ErrorLevel)
+				return err
+			}
+			a.setMessage(fmt.Sprintf("主机为 %s 的 dts 注册报警回调", a.DTS.Host), logrus.InfoLevel)
+		case CallTemp:
+			a.setMessage(fmt.Sprintf("主机为 %s 的 dts 注册温度更新回调", a.DTS.Host), logrus.InfoLevel)
+			err := a.Client.CallZoneTempNotify(func(notify *model.ZoneTempNotify, err error) {
+				if !a.enter() {
+					return
 				}
-			case CallSignal:
-				a.setMessage(fmt.Sprintf("主机为 %s 的 dts 注册通道信号回调", a.DTS.Host), logrus.InfoLevel)
-				a.ChanChannelSignal = make(chan ChannelSignal, 30)
-				err = a.Client.CallTempSignalNotify(func(notify *model.TempSignalNotify, err error) {
-					value, ok := a.ZonesChannelSignal.LoadOrStore(fmt.Sprintf("%s-%d", notify.GetDeviceID(), notify.ChannelID), notify)
-					if ok && time.Now().Sub(time.Unix(value.(*model.TempSignalNotify).GetTimestamp()/1000, 0)) < time.Second*time.Duration(a.GetConfig().ChanSignSec) {
-						return
-					}
-					length := len(notify.GetSignal())
-					if length == 0 {
-						a.setMessage(fmt.Sprintf("主机为 %s 通道 %d 的dts信号数据为空!", a.DTS.Host, notify.GetChannelID()), logrus.ErrorLevel)
-						return
-					}
-					if length > 10 {
-						signal := notify.GetSignal()
-						divide := length / 5
-						if signal[0] == 0 && signal[divide*1] == 0 && signal[divide*2] == 0 && signal[divide*3] == 0 &&
-							signal[divide*4] == 0 && signal[divide*5-1] == 0 {
-							a.setMessage(fmt.Sprintf("主机为 %s 通道 %d 的dts信号异常!", a.DTS.Host, notify.GetChannelID()), logrus.ErrorLevel)
-							return
-						}
-					}
-
-					a.ZonesChannelSignal.Store(fmt.Sprintf("%s-%d", notify.GetDeviceID(), notify.ChannelID), notify)
-					signal := ChannelSignal{
-						DeviceId:   notify.GetDeviceID(),
-						ChannelId:  notify.GetChannelID(),
-						RealLength: notify.GetRealLength(),
-						Host:       a.DTS.Host,
-						Signal:     notify.GetSignal(),
-						CreatedAt:  &device.TimeLocal{Time: time.Unix(notify.GetTimestamp()/1000, 0)},
-					}
-					select {
-					case a.ChanChannelSignal <- signal:
-					default:
-						log.L.Warn(fmt.Sprintf("主机 %s 通道 %d 信号缓冲已满,忽略当前更新...", a.DTS.Host, signal.ChannelId))
-					}
-				})
-				if err != nil {
-					a.setMessage(fmt.Sprintf("主机为 %s 的 dts 注册信号回调失败: %s", a.DTS.Host, err), logrus.ErrorLevel)
-					break START
+				defer a.leave()
+				a.onZoneTemp(notify)
+			})
+			if err != nil {
+				a.setMessage(fmt.Sprintf("主机为 %s 的 dts 注册实时温度更新回调失败: %s", a.DTS.Host, err), logrus.ErrorLevel)
+				return err
+			}
+			a.setMessage(fmt.Sprintf("主机为 %s 的 dts 注册实时温度更新回调", a.DTS.Host), logrus.InfoLevel)
+		case CallSignal:
+			a.setMessage(fmt.Sprintf("主机为 %s 的 dts 注册通道信号回调", a.DTS.Host), logrus.InfoLevel)
+			err := a.Client.CallTempSignalNotify(func(notify *model.TempSignalNotify, err error) {
+				if !a.enter() {
+					return
 				}
-			case CallEvent:
-				a.setMessage(fmt.Sprintf("主机为 %s 的 dts 注册通道光纤事件回调", a.DTS.Host), logrus.InfoLevel)
-				a.ChanChannelEvent = make(chan ChannelEvent, 10)
-				err = a.Client.CallDeviceEventNotify(func(notify *model.DeviceEventNotify, err error) {
-					event := ChannelEvent{
-						DTS:           a.DTS,
-						DeviceId:      notify.GetDeviceID(),
-						ChannelId:     notify.GetChannelID(),
-						Host:          a.DTS.Host,
-						EventType:     notify.GetEventType(),
-						ChannelLength: notify.GetChannelLength(),
-						CreatedAt:     &device.TimeLocal{Time: time.Unix(notify.GetTimestamp()/1000, 0)},
-					}
-					select {
-					case a.ChanChannelEvent <- event:
-					default:
-					}
-				})
-				if err != nil {
-					a.setMessage(fmt.Sprintf("主机为 %s 的 dts 注册信号回调失败: %s", a.DTS.Host, err), logrus.ErrorLevel)
-					break START
+				defer a.leave()
+				a.onTempSignal(notify)
+			})
+			if err != nil {
+				a.setMessage(fmt.Sprintf("主机为 %s 的 dts 注册信号回调失败: %s", a.DTS.Host, err), logrus.ErrorLevel)
+				return err
+			}
+		case CallEvent:
+			a.setMessage(fmt.Sprintf("主机为 %s 的 dts 注册通道光纤事件回调", a.DTS.Host), logrus.InfoLevel)
+			err := a.Client.CallDeviceEventNotify(func(notify *model.DeviceEventNotify, err error) {
+				if !a.enter() {
+					return
 				}
+				defer a.leave()
+				a.onDeviceEvent(notify)
+			})
+			if err != nil {
+				a.setMessage(fmt.Sprintf("主机为 %s 的 dts 注册信号回调失败: %s", a.DTS.Host, err), logrus.ErrorLevel)
+				return err
 			}
 		}
 	}
-	return
+	return nil
 }
 
-func (a *App) SetCron(cron *cron.Cron) {
-	a.Cron = cron
+// ValidateCallTypes 校验回调类型,未知的类型返回错误
+func ValidateCallTypes(types []CallType) error {
+	for _, t := range types {
+		if t > CallStatus {
+			return errors.New(fmt.Sprintf("未知的回调类型 %d", t))
+		}
+	}
+	return nil
 }
 
-// Destroy 销毁通道
-func (a *App) Destroy() {
-	select {
-	case <-a.ChanStatus:
-	default:
-		if a.ChanStatus != nil {
-			close(a.ChanStatus)
-		}
+// onZoneAlarm 处理防区报警通知
+func (a *App) onZoneAlarm(notify *model.ZoneAlarmNotify) {
+	var (
+		at    = time.Unix(notify.GetTimestamp()/1000, 0)
+		zones = notify.GetZones()
+		index = len(zones)
+	)
+	if index == 0 {
+		a.setMessage(fmt.Sprintf("主机为 %s 的dts更新防区温度数量为空!", a.DTS.Host), logrus.ErrorLevel)
+		return
 	}
-	select {
-	case <-a.ChanMessage:
-	default:
-		if a.ChanMessage != nil {
-			close(a.ChanMessage)
+	//当防区数量大于10时,进行防区的温度校验
+	if index > 10 {
+		divide := index / 5
+		if zones[0].AverageTemperature == 0 && zones[divide*1].AverageTemperature == 0 &&
+			zones[divide*2].AverageTemperature == 0 && zones[divide*3].AverageTemperature == 0 &&
+			zones[divide*4].AverageTemperature == 0 && zones[divide*5-1].AverageTemperature == 0 {
+			a.setMessage(fmt.Sprintf("主机为 %s 的dts更新防区温度异常!", a.DTS.Host), logrus.ErrorLevel)
+			return
 		}
 	}
-	select {
-	case <-a.ChanZonesTemp:
-	default:
-		if a.ChanZonesTemp != nil {
-			close(a.ChanZonesTemp)
+
+	//对于报警防区进行重新组装
+	alarms := make(Zones, 0, len(zones))
+	for _, v := range zones {
+		id := Id(a.DTS.Id, uint(v.GetID()))
+		zone := a.GetZone(id)
+		if zone == nil {
+			a.setMessage(fmt.Sprintf("主机为 %s 的dts %d 防区未找到", a.DTS.Host, id), logrus.ErrorLevel)
+			continue
 		}
+		alarm := zone.Clone()
+		alarm.Temperature = &Temperature{
+			Max: v.GetMaxTemperature(),
+			Avg: v.GetAverageTemperature(),
+			Min: v.GetMinTemperature(),
+			At:  &device.TimeLocal{Time: at},
+		}
+		alarm.Alarm = &Alarm{
+			At:       &device.TimeLocal{Time: at},
+			Location: v.GetAlarmLoc(),
+			State:    v.GetAlarmType(), //报警类型
+		}
+		//报警跟踪需要每一次的报警通知,在限流之前处理
+		a.track(a.Tracker.Alarm(alarm, at))
+
+		//同一防区同一报警状态在报警间隔内只处理一次,报警升级时立即处理,被限流的防区不影响其他防区
+		if !a.AlarmLimiter.Allow(id, v.GetAlarmType(), at) {
+			continue
+		}
+		alarms = append(alarms, alarm)
 	}
-	select {
-	case <-a.ChanChannelEvent:
-	default:
-		if a.ChanChannelEvent != nil {
-			close(a.ChanChannelEvent)
+	//当数量为0时则表明报警防区均被限流或未找到
+	if len(alarms) == 0 {
+		return
+	}
+	a.publish(CallAlarm, ZonesAlarm{
+		DTS:       a.DTS,
+		Zones:     alarms,
+		Host:      a.DTS.Host,
+		DeviceId:  notify.GetDeviceID(),
+		CreatedAt: &device.TimeLocal{Time: at},
+	})
+}
+
+// track 发布报警生命周期事件,报警恢复后重置该防区的报警限流
+func (a *App) track(event *AlarmEvent) {
+	if event == nil {
+		return
+	}
+	if event.Type == AlarmCleared {
+		a.AlarmLimiter.Reset(event.Zone.Id)
+	}
+	a.bus.Publish(CallAlarmEvent, *event)
+}
+
+// onZoneTemp 处理防区温度通知
+func (a *App) onZoneTemp(notify *model.ZoneTempNotify) {
+	//若当前温度更新防区已存在缓存中,且当前温度更新时间与上一个温度更新时间的间隔小于温度更新阈值,则不处理
+	value, ok := a.ZonesTemp.LoadOrStore(notify.GetDeviceID(), notify)
+	if ok && time.Now().Sub(time.Unix(value.(*model.ZoneTempNotify).GetTimestamp()/1000, 0)) < time.Second*time.Duration(a.GetConfig().ZonesTempSec) {
+		return
+	}
+	a.ZonesTemp.Store(notify.GetDeviceID(), notify)
+
+	var (
+		index = 0                                                                 //温度更新防区索引,最后一个索引即为当前温度更新防区的长度
+		zones = make(Zones, len(notify.GetZones()))                               //实际可用的温度更新防区
+		at    = &device.TimeLocal{Time: time.Unix(notify.GetTimestamp()/1000, 0)} //温度更新时间
+	)
+	for _, zone := range notify.GetZones() {
+		//对温度校验,当最大温度,平均温度,最小温度均为0时,则当前温度更新防区无效
+		if zone.GetMaxTemperature() == 0 && zone.GetAverageTemperature() == 0 && zone.GetMinTemperature() == 0 {
+			continue
 		}
+		id := Id(a.DTS.Id, uint(zone.GetID()))
+		z := a.GetZone(id)
+		if z == nil {
+			a.setMessage(fmt.Sprintf("主机为 %s 的dts %d 防区未找到", a.DTS.Host, id), logrus.ErrorLevel)
+			continue
+		}
+
+		zones[index] = &Zone{
+			BaseZone: BaseZone{Id: id, ChannelId: z.ChannelId, Name: z.Name},
+			Temperature: &Temperature{
+				Max: zone.GetMaxTemperature(),
+				Avg: zone.GetAverageTemperature(),
+				Min: zone.GetMinTemperature(),
+			},
+		}
+		if zone.GetAlarmType() != model.DefenceAreaState_Normal {
+			zones[index].Alarm = &Alarm{Location: zone.GetAlarmLoc(), At: at, State: zone.GetAlarmType()}
+		}
+		//报警跟踪使用完整的防区信息
+		tracked := z.Clone()
+		tracked.Temperature, tracked.Alarm = zones[index].Temperature, zones[index].Alarm
+		a.track(a.Tracker.Temp(tracked, at.Time))
+		index++
 	}
-	select {
-	case <-a.ChanChannelSignal:
-	default:
-		if a.ChanChannelSignal != nil {
-			close(a.ChanChannelSignal)
+	zones = zones[:index]
+	if len(zones) == 0 {
+		a.setMessage(fmt.Sprintf("更新温度, 主机为 %s 的dts防区为空", a.DTS.Host), logrus.ErrorLevel)
+		return
+	}
+	temp := ZonesTemp{
+		DTS:       a.DTS,
+		Zones:     zones,
+		Host:      a.DTS.Host,
+		DeviceId:  notify.GetDeviceID(),
+		CreatedAt: at,
+	}
+	a.history(temp)
+	a.Index.Temp(zones)
+	a.locker.Lock()
+	a.lastTemp = &temp
+	a.locker.Unlock()
+	a.publish(CallTemp, temp)
+}
+
+// onTempSignal 处理通道信号通知
+func (a *App) onTempSignal(notify *model.TempSignalNotify) {
+	value, ok := a.ZonesChannelSignal.LoadOrStore(fmt.Sprintf("%s-%d", notify.GetDeviceID(), notify.ChannelID), notify)
+	if ok && time.Now().Sub(time.Unix(value.(*model.TempSignalNotify).GetTimestamp()/1000, 0)) < time.Second*time.Duration(a.GetConfig().ChanSignSec) {
+		return
+	}
+	length := len(notify.GetSignal())
+	if length == 0 {
+		a.setMessage(fmt.Sprintf("主机为 %s 通道 %d 的dts信号数据为空!", a.DTS.Host, notify.GetChannelID()), logrus.ErrorLevel)
+		return
+	}
+	if length > 10 {
+		signal := notify.GetSignal()
+		divide := length / 5
+		if signal[0] == 0 && signal[divide*1] == 0 && signal[divide*2] == 0 && signal[divide*3] == 0 &&
+			signal[divide*4] == 0 && signal[divide*5-1] == 0 {
+			a.setMessage(fmt.Sprintf("主机为 %s 通道 %d 的dts信号异常!", a.DTS.Host, notify.GetChannelID()), logrus.ErrorLevel)
+			return
 		}
 	}
-	select {
-	case <-a.ChanZonesAlarm:
-	default:
-		if a.ChanZonesAlarm != nil {
-			close(a.ChanZonesAlarm)
+
+	a.ZonesChannelSignal.Store(fmt.Sprintf("%s-%d", notify.GetDeviceID(), notify.ChannelID), notify)
+	signal := ChannelSignal{
+		DTS:        a.DTS,
+		DeviceId:   notify.GetDeviceID(),
+		ChannelId:  notify.GetChannelID(),
+		RealLength: notify.GetRealLength(),
+		Host:       a.DTS.Host,
+		Signal:     notify.GetSignal(),
+		CreatedAt:  &device.TimeLocal{Time: time.Unix(notify.GetTimestamp()/1000, 0)},
+	}
+	a.locker.Lock()
+	a.lastSignals[signal.ChannelId] = signal
+	a.locker.Unlock()
+	a.publish(CallSignal, signal)
+	if a.GetConfig().Profile {
+		a.profile(signal)
+	}
+	if a.HotSpots != nil {
+		a.hotSpot(signal)
+	}
+}
+
+// onDeviceEvent 处理光纤事件通知
+func (a *App) onDeviceEvent(notify *model.DeviceEventNotify) {
+	event := ChannelEvent{
+		DTS:           a.DTS,
+		DeviceId:      notify.GetDeviceID(),
+		ChannelId:     notify.GetChannelID(),
+		Host:          a.DTS.Host,
+		EventType:     notify.GetEventType(),
+		ChannelLength: notify.GetChannelLength(),
+		CreatedAt:     &device.TimeLocal{Time: time.Unix(notify.GetTimestamp()/1000, 0)},
+	}
+	a.publish(CallEvent, event)
+}
+
+// publish 发布数据到数据通道和所有订阅者
+func (a *App) publish(t CallType, value interface{}) {
+	a.bus.Publish(t, value)
+	a.gate.RLock()
+	defer a.gate.RUnlock()
+	if a.destroyed {
+		return
+	}
+	switch v := value.(type) {
+	case ZonesAlarm:
+		select {
+		case a.ChanZonesAlarm <- v:
+		default:
+			log.L.Warn(fmt.Sprintf("主机 %s 报警防区缓冲已满,忽略当前报警...", a.DTS.Host))
+		}
+	case ZonesTemp:
+		select {
+		case a.ChanZonesTemp <- v:
+		default:
+			log.L.Warn(fmt.Sprintf("主机 %s 温度更新防区缓冲已满,忽略当前更新...", a.DTS.Host))
+		}
+	case ChannelSignal:
+		select {
+		case a.ChanChannelSignal <- v:
+		default:
+			log.L.Warn(fmt.Sprintf("主机 %s 通道 %d 信号缓冲已满,忽略当前更新...", a.DTS.Host, v.ChannelId))
+		}
+	case ChannelEvent:
+		select {
+		case a.ChanChannelEvent <- v:
+		default:
 		}
 	}
 }
 
-// Close 关闭DTS设备
-func (a *App) Close() error {
-	a.cancel()
-	status := a.GetStatus()
-	if status == device.Connecting || status == device.Connected {
-		a.Client.Close()
+// Subscribe 订阅某一类型的数据,每个订阅者独立获得每条数据的副本,互不影响
+func (a *App) Subscribe(t CallType, size int, policy DropPolicy) *Subscription {
+	return a.bus.Subscribe(t, size, policy)
+}
+
+func (a *App) SetCron(cron *cron.Cron) {
+	a.Cron = cron
+}
+
+// Destroy 关闭总线和所有数据通道,由 Close 在所有回调和后台协程结束后调用
+func (a *App) Destroy() {
+	a.bus.Close()
+	a.gate.Lock()
+	defer a.gate.Unlock()
+	if a.destroyed {
+		return
+	}
+	a.destroyed = true
+	if a.ChanStatus != nil {
+		close(a.ChanStatus)
+	}
+	if a.ChanMessage != nil {
+		close(a.ChanMessage)
+	}
+	if a.ChanZonesTemp != nil {
+		close(a.ChanZonesTemp)
+	}
+	if a.ChanChannelEvent != nil {
+		close(a.ChanChannelEvent)
 	}
-	for _, id := range a.CronIds {
-		a.Cron.Remove(id)
+	if a.ChanChannelSignal != nil {
+		close(a.ChanChannelSignal)
 	}
-	a.setStatus(device.Disconnect)
-	time.AfterFunc(time.Second*3, a.Destroy)
+	if a.ChanZonesAlarm != nil {
+		close(a.ChanZonesAlarm)
+	}
+}
+
+// Close 关闭DTS设备,不再处理新的回调,等待正在处理的回调和后台协程结束后关闭所有数据通道
+// 可重复调用,返回时所有数据通道均已关闭
+func (a *App) Close() error {
+	a.closeOnce.Do(func() {
+		a.gate.Lock()
+		a.closing = true
+		a.gate.Unlock()
+		a.cancel()
+		//先关闭总线,解除阻塞策略的订阅对回调的阻塞
+		a.bus.Close()
+		if a.Client != nil {
+			a.Client.Close()
+		}
+		if a.Cron != nil {
+			for _, id := range a.CronIds {
+				a.Cron.Remove(id)
+			}
+		}
+		a.producers.Wait()
+		a.workers.Wait()
+		a.setStatus(device.Disconnect)
+		a.Destroy()
+	})
 	return nil
 }
 
+// enter 回调开始时调用,关闭后返回 false 且回调不应继续处理,返回 true 时处理完成后需调用 leave
+func (a *App) enter() bool {
+	a.gate.RLock()
+	defer a.gate.RUnlock()
+	if a.closing {
+		return false
+	}
+	a.producers.Add(1)
+	return true
+}
+
+// leave 回调处理完成
+func (a *App) leave() {
+	a.producers.Done()
+}
+
+func (a *App) isClosing() bool {
+	a.gate.RLock()
+	defer a.gate.RUnlock()
+	return a.closing
+}
+
 // Status 获取当前设备的运行状态
 func (a *App) Status() device.StatusType {
 	a.locker.Lock()
@@ -415,7 +656,10 @@ func (a *App) setStatus(s device.StatusType) {
 	a.locker.Lock()
 	a.status = s
 	a.locker.Unlock()
-	if a.ChanStatus == nil {
+	a.bus.Publish(CallStatus, HostStatus{DTS: a.DTS, Status: s, At: &device.TimeLocal{Time: time.Now()}})
+	a.gate.RLock()
+	defer a.gate.RUnlock()
+	if a.ChanStatus == nil || a.destroyed {
 		return
 	}
 	select {
@@ -426,7 +670,9 @@ func (a *App) setStatus(s device.StatusType) {
 
 func (a *App) setMessage(msg string, level logrus.Level) {
 	log.L.Log(level, msg)
-	if a.ChanMessage == nil {
+	a.gate.RLock()
+	defer a.gate.RUnlock()
+	if a.ChanMessage == nil || a.destroyed {
 		return
 	}
 	select {
@@ -451,6 +697,31 @@ func (a *App) GetZones() map[uint]*Zone {
 	return a.Zones
 }
 
+// LastTemp 获取最近一次的防区温度,未收到温度更新时返回 nil
+func (a *App) LastTemp() *ZonesTemp {
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	if a.lastTemp == nil {
+		return nil
+	}
+	temp := *a.lastTemp
+	return &temp
+}
+
+// LastSignals 获取每个通道最近一次的信号,按通道排序
+func (a *App) LastSignals() []ChannelSignal {
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	signals := make([]ChannelSignal, 0, len(a.lastSignals))
+	for _, signal := range a.lastSignals {
+		signals = append(signals, signal)
+	}
+	sort.Slice(signals, func(i, j int) bool {
+		return signals[i].ChannelId < signals[j].ChannelId
+	})
+	return signals
+}
+
 // GetSyncChannelZones 根据通道 Id 获取防区集合
 func (a *App) GetSyncChannelZones(channelId byte) (Zones, error) {
 	config := a.GetConfig()
@@ -461,7 +732,10 @@ func (a *App) GetSyncChannelZones(channelId byte) (Zones, error) {
 	if !response.Success {
 		return nil, errors.New(response.ErrMsg)
 	}
-	zones := make(Zones, len(response.Rows))
+	var (
+		zones = make(Zones, len(response.Rows))
+		errs  []*ZoneTagError
+	)
 	for k := range response.Rows {
 		v := response.Rows[k]
 		id := Id(a.DTS.Id, uint(v.ID))
@@ -474,53 +748,75 @@ func (a *App) GetSyncChannelZones(channelId byte) (Zones, error) {
 			Finish:    v.GetFinish(),
 			Host:      a.DTS.Host,
 		}
-		if v.Tag == "" && (config.Coordinate || config.Relay) {
-			log.L.Warn(fmt.Sprintf("获取主机 %s 通道 %d 防区 %s 标签为空", a.DTS.Host, channelId, v.ZoneName))
-			continue
-		}
-		zones[k].Tag = DecodeTags(v.GetTag())
-		if config.Relay {
-			relay, err := NewRelay(zones[k].Tag)
-			if err != nil {
-				continue
-			}
-			zones[k].Relay = relay
-		}
-		if config.Coordinate {
-			coordinate, err := NewCoordinate(zones[k].Tag)
-			if err != nil {
-				log.L.Error(fmt.Sprintf("获取主机 %s 通道 %d 防区 %s 坐标失败: %s", a.DTS.Host, channelId, v.ZoneName, err))
-				continue
-			}
-			zones[k].Coordinate = coordinate
+		if e := a.schema.Decode(zones[k], v.GetTag(), config.Coordinate, config.Relay); e != nil {
+			errs = append(errs, e)
 		}
 	}
+	a.locker.Lock()
+	a.tagErrors[channelId] = errs
+	a.locker.Unlock()
+	if len(errs) > 0 {
+		a.setMessage(fmt.Sprintf("主机为 %s 通道 %d 有 %d 个防区标签错误", a.DTS.Host, channelId, len(errs)), logrus.WarnLevel)
+	}
 	return zones, nil
 }
 
 // SyncChannelZones 同步获取通道防区
 func (a *App) SyncChannelZones(channelId byte) error {
+	a.syncLocker.Lock()
+	defer a.syncLocker.Unlock()
 	zones, err := a.GetSyncChannelZones(channelId)
 	if err != nil {
 		return err
 	}
-	a.locker.Lock()
-	for _, zone := range zones {
-		a.Zones[zone.Id] = zone
-	}
-	a.locker.Unlock()
+	a.swapZones(map[byte]Zones{channelId: zones})
 	log.L.Info(fmt.Sprintf("获取主机 %s 通道 %d 防区", a.DTS.Host, channelId))
 	return nil
 }
 
-// SyncZones 同步获取所有防区
-func (a *App) SyncZones() {
+// SyncZones 同步获取所有防区,返回最后一个失败通道的错误,失败通道的防区保持不变
+func (a *App) SyncZones() (err error) {
+	a.syncLocker.Lock()
+	defer a.syncLocker.Unlock()
+	channels := map[byte]Zones{}
 	for i := byte(1); i <= a.GetConfig().ChannelNum; i++ {
-		err := a.SyncChannelZones(i)
-		if err != nil {
-			a.setMessage(fmt.Sprintf("获取主机 %s 通道 %d 防区失败: %s", a.DTS.Host, i, err), logrus.ErrorLevel)
+		zones, e := a.GetSyncChannelZones(i)
+		if e != nil {
+			a.setMessage(fmt.Sprintf("获取主机 %s 通道 %d 防区失败: %s", a.DTS.Host, i, e), logrus.ErrorLevel)
+			err = errors.New(fmt.Sprintf("获取通道 %d 防区失败: %s", i, e))
+			continue
+		}
+		channels[i] = zones
+		log.L.Info(fmt.Sprintf("获取主机 %s 通道 %d 防区", a.DTS.Host, i))
+	}
+	a.swapZones(channels)
+	return
+}
+
+// swapZones 以同步的通道防区整体替换当前防区,未同步的通道保持不变,并发布防区的变化
+func (a *App) swapZones(channels map[byte]Zones) {
+	a.locker.Lock()
+	old := a.Zones
+	zones := make(map[uint]*Zone, len(old))
+	for id, zone := range old {
+		if _, ok := channels[zone.ChannelId]; !ok {
+			zones[id] = zone
 		}
 	}
+	for _, list := range channels {
+		for _, zone := range list {
+			zones[zone.Id] = zone
+		}
+	}
+	a.Zones = zones
+	a.locker.Unlock()
+	a.Index.Sync(zones)
+	for _, change := range DiffZones(a.DTS, old, zones) {
+		if change.Type == ZoneRemoved {
+			a.History.Remove(change.Zone.Id)
+		}
+		a.bus.Publish(CallZoneChange, change)
+	}
 }
 
 // GetDeviceCode 获取设备编码
@@ -542,10 +838,13 @@ func (a *App) GetConfig() *Config {
 	return a.config
 }
 
-// SetConfig 设置配置
+// SetConfig 设置配置,ZonesSyncSec 在下次 Run 时生效,Coordinate 和 Relay 在下次同步防区时生效
 func (a *App) SetConfig(config *Config) {
 	a.locker.Lock()
 	defer a.locker.Unlock()
+	a.config.Coordinate = config.Coordinate
+	a.config.Relay = config.Relay
+
 	if config.ZonesAlarmSec <= 0 {
 		config.ZonesAlarmSec = 20
 	}
@@ -565,4 +864,13 @@ func (a *App) SetConfig(config *Config) {
 		config.ChannelNum = 4
 	}
 	a.config.ChannelNum = config.ChannelNum
+
+	if config.AlarmClearSec <= 0 {
+		config.AlarmClearSec = 60
+	}
+	a.config.AlarmClearSec = config.AlarmClearSec
+	a.config.ZonesSyncSec = config.ZonesSyncSec
+	a.config.Profile = config.Profile
+	a.config.HistorySec = config.HistorySec
+	a.config.HistorySize = config.HistorySize
 }
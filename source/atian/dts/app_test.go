@@ -0,0 +1,297 @@
+package dts
+
+import (
+	"context"
+	"github.com/Atian-OE/DTSSDK_Golang/dtssdk/model"
+	"github.com/zing-dev/atian-tools/source/atian/dts/simulator"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testClient 内存中的 Client,由测试协程直接调用注册的回调
+type testClient struct {
+	locker       sync.Mutex
+	zones        []*model.DefenceZone
+	connected    func(string)
+	disconnected func(string)
+	timeout      func(string)
+	alarm        func(*model.ZoneAlarmNotify, error)
+	temp         func(*model.ZoneTempNotify, error)
+	signal       func(*model.TempSignalNotify, error)
+	event        func(*model.DeviceEventNotify, error)
+	closed       int
+}
+
+func (c *testClient) CallConnected(f func(string)) {
+	c.locker.Lock()
+	c.connected = f
+	c.locker.Unlock()
+}
+
+func (c *testClient) CallDisconnected(f func(string)) {
+	c.locker.Lock()
+	c.disconnected = f
+	c.locker.Unlock()
+}
+
+func (c *testClient) OnTimeout(f func(string)) {
+	c.locker.Lock()
+	c.timeout = f
+	c.locker.Unlock()
+}
+
+func (c *testClient) CallZoneAlarmNotify(f func(*model.ZoneAlarmNotify, error)) error {
+	c.locker.Lock()
+	c.alarm = f
+	c.locker.Unlock()
+	return nil
+}
+
+func (c *testClient) CallZoneTempNotify(f func(*model.ZoneTempNotify, error)) error {
+	c.locker.Lock()
+	c.temp = f
+	c.locker.Unlock()
+	return nil
+}
+
+func (c *testClient) CallTempSignalNotify(f func(*model.TempSignalNotify, error)) error {
+	c.locker.Lock()
+	c.signal = f
+	c.locker.Unlock()
+	return nil
+}
+
+func (c *testClient) CallDeviceEventNotify(f func(*model.DeviceEventNotify, error)) error {
+	c.locker.Lock()
+	c.event = f
+	c.locker.Unlock()
+	return nil
+}
+
+func (c *testClient) GetDefenceZone(channel int, _ string) (*model.GetDefenceZoneReply, error) {
+	reply := &model.GetDefenceZoneReply{Success: true}
+	for _, zone := range c.zones {
+		if int(zone.GetChannelID()) == channel {
+			reply.Rows = append(reply.Rows, zone)
+		}
+	}
+	return reply, nil
+}
+
+func (c *testClient) GetDeviceID() (*model.GetDeviceIDReply, error) {
+	return &model.GetDeviceIDReply{Success: true, DeviceID: "DTS-TEST"}, nil
+}
+
+func (c *testClient) Close() {
+	c.locker.Lock()
+	c.closed++
+	c.locker.Unlock()
+}
+
+func (c *testClient) closes() int {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	return c.closed
+}
+
+// fire 随机调用一个已注册的回调,模拟 SDK 在自己的协程中推送
+func (c *testClient) fire(r *rand.Rand, channels int) {
+	c.locker.Lock()
+	connected, disconnected, timeout := c.connected, c.disconnected, c.timeout
+	alarm, temp, signal, event := c.alarm, c.temp, c.signal, c.event
+	c.locker.Unlock()
+
+	//时间戳取一小时前,避免被温度和信号的时间间隔过滤
+	timestamp := time.Now().Add(-time.Hour).UnixNano() / 1e6
+	switch n := r.Intn(100); {
+	case n < 3 && connected != nil:
+		connected("test")
+	case n < 5 && disconnected != nil:
+		disconnected("test")
+	case n < 6 && timeout != nil:
+		timeout("test")
+	case n < 30 && alarm != nil:
+		zone := simulator.ZoneTemps(c.zones[r.Intn(len(c.zones)):][:1], 60)[0]
+		zone.AlarmType = model.DefenceAreaState(r.Intn(9))
+		alarm(&model.ZoneAlarmNotify{DeviceID: "DTS-TEST", Timestamp: timestamp, Zones: []*model.DefenceZone{zone}}, nil)
+	case n < 60 && temp != nil:
+		temp(&model.ZoneTempNotify{DeviceID: "DTS-TEST", Timestamp: timestamp, Zones: simulator.ZoneTemps(c.zones, 25)}, nil)
+	case n < 90 && signal != nil:
+		signal(&model.TempSignalNotify{
+			DeviceID:   "DTS-TEST",
+			ChannelID:  int32(r.Intn(channels) + 1),
+			RealLength: 500,
+			Signal:     simulator.Signal(500, 1, 25),
+			Timestamp:  timestamp,
+		}, nil)
+	case event != nil:
+		event(&model.DeviceEventNotify{DeviceID: "DTS-TEST", ChannelID: int32(r.Intn(channels) + 1), Timestamp: timestamp}, nil)
+	}
+}
+
+// TestCloseConcurrentNotify 多个协程并发推送各类通知时关闭 App,需使用 go test -race 运行
+// Close 返回时所有数据通道均已读完并关闭,关闭后的推送被忽略,且不能再次 Run
+func TestCloseConcurrentNotify(t *testing.T) {
+	const (
+		rounds    = 5
+		producers = 8
+		channels  = 4
+	)
+	for i := 0; i < rounds; i++ {
+		c := &testClient{zones: simulator.NewZones(channels, 20, 10)}
+		app := New(context.Background(), DTS{Id: 1, Name: "test", Host: "test"}, &Config{},
+			WithClient(c), WithAuto(), WithHotSpot(HotSpotConfig{}))
+		app.SetConfig(&Config{ZonesAlarmSec: 1, ZonesTempSec: 1, ChanSignSec: 1, ChannelNum: channels, HistorySec: 60})
+		app.CallTypes = []CallType{CallAlarm, CallTemp, CallSignal, CallEvent}
+
+		//阻塞策略且不读取的订阅,关闭时不能因此卡住
+		app.Subscribe(CallTemp, 1, Block)
+		subscription := app.Subscribe(CallAlarmEvent, 10, DropOldest)
+
+		var (
+			received  uint64
+			consumers sync.WaitGroup
+		)
+		consume := func(f func() bool) {
+			consumers.Add(1)
+			go func() {
+				defer consumers.Done()
+				for f() {
+					atomic.AddUint64(&received, 1)
+				}
+			}()
+		}
+		consume(func() bool { _, ok := <-app.ChanZonesAlarm; return ok })
+		consume(func() bool { _, ok := <-app.ChanZonesTemp; return ok })
+		consume(func() bool { _, ok := <-app.ChanChannelSignal; return ok })
+		consume(func() bool { _, ok := <-app.ChanChannelEvent; return ok })
+		consume(func() bool { _, ok := <-app.ChanStatus; return ok })
+		consume(func() bool { _, ok := <-app.ChanMessage; return ok })
+		consume(func() bool { _, ok := <-subscription.C; return ok })
+
+		if err := app.Run(); err != nil {
+			t.Fatal(err)
+		}
+
+		var (
+			stop = make(chan struct{})
+			sent uint64
+			wg   sync.WaitGroup
+		)
+		for p := 0; p < producers; p++ {
+			wg.Add(1)
+			go func(seed int64) {
+				defer wg.Done()
+				r := rand.New(rand.NewSource(seed))
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					c.fire(r, channels)
+					atomic.AddUint64(&sent, 1)
+				}
+			}(int64(i*producers + p))
+		}
+
+		time.Sleep(time.Millisecond * 50)
+		closed := make(chan struct{})
+		go func() {
+			_ = app.Close()
+			close(closed)
+		}()
+		select {
+		case <-closed:
+		case <-time.After(time.Second * 10):
+			close(stop)
+			t.Fatalf("第 %d 轮 Close 超过 10 秒未返回", i)
+		}
+		//Close 返回时数据通道已关闭,消费者读完剩余数据后读到关闭即退出
+		done := make(chan struct{})
+		go func() {
+			consumers.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			close(stop)
+			t.Fatalf("第 %d 轮 Close 返回后数据通道未全部关闭", i)
+		}
+		//关闭后继续推送一段时间,回调应被忽略
+		time.Sleep(time.Millisecond * 20)
+		close(stop)
+		wg.Wait()
+		if err := app.Run(); err == nil {
+			t.Fatalf("第 %d 轮 Close 后 Run 应返回错误", i)
+		}
+		t.Logf("第 %d 轮推送 %d 条通知,读取 %d 条数据", i, atomic.LoadUint64(&sent), atomic.LoadUint64(&received))
+	}
+}
+
+// TestRunAgain 断开后再次 Run 时关闭之前的客户端
+func TestRunAgain(t *testing.T) {
+	var clients []*testClient
+	app := New(context.Background(), DTS{Id: 1, Name: "test", Host: "test"}, &Config{},
+		WithClientFactory(func(string) Client {
+			c := &testClient{zones: simulator.NewZones(1, 5, 10)}
+			clients = append(clients, c)
+			return c
+		}), WithAuto())
+	for i := 0; i < 3; i++ {
+		if err := app.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if err := app.Run(); err == nil {
+			t.Fatal("连接中再次 Run 应返回错误")
+		}
+		clients[i].disconnected("test")
+	}
+	for i, c := range clients[:2] {
+		if c.closes() != 1 {
+			t.Fatalf("第 %d 个客户端关闭 %d 次, 期望 1 次", i, c.closes())
+		}
+	}
+	if clients[2].closes() != 0 {
+		t.Fatal("当前客户端不应关闭")
+	}
+	_ = app.Close()
+	if clients[2].closes() != 1 {
+		t.Fatal("Close 应关闭当前客户端")
+	}
+}
+
+// TestRegisterSubscribeOnly 只能订阅的回调类型跳过,不注册任何回调
+func TestRegisterSubscribeOnly(t *testing.T) {
+	c := &testClient{}
+	app := New(context.Background(), DTS{Id: 1, Name: "test", Host: "test"}, &Config{}, WithClient(c))
+	defer app.Close()
+	app.Client = c
+	app.CallTypes = []CallType{CallStatus, CallZoneChange, CallAlarmEvent, CallProfile, CallHotSpot}
+	if err := app.Register(); err != nil {
+		t.Fatal(err)
+	}
+	app.CallTypes = append(app.CallTypes, CallTemp)
+	if err := app.Register(); err != nil {
+		t.Fatal(err)
+	}
+	if c.alarm != nil || c.temp == nil {
+		t.Fatal("应只注册温度更新回调")
+	}
+}
+
+func TestFactoryCallTypes(t *testing.T) {
+	factory := NewFactory()
+	app, err := factory.New(context.Background(), []byte(`{"dts":{"host":"test"},"call_types":[0,8]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = app.Close()
+	if _, err := factory.New(context.Background(), []byte(`{"dts":{"host":"test"},"call_types":[0,42]}`)); err == nil {
+		t.Fatal("未知的回调类型应返回错误")
+	}
+}
@@ -0,0 +1,215 @@
+package dts
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	DropNewest DropPolicy = iota //缓冲已满时丢弃当前数据,与原数据通道的行为一致
+	DropOldest                   //缓冲已满时丢弃最旧的数据
+	Block                        //缓冲已满时阻塞直到有空间或订阅关闭
+)
+
+type (
+	// DropPolicy 订阅缓冲已满时的处理策略
+	DropPolicy byte
+
+	// Subscription 订阅,C 中的数据类型由订阅的回调类型决定
+	// CallAlarm: ZonesAlarm, CallTemp: ZonesTemp, CallSignal: ChannelSignal, CallEvent: ChannelEvent,
+	// CallAlarmEvent: AlarmEvent, CallZoneChange: ZoneChange, CallProfile: ZonesTemp,
+	// CallHotSpot: HotSpotEvent, CallStatus: HostStatus
+	Subscription struct {
+		dropped   uint64 //64位原子操作的字段放在首位,保证32位平台上的对齐
+		delivered uint64
+
+		Type   CallType
+		Policy DropPolicy
+		C      <-chan interface{}
+
+		c      chan interface{}
+		done   chan struct{}
+		once   sync.Once
+		locker sync.Mutex
+		closed bool
+		bus    *Bus
+	}
+
+	// Bus 多订阅者的事件总线,每个订阅者获得每个事件的独立副本
+	Bus struct {
+		locker      sync.RWMutex
+		closed      bool
+		subscribers map[CallType]map[*Subscription]struct{}
+	}
+)
+
+func NewBus() *Bus {
+	return &Bus{subscribers: map[CallType]map[*Subscription]struct{}{}}
+}
+
+// Subscribe 订阅某一类型的事件,size 为订阅缓冲大小
+func (b *Bus) Subscribe(t CallType, size int, policy DropPolicy) *Subscription {
+	if size < 0 {
+		size = 0
+	}
+	c := make(chan interface{}, size)
+	s := &Subscription{
+		Type:   t,
+		Policy: policy,
+		C:      c,
+		c:      c,
+		done:   make(chan struct{}),
+		bus:    b,
+	}
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	if b.closed {
+		s.close()
+		return s
+	}
+	if b.subscribers[t] == nil {
+		b.subscribers[t] = map[*Subscription]struct{}{}
+	}
+	b.subscribers[t][s] = struct{}{}
+	return s
+}
+
+// Publish 向该类型的所有订阅者发布事件副本
+func (b *Bus) Publish(t CallType, value interface{}) {
+	b.locker.RLock()
+	subscribers := make([]*Subscription, 0, len(b.subscribers[t]))
+	for s := range b.subscribers[t] {
+		subscribers = append(subscribers, s)
+	}
+	b.locker.RUnlock()
+	for _, s := range subscribers {
+		s.send(Clone(value))
+	}
+}
+
+// Subscriptions 获取当前所有的订阅
+func (b *Bus) Subscriptions() []*Subscription {
+	b.locker.RLock()
+	defer b.locker.RUnlock()
+	var subscriptions []*Subscription
+	for _, subscribers := range b.subscribers {
+		for s := range subscribers {
+			subscriptions = append(subscriptions, s)
+		}
+	}
+	return subscriptions
+}
+
+// Close 关闭总线及所有订阅
+func (b *Bus) Close() {
+	b.locker.Lock()
+	b.closed = true
+	subscribers := b.subscribers
+	b.subscribers = map[CallType]map[*Subscription]struct{}{}
+	b.locker.Unlock()
+	for _, list := range subscribers {
+		for s := range list {
+			s.close()
+		}
+	}
+}
+
+func (b *Bus) remove(s *Subscription) {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	delete(b.subscribers[s.Type], s)
+}
+
+// Dropped 因缓冲已满丢弃的事件数
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Delivered 已投递的事件数
+func (s *Subscription) Delivered() uint64 {
+	return atomic.LoadUint64(&s.delivered)
+}
+
+// Close 取消订阅并关闭 C
+func (s *Subscription) Close() {
+	s.bus.remove(s)
+	s.close()
+}
+
+func (s *Subscription) close() {
+	s.once.Do(func() {
+		close(s.done)
+		s.locker.Lock()
+		s.closed = true
+		close(s.c)
+		s.locker.Unlock()
+	})
+}
+
+func (s *Subscription) send(value interface{}) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.c <- value:
+		atomic.AddUint64(&s.delivered, 1)
+		return
+	default:
+	}
+	switch s.Policy {
+	case Block:
+		select {
+		case s.c <- value:
+			atomic.AddUint64(&s.delivered, 1)
+		case <-s.done:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	case DropOldest:
+		select {
+		case <-s.c:
+			atomic.AddUint64(&s.dropped, 1)
+		default:
+		}
+		select {
+		case s.c <- value:
+			atomic.AddUint64(&s.delivered, 1)
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// Clone 复制事件,防区信息为深拷贝,未知类型原样返回
+func Clone(value interface{}) interface{} {
+	switch v := value.(type) {
+	case ZonesAlarm:
+		v.Zones = v.Zones.Clone()
+		return v
+	case ZonesTemp:
+		v.Zones = v.Zones.Clone()
+		return v
+	case ChannelSignal:
+		v.Signal = append([]float32(nil), v.Signal...)
+		return v
+	case AlarmEvent:
+		v.Zone = v.Zone.Clone()
+		return v
+	case ZoneChange:
+		v.Zone, v.Old = v.Zone.Clone(), v.Old.Clone()
+		return v
+	case HotSpotEvent:
+		v.HotSpot.Zone = v.HotSpot.Zone.Clone()
+		if v.Previous != nil {
+			previous := *v.Previous
+			previous.Zone = previous.Zone.Clone()
+			v.Previous = &previous
+		}
+		return v
+	default:
+		return value
+	}
+}
@@ -0,0 +1,101 @@
+package dts
+
+import (
+	"encoding/json"
+	"github.com/zing-dev/atian-tools/source/device"
+	"sort"
+	"time"
+)
+
+const (
+	_              ZoneChangeType = iota
+	ZoneAdded                     //新增防区
+	ZoneRemoved                   //删除防区
+	ZoneRenamed                   //防区名变化
+	ZoneMoved                     //防区开始或结束位置变化
+	ZoneTagChanged                //防区标签变化
+)
+
+type (
+	// ZoneChangeType 防区变化类型
+	ZoneChangeType byte
+
+	// ZoneChange 防区变化事件
+	ZoneChange struct {
+		Type ZoneChangeType    `json:"type"`
+		DTS  DTS               `json:"dts,omitempty"`
+		Zone *Zone             `json:"zone"`          //变化后的防区,删除时为被删除的防区
+		Old  *Zone             `json:"old,omitempty"` //变化前的防区,新增和删除时为空
+		At   *device.TimeLocal `json:"at"`
+	}
+)
+
+func (t ZoneChangeType) String() string {
+	switch t {
+	case ZoneAdded:
+		return "新增防区"
+	case ZoneRemoved:
+		return "删除防区"
+	case ZoneRenamed:
+		return "防区重命名"
+	case ZoneMoved:
+		return "防区位置变化"
+	case ZoneTagChanged:
+		return "防区标签变化"
+	default:
+		return "未知变化"
+	}
+}
+
+func (c *ZoneChange) JSON() string {
+	data, _ := json.Marshal(c)
+	return string(data)
+}
+
+// DiffZones 比较新旧防区,按防区 Id 排序返回防区的变化,同一防区可能同时有多个变化
+func DiffZones(dts DTS, old, zones map[uint]*Zone) []ZoneChange {
+	var (
+		changes []ZoneChange
+		at      = &device.TimeLocal{Time: time.Now()}
+	)
+	for id, zone := range zones {
+		o, ok := old[id]
+		if !ok {
+			changes = append(changes, ZoneChange{Type: ZoneAdded, DTS: dts, Zone: zone, At: at})
+			continue
+		}
+		if o.Name != zone.Name {
+			changes = append(changes, ZoneChange{Type: ZoneRenamed, DTS: dts, Zone: zone, Old: o, At: at})
+		}
+		if o.Start != zone.Start || o.Finish != zone.Finish || o.ChannelId != zone.ChannelId {
+			changes = append(changes, ZoneChange{Type: ZoneMoved, DTS: dts, Zone: zone, Old: o, At: at})
+		}
+		if !equalTag(o.Tag, zone.Tag) {
+			changes = append(changes, ZoneChange{Type: ZoneTagChanged, DTS: dts, Zone: zone, Old: o, At: at})
+		}
+	}
+	for id, zone := range old {
+		if _, ok := zones[id]; !ok {
+			changes = append(changes, ZoneChange{Type: ZoneRemoved, DTS: dts, Zone: zone, At: at})
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].Zone.Id == changes[j].Zone.Id {
+			return changes[i].Type < changes[j].Type
+		}
+		return changes[i].Zone.Id < changes[j].Zone.Id
+	})
+	return changes
+}
+
+func equalTag(a, b Tag) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if value, ok := b[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,162 @@
+package dts
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	IssueTag        IssueType = iota + 1 //标签缺失或错误
+	IssueRange                           //防区开始位置不小于结束位置
+	IssueOverlap                         //同一通道的防区范围重叠
+	IssueGap                             //同一通道相邻防区之间的间隔过大
+	IssueCoordinate                      //防区坐标重复
+
+	// overlapEpsilon 判断重叠时允许的误差 米,首尾相接的防区不视为重叠
+	overlapEpsilon float32 = 1e-3
+)
+
+type (
+	// IssueType 防区检查的问题类型
+	IssueType byte
+
+	// ZoneIssue 防区检查发现的问题,Zones 为涉及的防区 Id
+	ZoneIssue struct {
+		Type      IssueType `json:"type"`
+		ChannelId byte      `json:"channel_id,omitempty"`
+		Zones     []uint    `json:"zones"`
+		Message   string    `json:"message"`
+	}
+)
+
+func (t IssueType) String() string {
+	switch t {
+	case IssueTag:
+		return "标签错误"
+	case IssueRange:
+		return "范围错误"
+	case IssueOverlap:
+		return "范围重叠"
+	case IssueGap:
+		return "范围间隔"
+	case IssueCoordinate:
+		return "坐标重复"
+	default:
+		return "未知"
+	}
+}
+
+// CheckZones 检查防区的范围和坐标,gap 为相邻防区之间允许的最大间隔 米
+// 同一通道的防区按开始位置排序后检查重叠和间隔,坐标重复在所有防区中检查
+func CheckZones(zones Zones, gap float32) []*ZoneIssue {
+	var issues []*ZoneIssue
+	channels := map[byte]Zones{}
+	for _, zone := range zones {
+		channels[zone.ChannelId] = append(channels[zone.ChannelId], zone)
+	}
+	ids := make([]int, 0, len(channels))
+	for id := range channels {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		issues = append(issues, checkChannel(channels[byte(id)], gap)...)
+	}
+	return append(issues, checkCoordinate(zones)...)
+}
+
+// TagIssues 将防区标签错误转换为检查问题
+func TagIssues(errs []*ZoneTagError) []*ZoneIssue {
+	issues := make([]*ZoneIssue, 0, len(errs))
+	for _, e := range errs {
+		issues = append(issues, &ZoneIssue{
+			Type:      IssueTag,
+			ChannelId: e.ChannelId,
+			Zones:     []uint{e.Id},
+			Message:   fmt.Sprintf("防区 %s 标签 %q 错误: %s", e.Name, e.Tag, e.Errors),
+		})
+	}
+	return issues
+}
+
+func checkChannel(zones Zones, gap float32) []*ZoneIssue {
+	var issues []*ZoneIssue
+	zones = append(Zones{}, zones...)
+	sort.SliceStable(zones, func(i, j int) bool {
+		if zones[i].Start == zones[j].Start {
+			return zones[i].Id < zones[j].Id
+		}
+		return zones[i].Start < zones[j].Start
+	})
+	var last *Zone //结束位置最远的防区
+	for _, zone := range zones {
+		if zone.Start >= zone.Finish {
+			issues = append(issues, &ZoneIssue{
+				Type:      IssueRange,
+				ChannelId: zone.ChannelId,
+				Zones:     []uint{zone.Id},
+				Message:   fmt.Sprintf("防区 %s 开始位置 %.2f 不小于结束位置 %.2f", zone.Name, zone.Start, zone.Finish),
+			})
+		}
+		if last != nil {
+			switch {
+			case zone.Start < last.Finish-overlapEpsilon:
+				issues = append(issues, &ZoneIssue{
+					Type:      IssueOverlap,
+					ChannelId: zone.ChannelId,
+					Zones:     []uint{last.Id, zone.Id},
+					Message: fmt.Sprintf("防区 %s [%.2f, %.2f] 与防区 %s [%.2f, %.2f] 重叠",
+						last.Name, last.Start, last.Finish, zone.Name, zone.Start, zone.Finish),
+				})
+			case zone.Start-last.Finish > gap:
+				issues = append(issues, &ZoneIssue{
+					Type:      IssueGap,
+					ChannelId: zone.ChannelId,
+					Zones:     []uint{last.Id, zone.Id},
+					Message: fmt.Sprintf("防区 %s 与防区 %s 之间间隔 %.2f 米 (%.2f - %.2f)",
+						last.Name, zone.Name, zone.Start-last.Finish, last.Finish, zone.Start),
+				})
+			}
+		}
+		if last == nil || zone.Finish > last.Finish {
+			last = zone
+		}
+	}
+	return issues
+}
+
+func checkCoordinate(zones Zones) []*ZoneIssue {
+	var (
+		issues      []*ZoneIssue
+		coordinates = map[Coordinate]Zones{}
+		keys        []Coordinate
+	)
+	for _, zone := range zones {
+		if zone.Coordinate == nil {
+			continue
+		}
+		c := *zone.Coordinate
+		if _, ok := coordinates[c]; !ok {
+			keys = append(keys, c)
+		}
+		coordinates[c] = append(coordinates[c], zone)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return lessCoordinate(keys[i], keys[j])
+	})
+	for _, c := range keys {
+		if len(coordinates[c]) < 2 {
+			continue
+		}
+		issue := &ZoneIssue{Type: IssueCoordinate}
+		names := make([]string, 0, len(coordinates[c]))
+		for _, zone := range coordinates[c] {
+			issue.Zones = append(issue.Zones, zone.Id)
+			names = append(names, zone.Name)
+		}
+		issue.Message = fmt.Sprintf("防区 %v 坐标重复 仓库 %s 组 %s 行 %d 列 %d 层 %d",
+			names, c.Warehouse, c.Group, c.Row, c.Column, c.Layer)
+		issues = append(issues, issue)
+	}
+	return issues
+}
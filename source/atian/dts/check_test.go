@@ -0,0 +1,36 @@
+package dts
+
+import (
+	"testing"
+)
+
+func TestCheckZonesOverlap(t *testing.T) {
+	newZone := func(id uint, start, finish float32) *Zone {
+		return &Zone{BaseZone: BaseZone{Id: id, ChannelId: 1, Start: start, Finish: finish}}
+	}
+	tests := []struct {
+		name   string
+		zones  Zones
+		issues []IssueType
+	}{
+		{name: "首尾相接", zones: Zones{newZone(1, 0, 10), newZone(2, 10, 20)}},
+		{name: "float32 误差内相接", zones: Zones{newZone(1, 0, 10.0001), newZone(2, 10, 20)}},
+		{name: "重叠", zones: Zones{newZone(1, 0, 10.5), newZone(2, 10, 20)}, issues: []IssueType{IssueOverlap}},
+		{name: "间隔", zones: Zones{newZone(1, 0, 10), newZone(2, 12, 20)}, issues: []IssueType{IssueGap}},
+		{name: "间隔在允许范围内", zones: Zones{newZone(1, 0, 10), newZone(2, 10.5, 20)}},
+		{name: "范围错误", zones: Zones{newZone(1, 10, 10)}, issues: []IssueType{IssueRange}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			issues := CheckZones(test.zones, 1)
+			if len(issues) != len(test.issues) {
+				t.Fatalf("得到 %d 个问题 %v, 期望 %v", len(issues), issues, test.issues)
+			}
+			for k, issue := range issues {
+				if issue.Type != test.issues[k] {
+					t.Fatalf("问题 %s, 期望 %s", issue.Message, test.issues[k].String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package dts
+
+import (
+	"github.com/Atian-OE/DTSSDK_Golang/dtssdk"
+	"github.com/Atian-OE/DTSSDK_Golang/dtssdk/model"
+)
+
+type (
+	// Client DTS 主机客户端,App 通过该接口与主机通信,默认实现为 *dtssdk.Client
+	Client interface {
+		CallConnected(func(string))    //连接成功回调
+		CallDisconnected(func(string)) //断开连接回调
+		OnTimeout(func(string))        //连接超时回调
+
+		CallZoneAlarmNotify(func(*model.ZoneAlarmNotify, error)) error     //注册防区报警回调
+		CallZoneTempNotify(func(*model.ZoneTempNotify, error)) error       //注册防区温度回调
+		CallTempSignalNotify(func(*model.TempSignalNotify, error)) error   //注册通道信号回调
+		CallDeviceEventNotify(func(*model.DeviceEventNotify, error)) error //注册光纤事件回调
+
+		GetDefenceZone(channel int, search string) (*model.GetDefenceZoneReply, error) //获取通道防区
+		GetDeviceID() (*model.GetDeviceIDReply, error)                                 //获取设备编码
+
+		Close()
+	}
+
+	// ClientFactory 根据主机地址创建客户端,每次 Run 时调用
+	ClientFactory func(host string) Client
+
+	// Option App 的可选配置
+	Option func(*App)
+)
+
+var _ Client = (*dtssdk.Client)(nil)
+
+// NewDTSClient 默认的客户端工厂,使用 DTSSDK 连接主机
+func NewDTSClient(host string) Client {
+	return dtssdk.NewDTSClient(host)
+}
+
+// WithClient 使用指定的客户端代替 DTSSDK 客户端
+func WithClient(client Client) Option {
+	return func(a *App) {
+		a.newClient = func(string) Client {
+			return client
+		}
+	}
+}
+
+// WithClientFactory 使用指定的客户端工厂创建客户端
+func WithClientFactory(factory ClientFactory) Option {
+	return func(a *App) {
+		a.newClient = factory
+	}
+}
@@ -0,0 +1,95 @@
+//go:build !race
+// +build !race
+
+package dts
+
+import (
+	"context"
+	"fmt"
+	"github.com/Atian-OE/DTSSDK_Golang/dtssdk/model"
+	"github.com/zing-dev/atian-tools/source/atian/dts/simulator"
+	"github.com/zing-dev/atian-tools/source/device"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, message string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 5)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+// TestSimulatorEndToEnd 启动 DTS 模拟器,App 使用 DTSSDK 客户端完成连接,同步防区,注册回调和接收通知
+// DTSSDK 客户端固定连接 17083 端口,端口被占用时跳过
+// DTSSDK 客户端的连接状态和回调没有同步,-race 会报告 SDK 内部的数据竞争,因此该文件不参与 -race 测试
+func TestSimulatorEndToEnd(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := simulator.New(ctx, simulator.Config{
+		Addr:     fmt.Sprintf("127.0.0.1:%d", simulator.Port),
+		DeviceId: "DTS-E2E",
+		Zones:    simulator.NewZones(2, 3, 10),
+	})
+	if err := server.Listen(); err != nil {
+		t.Skipf("端口 %d 无法监听: %s", simulator.Port, err)
+	}
+	defer server.Close()
+
+	app := New(ctx, DTS{Id: 1, Name: "e2e", Host: "127.0.0.1"}, &Config{ChannelNum: 2, Relay: true})
+	app.CallTypes = []CallType{CallAlarm, CallTemp, CallEvent}
+	subscription := app.Subscribe(CallTemp, 4, DropOldest)
+	defer app.Close()
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "未连接到模拟器", func() bool {
+		return app.Status() == device.Connected
+	})
+
+	//防区同步经过模拟器的编码和 SDK 的解码
+	if err := app.SyncZones(); err != nil {
+		t.Fatal(err)
+	}
+	zones := app.GetZones()
+	if len(zones) != 6 {
+		t.Fatalf("同步到 %d 个防区, 期望 6 个", len(zones))
+	}
+	zone := app.GetZone(Id(1, 5))
+	if zone == nil || zone.Name != "通道2-防区2" || zone.ChannelId != 2 || zone.Start != 10 || zone.Finish != 19 || zone.Relay['A'] != "2" {
+		t.Fatalf("得到防区 %+v", zone)
+	}
+	code, err := app.GetDeviceCode()
+	if err != nil || code != "DTS-E2E" {
+		t.Fatalf("得到设备编码 %s %v", code, err)
+	}
+
+	if err := app.Register(); err != nil {
+		t.Fatal(err)
+	}
+	//注册后模拟器按客户端的订阅推送通知
+	temps := simulator.ZoneTemps(server.Zones(), 25)
+	waitFor(t, "模拟器未推送防区温度", func() bool {
+		n, err := server.NotifyZoneTemp(&model.ZoneTempNotify{Zones: temps})
+		return err == nil && n == 1
+	})
+	select {
+	case value := <-subscription.C:
+		temp := value.(ZonesTemp)
+		if temp.DeviceId != "DTS-E2E" || len(temp.Zones) != 6 || temp.Zones[0].Temperature.Avg != temps[0].AverageTemperature {
+			t.Fatalf("得到温度 %+v", temp)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("未收到防区温度")
+	}
+
+	//模拟器断开后状态变为断开
+	_ = server.Close()
+	waitFor(t, "模拟器关闭后未断开", func() bool {
+		return app.Status() == device.Disconnect
+	})
+}
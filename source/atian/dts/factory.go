@@ -0,0 +1,57 @@
+package dts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/zing-dev/atian-tools/source/device"
+)
+
+type (
+	// AppParams App 的构造参数
+	AppParams struct {
+		DTS       DTS        `json:"dts"`
+		Config    Config     `json:"config"`
+		CallTypes []CallType `json:"call_types,omitempty"` //注册的回调,为空时为 CallAlarm 和 CallTemp
+	}
+
+	// Factory App 的设备工厂,注册到 device.Registry 的 device.TypeDTS
+	Factory struct {
+		options []Option
+	}
+)
+
+// NewFactory 实例化 App 的设备工厂,options 应用于每个重建的 App
+func NewFactory(options ...Option) *Factory {
+	return &Factory{options: options}
+}
+
+func (f *Factory) Params(d device.Device) (interface{}, error) {
+	app, ok := d.(*App)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("不支持的 DTS 设备 %T", d))
+	}
+	return AppParams{
+		DTS:       app.DTS,
+		Config:    *app.GetConfig(),
+		CallTypes: app.CallTypes,
+	}, nil
+}
+
+func (f *Factory) New(ctx context.Context, data []byte) (device.Device, error) {
+	var params AppParams
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, err
+	}
+	if params.DTS.Host == "" {
+		return nil, errors.New("DTS 主机地址为空")
+	}
+	if err := ValidateCallTypes(params.CallTypes); err != nil {
+		return nil, err
+	}
+	config := params.Config
+	app := New(ctx, params.DTS, &config, f.options...)
+	app.CallTypes = params.CallTypes
+	return app, nil
+}
@@ -0,0 +1,248 @@
+package dts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"github.com/zing-dev/atian-tools/log"
+	"github.com/zing-dev/atian-tools/source/device"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FleetCallTypes Fleet 合并转发的数据类型
+var FleetCallTypes = []CallType{
+	CallAlarm, CallTemp, CallSignal, CallEvent,
+	CallAlarmEvent, CallZoneChange, CallProfile, CallHotSpot, CallStatus,
+}
+
+type (
+	// FleetHost Fleet 中的一台主机
+	FleetHost struct {
+		App           *App
+		forwards      sync.WaitGroup  //转发协程
+		subscriptions []*Subscription //转发的订阅
+	}
+
+	// FleetStatus Fleet 中所有主机的状态
+	FleetStatus struct {
+		Total     int          `json:"total"`
+		Connected int          `json:"connected"`
+		Dropped   uint64       `json:"dropped"` //所有主机转发时丢弃的数据数
+		Hosts     []HostStatus `json:"hosts"`
+	}
+
+	// Fleet 多台 DTS 主机的管理,运行时增删主机,并将所有主机的数据合并到同一总线
+	// 合并后的数据通过各自的 DTS 字段区分主机
+	Fleet struct {
+		Context context.Context
+		cancel  context.CancelFunc
+
+		Cron      *cron.Cron //所有主机共用的定时任务,可为空
+		CallTypes []CallType //每台主机注册的回调,为空时为 CallAlarm 和 CallTemp
+		Index     *Index     //所有主机的防区坐标索引
+
+		config  Config
+		options []Option
+		bus     *Bus
+		hosts   map[string]*FleetHost
+		closed  bool
+		locker  sync.Mutex
+	}
+)
+
+// NewFleet 实例化 Fleet,每台主机使用 config 的副本,options 应用于每台主机,主机均以自动模式运行
+func NewFleet(ctx context.Context, config Config, options ...Option) *Fleet {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Fleet{
+		Context: ctx,
+		cancel:  cancel,
+		config:  config,
+		options: options,
+		bus:     NewBus(),
+		hosts:   map[string]*FleetHost{},
+		Index:   NewIndex(),
+	}
+}
+
+// Add 添加并运行主机,dts.Id 为 0 时自动分配,主机地址和 Id 均不能重复
+func (f *Fleet) Add(dts DTS) (*App, error) {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	if f.closed {
+		return nil, errors.New("Fleet 已经关闭")
+	}
+	if _, ok := f.hosts[dts.Host]; ok {
+		return nil, errors.New(fmt.Sprintf("主机 %s 已经存在", dts.Host))
+	}
+	ids := map[uint]bool{}
+	for _, host := range f.hosts {
+		ids[host.App.DTS.Id] = true
+	}
+	if dts.Id == 0 {
+		for dts.Id = 1; ids[dts.Id]; dts.Id++ {
+		}
+	} else if ids[dts.Id] {
+		return nil, errors.New(fmt.Sprintf("主机 %s 的 Id %d 已经被使用", dts.Host, dts.Id))
+	}
+
+	config := f.config
+	app := New(f.Context, dts, &config, append([]Option{WithAuto()}, f.options...)...)
+	app.SetConfig(&config)
+	app.Cron = f.Cron
+	app.CallTypes = f.CallTypes
+	host := &FleetHost{App: app}
+	for _, t := range FleetCallTypes {
+		//转发受 Fleet 订阅者的策略影响,缓冲已满时丢弃最旧的数据,不阻塞主机的通知回调
+		subscription := app.Subscribe(t, 64, DropOldest)
+		host.subscriptions = append(host.subscriptions, subscription)
+		host.forwards.Add(1)
+		go func() {
+			defer host.forwards.Done()
+			for value := range subscription.C {
+				//由通道信号计算的防区温度不是实测温度,不更新索引
+				if subscription.Type != CallProfile {
+					f.Index.Apply(value)
+				}
+				f.bus.Publish(subscription.Type, value)
+			}
+		}()
+	}
+	if err := app.Run(); err != nil {
+		_ = app.Close()
+		host.forwards.Wait()
+		return nil, err
+	}
+	f.hosts[dts.Host] = host
+	log.L.Info(fmt.Sprintf("添加主机 %s, Id %d", dts.Host, dts.Id))
+	return app, nil
+}
+
+// Remove 关闭并删除主机,返回时该主机的数据已全部转发
+func (f *Fleet) Remove(host string) error {
+	f.locker.Lock()
+	h, ok := f.hosts[host]
+	delete(f.hosts, host)
+	f.locker.Unlock()
+	if !ok {
+		return errors.New(fmt.Sprintf("主机 %s 不存在", host))
+	}
+	f.close(h)
+	log.L.Info(fmt.Sprintf("删除主机 %s", host))
+	return nil
+}
+
+// App 获取主机
+func (f *Fleet) App(host string) *App {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	if h, ok := f.hosts[host]; ok {
+		return h.App
+	}
+	return nil
+}
+
+// Apps 获取所有主机,按 Id 排序
+func (f *Fleet) Apps() []*App {
+	f.locker.Lock()
+	apps := make([]*App, 0, len(f.hosts))
+	for _, h := range f.hosts {
+		apps = append(apps, h.App)
+	}
+	f.locker.Unlock()
+	sort.Slice(apps, func(i, j int) bool {
+		return apps[i].DTS.Id < apps[j].DTS.Id
+	})
+	return apps
+}
+
+// Dropped 主机转发时因缓冲已满丢弃的数据数
+func (h *FleetHost) Dropped() uint64 {
+	var dropped uint64
+	for _, subscription := range h.subscriptions {
+		dropped += subscription.Dropped()
+	}
+	return dropped
+}
+
+// Dropped 所有主机转发时丢弃的数据数
+func (f *Fleet) Dropped() uint64 {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	var dropped uint64
+	for _, h := range f.hosts {
+		dropped += h.Dropped()
+	}
+	return dropped
+}
+
+// Subscribe 订阅所有主机某一类型的数据
+func (f *Fleet) Subscribe(t CallType, size int, policy DropPolicy) *Subscription {
+	return f.bus.Subscribe(t, size, policy)
+}
+
+// SetConfig 设置所有主机的配置,之后添加的主机也使用该配置
+func (f *Fleet) SetConfig(config Config) {
+	f.locker.Lock()
+	f.config = config
+	f.locker.Unlock()
+	for _, app := range f.Apps() {
+		c := config
+		app.SetConfig(&c)
+	}
+}
+
+// Status 获取所有主机的状态
+func (f *Fleet) Status() FleetStatus {
+	apps := f.Apps()
+	status := FleetStatus{Total: len(apps), Dropped: f.Dropped(), Hosts: make([]HostStatus, 0, len(apps))}
+	for _, app := range apps {
+		s := app.Status()
+		if s == device.Connected {
+			status.Connected++
+		}
+		status.Hosts = append(status.Hosts, HostStatus{DTS: app.DTS, Status: s, At: &device.TimeLocal{Time: time.Now()}})
+	}
+	return status
+}
+
+// Close 关闭所有主机,等待所有数据转发完成后关闭总线
+func (f *Fleet) Close() error {
+	f.locker.Lock()
+	if f.closed {
+		f.locker.Unlock()
+		return nil
+	}
+	f.closed = true
+	hosts := f.hosts
+	f.hosts = map[string]*FleetHost{}
+	f.locker.Unlock()
+
+	var wg sync.WaitGroup
+	for _, h := range hosts {
+		wg.Add(1)
+		go func(h *FleetHost) {
+			defer wg.Done()
+			f.close(h)
+		}(h)
+	}
+	wg.Wait()
+	f.cancel()
+	f.bus.Close()
+	return nil
+}
+
+// close 关闭主机并等待转发完成,发布主机断开的状态
+func (f *Fleet) close(h *FleetHost) {
+	_ = h.App.Close()
+	h.forwards.Wait()
+	if dropped := h.Dropped(); dropped > 0 {
+		log.L.Warn(fmt.Sprintf("主机 %s 转发时共丢弃 %d 条数据", h.App.DTS.Host, dropped))
+	}
+	for _, zone := range h.App.GetZones() {
+		f.Index.Remove(zone.Id)
+	}
+	f.bus.Publish(CallStatus, HostStatus{DTS: h.App.DTS, Status: device.Disconnect, At: &device.TimeLocal{Time: time.Now()}})
+}
@@ -0,0 +1,270 @@
+package dts
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHistorySize 未设置 Config.HistorySize 时每个防区保留的温度数
+	DefaultHistorySize = 1024
+	// initialHistorySize 环形缓冲的初始容量,之后按需倍增到 HistorySize
+	initialHistorySize = 16
+)
+
+type (
+	// Sample 防区某一时刻的温度
+	Sample struct {
+		At  time.Time `json:"at"`
+		Max float32   `json:"max"`
+		Avg float32   `json:"avg"`
+		Min float32   `json:"min"`
+	}
+
+	// Stats 防区在一段时间内的温度统计
+	Stats struct {
+		Count int       `json:"count"`
+		From  time.Time `json:"from"`
+		To    time.Time `json:"to"`
+		Max   float32   `json:"max"` //最高温度
+		Avg   float32   `json:"avg"` //平均温度的平均值
+		Min   float32   `json:"min"` //最低温度
+	}
+
+	// ring 环形缓冲,按时间顺序保存温度,容量按需增长到 limit
+	ring struct {
+		samples []Sample
+		head    int //最早的温度所在位置
+		size    int
+		limit   int //最大容量
+	}
+
+	// History 防区温度历史,每个防区保留最近 maxAge 时间内最多 maxSize 个温度,并发读安全
+	History struct {
+		locker  sync.RWMutex
+		maxAge  func() time.Duration
+		maxSize func() int
+		zones   map[uint]*ring
+	}
+)
+
+// NewHistory 实例化防区温度历史
+// maxAge 返回温度的最长保留时间,为 0 时只按数量限制,maxSize 返回每个防区保留的最多温度数
+func NewHistory(maxAge func() time.Duration, maxSize func() int) *History {
+	return &History{
+		maxAge:  maxAge,
+		maxSize: maxSize,
+		zones:   map[uint]*ring{},
+	}
+}
+
+// Add 记录防区温度,早于该防区最近一次温度的数据将被忽略
+func (h *History) Add(id uint, sample Sample) {
+	size := h.maxSize()
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+	h.locker.Lock()
+	defer h.locker.Unlock()
+	r, ok := h.zones[id]
+	if !ok {
+		r = &ring{limit: size}
+		h.zones[id] = r
+	}
+	if r.limit != size {
+		r.resize(size)
+	}
+	if r.size > 0 && sample.At.Before(r.at(r.size-1).At) {
+		return
+	}
+	r.push(sample)
+	if age := h.maxAge(); age > 0 {
+		for r.size > 0 && sample.At.Sub(r.at(0).At) > age {
+			r.shift()
+		}
+	}
+}
+
+// Remove 删除防区的温度历史
+func (h *History) Remove(id uint) {
+	h.locker.Lock()
+	defer h.locker.Unlock()
+	delete(h.zones, id)
+}
+
+// Zones 获取有温度历史的防区 Id
+func (h *History) Zones() []uint {
+	h.locker.RLock()
+	defer h.locker.RUnlock()
+	ids := make([]uint, 0, len(h.zones))
+	for id := range h.zones {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// Len 获取防区保留的温度数
+func (h *History) Len(id uint) int {
+	h.locker.RLock()
+	defer h.locker.RUnlock()
+	if r, ok := h.zones[id]; ok {
+		return r.size
+	}
+	return 0
+}
+
+// Range 获取防区在 [from, to] 时间范围内的温度,按时间排序
+func (h *History) Range(id uint, from, to time.Time) []Sample {
+	h.locker.RLock()
+	defer h.locker.RUnlock()
+	r, ok := h.zones[id]
+	if !ok {
+		return nil
+	}
+	return r.between(from, to)
+}
+
+// Last 获取防区最近的 n 个温度,按时间排序
+func (h *History) Last(id uint, n int) []Sample {
+	h.locker.RLock()
+	defer h.locker.RUnlock()
+	r, ok := h.zones[id]
+	if !ok || n <= 0 {
+		return nil
+	}
+	if n > r.size {
+		n = r.size
+	}
+	samples := make([]Sample, 0, n)
+	for i := r.size - n; i < r.size; i++ {
+		samples = append(samples, r.at(i))
+	}
+	return samples
+}
+
+// Stats 统计防区最近一次温度之前 window 时间内的温度,没有温度时返回 false
+// 时间窗口以最近一次温度的时间为终点,不受温度时间与本机时间偏差的影响
+func (h *History) Stats(id uint, window time.Duration) (Stats, bool) {
+	h.locker.RLock()
+	var samples []Sample
+	if r, ok := h.zones[id]; ok && r.size > 0 {
+		last := r.at(r.size - 1).At
+		samples = r.between(last.Add(-window), last)
+	}
+	h.locker.RUnlock()
+	if len(samples) == 0 {
+		return Stats{}, false
+	}
+	stats := Stats{
+		Count: len(samples),
+		From:  samples[0].At,
+		To:    samples[len(samples)-1].At,
+		Max:   samples[0].Max,
+		Min:   samples[0].Min,
+	}
+	var sum float64
+	for _, sample := range samples {
+		if sample.Max > stats.Max {
+			stats.Max = sample.Max
+		}
+		if sample.Min < stats.Min {
+			stats.Min = sample.Min
+		}
+		sum += float64(sample.Avg)
+	}
+	stats.Avg = float32(sum / float64(len(samples)))
+	return stats, true
+}
+
+func (r *ring) at(i int) Sample {
+	return r.samples[(r.head+i)%len(r.samples)]
+}
+
+// between 获取 [from, to] 时间范围内的温度
+func (r *ring) between(from, to time.Time) []Sample {
+	start := sort.Search(r.size, func(i int) bool {
+		return !r.at(i).At.Before(from)
+	})
+	var samples []Sample
+	for i := start; i < r.size && !r.at(i).At.After(to); i++ {
+		samples = append(samples, r.at(i))
+	}
+	return samples
+}
+
+func (r *ring) push(sample Sample) {
+	if r.size == len(r.samples) {
+		if len(r.samples) < r.limit {
+			r.grow()
+		} else {
+			r.shift()
+		}
+	}
+	r.samples[(r.head+r.size)%len(r.samples)] = sample
+	r.size++
+}
+
+func (r *ring) shift() {
+	r.head = (r.head + 1) % len(r.samples)
+	r.size--
+}
+
+// grow 容量倍增,不超过 limit
+func (r *ring) grow() {
+	size := len(r.samples) * 2
+	if size < initialHistorySize {
+		size = initialHistorySize
+	}
+	if size > r.limit {
+		size = r.limit
+	}
+	r.copy(size, 0)
+}
+
+// resize 调整最大容量,容量变小时丢弃最早的温度
+func (r *ring) resize(limit int) {
+	skip := 0
+	if r.size > limit {
+		skip = r.size - limit
+	}
+	size := len(r.samples)
+	if size > limit {
+		size = limit
+	}
+	r.limit = limit
+	r.copy(size, skip)
+}
+
+// copy 跳过最早的 skip 个温度,复制到容量为 size 的新缓冲
+func (r *ring) copy(size, skip int) {
+	samples := make([]Sample, size)
+	n := 0
+	for i := skip; i < r.size; i++ {
+		samples[n] = r.at(i)
+		n++
+	}
+	r.samples, r.head, r.size = samples, 0, n
+}
+
+// history 记录温度更新中防区的温度
+func (a *App) history(temp ZonesTemp) {
+	config := a.GetConfig()
+	if config.HistorySec == 0 && config.HistorySize == 0 {
+		return
+	}
+	for _, zone := range temp.Zones {
+		if zone.Temperature == nil {
+			continue
+		}
+		a.History.Add(zone.Id, Sample{
+			At:  temp.CreatedAt.Time,
+			Max: zone.Temperature.Max,
+			Avg: zone.Temperature.Avg,
+			Min: zone.Temperature.Min,
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package dts
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func newHistory(age time.Duration, size int) *History {
+	return NewHistory(func() time.Duration {
+		return age
+	}, func() int {
+		return size
+	})
+}
+
+// fill 每秒记录一个温度,温度值为序号
+func fill(h *History, id uint, begin time.Time, n int) {
+	for i := 0; i < n; i++ {
+		v := float32(i)
+		h.Add(id, Sample{At: begin.Add(time.Duration(i) * time.Second), Max: v, Avg: v, Min: v})
+	}
+}
+
+func TestHistoryRange(t *testing.T) {
+	h := newHistory(0, 100)
+	begin := time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local)
+	fill(h, 1, begin, 10)
+	samples := h.Range(1, begin.Add(2*time.Second), begin.Add(5*time.Second))
+	if len(samples) != 4 || samples[0].Max != 2 || samples[3].Max != 5 {
+		t.Fatalf("得到 %v", samples)
+	}
+	if samples := h.Range(1, begin.Add(time.Hour), begin.Add(2*time.Hour)); len(samples) != 0 {
+		t.Fatalf("范围外应为空, 得到 %v", samples)
+	}
+	if samples := h.Range(2, begin, begin.Add(time.Hour)); samples != nil {
+		t.Fatalf("不存在的防区应为空, 得到 %v", samples)
+	}
+	//早于最近一次温度的数据被忽略
+	h.Add(1, Sample{At: begin, Max: 100})
+	if h.Len(1) != 10 {
+		t.Fatalf("得到 %d 个温度", h.Len(1))
+	}
+}
+
+func TestHistoryLast(t *testing.T) {
+	h := newHistory(0, 5)
+	begin := time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local)
+	fill(h, 1, begin, 8)
+	if h.Len(1) != 5 {
+		t.Fatalf("应只保留 5 个温度, 得到 %d", h.Len(1))
+	}
+	samples := h.Last(1, 3)
+	if len(samples) != 3 || samples[0].Max != 5 || samples[2].Max != 7 {
+		t.Fatalf("得到 %v", samples)
+	}
+	if samples := h.Last(1, 10); len(samples) != 5 || samples[0].Max != 3 {
+		t.Fatalf("得到 %v", samples)
+	}
+	if samples := h.Last(1, 0); samples != nil {
+		t.Fatalf("得到 %v", samples)
+	}
+}
+
+func TestHistoryGrow(t *testing.T) {
+	h := newHistory(0, 100)
+	begin := time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local)
+	fill(h, 1, begin, 3)
+	if capacity := len(h.zones[1].samples); capacity != initialHistorySize {
+		t.Fatalf("初始容量 %d, 期望 %d", capacity, initialHistorySize)
+	}
+	fill(h, 1, begin.Add(time.Minute), 40)
+	if capacity := len(h.zones[1].samples); capacity != 64 {
+		t.Fatalf("容量 %d, 期望 64", capacity)
+	}
+	fill(h, 1, begin.Add(time.Hour), 200)
+	if capacity := len(h.zones[1].samples); capacity != 100 {
+		t.Fatalf("容量 %d, 期望不超过 100", capacity)
+	}
+	samples := h.Last(1, 100)
+	if len(samples) != 100 || samples[0].Max != 100 || samples[99].Max != 199 {
+		t.Fatalf("得到 %d 个温度 %v ... %v", len(samples), samples[0], samples[len(samples)-1])
+	}
+}
+
+func TestHistoryResize(t *testing.T) {
+	size := 10
+	h := NewHistory(func() time.Duration {
+		return 0
+	}, func() int {
+		return size
+	})
+	begin := time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local)
+	fill(h, 1, begin, 10)
+	size = 4
+	h.Add(1, Sample{At: begin.Add(time.Minute), Max: 100})
+	samples := h.Last(1, 10)
+	if len(samples) != 4 || samples[0].Max != 7 || samples[3].Max != 100 {
+		t.Fatalf("得到 %v", samples)
+	}
+}
+
+func TestHistoryMaxAge(t *testing.T) {
+	h := newHistory(5*time.Second, 100)
+	begin := time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local)
+	fill(h, 1, begin, 10)
+	samples := h.Last(1, 100)
+	if len(samples) != 6 || samples[0].Max != 4 {
+		t.Fatalf("得到 %v", samples)
+	}
+}
+
+func TestHistoryStats(t *testing.T) {
+	h := newHistory(0, 100)
+	//温度的时间远早于当前时间,窗口以最近一次温度为终点
+	begin := time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local)
+	fill(h, 1, begin, 10)
+	stats, ok := h.Stats(1, 3*time.Second)
+	if !ok {
+		t.Fatal("期望有统计")
+	}
+	if stats.Count != 4 || stats.Max != 9 || stats.Min != 6 || stats.Avg != 7.5 {
+		t.Fatalf("得到 %+v", stats)
+	}
+	if !stats.From.Equal(begin.Add(6*time.Second)) || !stats.To.Equal(begin.Add(9*time.Second)) {
+		t.Fatalf("得到 %+v", stats)
+	}
+	if _, ok := h.Stats(2, time.Minute); ok {
+		t.Fatal("不存在的防区不应有统计")
+	}
+}
+
+func TestHistoryConcurrent(t *testing.T) {
+	h := newHistory(time.Minute, 50)
+	begin := time.Now()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		fill(h, 1, begin, 500)
+	}()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				samples := h.Last(1, 20)
+				for k := 1; k < len(samples); k++ {
+					if samples[k].At.Before(samples[k-1].At) {
+						t.Error("温度未按时间排序")
+						return
+					}
+				}
+				h.Range(1, begin, begin.Add(time.Hour))
+				h.Stats(1, 10*time.Second)
+				h.Zones()
+			}
+		}()
+	}
+	wg.Wait()
+	if h.Len(1) != 50 {
+		t.Fatalf("得到 %d 个温度", h.Len(1))
+	}
+}
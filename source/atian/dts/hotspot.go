@@ -0,0 +1,268 @@
+package dts
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/zing-dev/atian-tools/source/device"
+	"math"
+	"sort"
+	"sync"
+)
+
+const (
+	_            HotSpotEventType = iota
+	HotSpotNew                    //新出现的热点
+	HotSpotMoved                  //热点位置移动
+	HotSpotGone                   //热点消失
+)
+
+type (
+	// HotSpotEventType 热点事件类型
+	HotSpotEventType byte
+
+	// HotSpotConfig 热点检测配置
+	HotSpotConfig struct {
+		MinHeight  float32 //峰值高于移动基线的最小温度,默认 5
+		MinWidth   float32 //热点的最小宽度 米,默认 1
+		Resolution float32 //距离分辨率 米,间隔小于该值的峰合并为一个热点,相邻两次信号中距离差小于该值的热点视为同一热点,默认 2
+		Window     float32 //移动基线的窗口宽度 米,默认 50
+		MinMove    float32 //热点移动的最小距离 米,与上一次通知的位置相差小于该值时不通知移动,默认 Resolution/2
+	}
+
+	// HotSpot 通道上的局部温度峰值
+	HotSpot struct {
+		ChannelId   int32   `json:"channel_id"`
+		Distance    float32 `json:"distance"`    //峰值位置 米
+		Temperature float32 `json:"temperature"` //峰值温度
+		Baseline    float32 `json:"baseline"`    //峰值位置的基线温度
+		Start       float32 `json:"start"`       //热点开始位置 米
+		Finish      float32 `json:"finish"`      //热点结束位置 米
+		Zone        *Zone   `json:"zone,omitempty"`
+
+		reported float32 //上一次通知出现或移动时的位置
+	}
+
+	// HotSpotEvent 热点事件
+	HotSpotEvent struct {
+		Type     HotSpotEventType  `json:"type"`
+		DTS      DTS               `json:"dts,omitempty"`
+		HotSpot  HotSpot           `json:"hot_spot"`
+		Previous *HotSpot          `json:"previous,omitempty"` //移动或消失前的热点
+		At       *device.TimeLocal `json:"at"`
+	}
+
+	// HotSpotDetector 热点检测器,检测通道信号中的热点并跟踪热点的出现,移动和消失
+	HotSpotDetector struct {
+		config HotSpotConfig
+		locker sync.Mutex
+		spots  map[int32][]HotSpot //每个通道上一次检测到的热点
+	}
+)
+
+func (t HotSpotEventType) String() string {
+	switch t {
+	case HotSpotNew:
+		return "新热点"
+	case HotSpotMoved:
+		return "热点移动"
+	case HotSpotGone:
+		return "热点消失"
+	default:
+		return "未知事件"
+	}
+}
+
+func (e *HotSpotEvent) JSON() string {
+	data, _ := json.Marshal(e)
+	return string(data)
+}
+
+// WithHotSpot 启用热点检测,需注册 CallSignal,热点事件发布到 CallHotSpot
+func WithHotSpot(config HotSpotConfig) Option {
+	return func(a *App) {
+		a.HotSpots = NewHotSpotDetector(config)
+	}
+}
+
+func NewHotSpotDetector(config HotSpotConfig) *HotSpotDetector {
+	if config.MinHeight <= 0 {
+		config.MinHeight = 5
+	}
+	if config.MinWidth <= 0 {
+		config.MinWidth = 1
+	}
+	if config.Resolution <= 0 {
+		config.Resolution = 2
+	}
+	if config.Window <= 0 {
+		config.Window = 50
+	}
+	if config.MinMove <= 0 {
+		config.MinMove = config.Resolution / 2
+	}
+	return &HotSpotDetector{
+		config: config,
+		spots:  map[int32][]HotSpot{},
+	}
+}
+
+// Detect 检测通道信号中的热点,zones 用于查找热点所在的防区
+func (d *HotSpotDetector) Detect(signal ChannelSignal, zones Zones) ([]HotSpot, error) {
+	scale, err := SignalScale(signal)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		data     = signal.Signal
+		n        = len(data)
+		half     = int(d.config.Window / scale / 2)
+		sum      = make([]float64, n+1)
+		baseline = make([]float32, n)
+		spots    []HotSpot
+	)
+	for i, v := range data {
+		sum[i+1] = sum[i] + float64(v)
+	}
+	for i := range data {
+		l, r := i-half, i+half+1
+		if l < 0 {
+			l = 0
+		}
+		if r > n {
+			r = n
+		}
+		baseline[i] = float32((sum[r] - sum[l]) / float64(r-l))
+	}
+
+	for i := 0; i < n; {
+		if data[i]-baseline[i] < d.config.MinHeight {
+			i++
+			continue
+		}
+		start, peak := i, i
+		for ; i < n && data[i]-baseline[i] >= d.config.MinHeight; i++ {
+			if data[i] > data[peak] {
+				peak = i
+			}
+		}
+		spot := HotSpot{
+			ChannelId:   signal.ChannelId,
+			Distance:    float32(peak) * scale,
+			Temperature: data[peak],
+			Baseline:    baseline[peak],
+			Start:       float32(start) * scale,
+			Finish:      float32(i-1) * scale,
+		}
+		//间隔小于距离分辨率的峰合并
+		if last := len(spots) - 1; last >= 0 && spot.Start-spots[last].Finish < d.config.Resolution {
+			if spot.Temperature > spots[last].Temperature {
+				spot.Start = spots[last].Start
+				spots[last] = spot
+			} else {
+				spots[last].Finish = spot.Finish
+			}
+			continue
+		}
+		spots = append(spots, spot)
+	}
+
+	list := spots[:0]
+	for _, spot := range spots {
+		if spot.Finish-spot.Start+scale < d.config.MinWidth {
+			continue
+		}
+		for _, zone := range zones {
+			if int32(zone.ChannelId) == spot.ChannelId && zone.Start <= spot.Distance && spot.Distance <= zone.Finish {
+				spot.Zone = &Zone{BaseZone: zone.BaseZone, Coordinate: zone.Coordinate}
+				break
+			}
+		}
+		list = append(list, spot)
+	}
+	return list, nil
+}
+
+// Track 检测通道信号中的热点,并与该通道上一次的热点比较,返回热点的出现,移动和消失事件
+// 距离差不超过 Resolution 的热点按距离从近到远配对,配对的热点与上一次通知的位置相差不小于 MinMove 时通知移动
+func (d *HotSpotDetector) Track(signal ChannelSignal, zones Zones) ([]HotSpotEvent, error) {
+	spots, err := d.Detect(signal, zones)
+	if err != nil {
+		return nil, err
+	}
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	type pair struct {
+		spot, previous int
+		distance       float32
+	}
+	var (
+		events   []HotSpotEvent
+		pairs    []pair
+		previous = d.spots[signal.ChannelId]
+		matched  = make([]int, len(spots)) //配对的上一次热点的下标,-1 为新热点
+		used     = make([]bool, len(previous))
+	)
+	for i, spot := range spots {
+		matched[i] = -1
+		for j, p := range previous {
+			if diff := float32(math.Abs(float64(spot.Distance - p.Distance))); diff <= d.config.Resolution {
+				pairs = append(pairs, pair{spot: i, previous: j, distance: diff})
+			}
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return pairs[i].distance < pairs[j].distance
+	})
+	for _, p := range pairs {
+		if matched[p.spot] >= 0 || used[p.previous] {
+			continue
+		}
+		matched[p.spot], used[p.previous] = p.previous, true
+	}
+	for i := range spots {
+		spot := &spots[i]
+		if matched[i] < 0 {
+			spot.reported = spot.Distance
+			events = append(events, HotSpotEvent{Type: HotSpotNew, HotSpot: *spot, At: signal.CreatedAt})
+			continue
+		}
+		p := previous[matched[i]]
+		spot.reported = p.reported
+		if float32(math.Abs(float64(spot.Distance-p.reported))) >= d.config.MinMove {
+			spot.reported = spot.Distance
+			events = append(events, HotSpotEvent{Type: HotSpotMoved, HotSpot: *spot, Previous: &p, At: signal.CreatedAt})
+		}
+	}
+	for i, p := range previous {
+		if !used[i] {
+			p := p
+			events = append(events, HotSpotEvent{Type: HotSpotGone, HotSpot: p, Previous: &p, At: signal.CreatedAt})
+		}
+	}
+	d.spots[signal.ChannelId] = spots
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].HotSpot.Distance < events[j].HotSpot.Distance
+	})
+	return events, nil
+}
+
+// HotSpots 获取通道当前的热点
+func (d *HotSpotDetector) HotSpots(channelId int32) []HotSpot {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	return append([]HotSpot(nil), d.spots[channelId]...)
+}
+
+// hotSpot 检测通道信号中的热点并发布热点事件
+func (a *App) hotSpot(signal ChannelSignal) {
+	events, err := a.HotSpots.Track(signal, a.channelZones(signal.ChannelId))
+	if err != nil {
+		a.setMessage(fmt.Sprintf("主机为 %s 通道 %d 检测热点失败: %s", a.DTS.Host, signal.ChannelId, err), logrus.WarnLevel)
+		return
+	}
+	for _, event := range events {
+		event.DTS = a.DTS
+		a.bus.Publish(CallHotSpot, event)
+	}
+}
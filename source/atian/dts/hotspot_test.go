@@ -0,0 +1,173 @@
+package dts
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// hotSignal 生成 100 米,每米一个点,基础温度 20 的通道 1 信号,peaks 为各点的温度
+func hotSignal(peaks map[int]float32) ChannelSignal {
+	signal := ChannelSignal{ChannelId: 1, RealLength: 100, Signal: make([]float32, 101)}
+	for i := range signal.Signal {
+		signal.Signal[i] = 20
+	}
+	for i, v := range peaks {
+		signal.Signal[i] = v
+	}
+	return signal
+}
+
+func TestHotSpotDetect(t *testing.T) {
+	zones := Zones{
+		{BaseZone: BaseZone{Id: 1, ChannelId: 1, Start: 25, Finish: 35}},
+		{BaseZone: BaseZone{Id: 2, ChannelId: 2, Start: 60, Finish: 80}},
+	}
+	tests := []struct {
+		name   string
+		config HotSpotConfig
+		peaks  map[int]float32
+		spots  []HotSpot //只比较位置,温度,基线和防区
+	}{
+		{name: "没有热点", peaks: nil},
+		{name: "低于最小高度", peaks: map[int]float32{30: 24, 31: 24}},
+		{
+			name:  "单个热点",
+			peaks: map[int]float32{29: 30, 30: 40, 31: 30},
+			spots: []HotSpot{{Distance: 30, Temperature: 40, Baseline: 20 + 40.0/51, Start: 29, Finish: 31, Zone: &Zone{BaseZone: BaseZone{Id: 1}}}},
+		},
+		{name: "窄于最小宽度", config: HotSpotConfig{MinWidth: 5}, peaks: map[int]float32{29: 30, 30: 40, 31: 30}},
+		{
+			name:   "提高最小高度",
+			config: HotSpotConfig{MinHeight: 15},
+			peaks:  map[int]float32{29: 30, 30: 40, 31: 30},
+			spots:  []HotSpot{{Distance: 30, Temperature: 40, Baseline: 20 + 40.0/51, Start: 30, Finish: 30, Zone: &Zone{BaseZone: BaseZone{Id: 1}}}},
+		},
+		{
+			name:   "间隔小于分辨率的峰合并",
+			config: HotSpotConfig{Resolution: 3},
+			peaks:  map[int]float32{30: 40, 31: 40, 33: 45, 34: 40},
+			spots:  []HotSpot{{Distance: 33, Temperature: 45, Baseline: 20 + 85.0/51, Start: 30, Finish: 34, Zone: &Zone{BaseZone: BaseZone{Id: 1}}}},
+		},
+		{
+			name:  "多个热点",
+			peaks: map[int]float32{10: 40, 11: 40, 70: 50, 71: 50},
+			spots: []HotSpot{
+				{Distance: 10, Temperature: 40, Baseline: 20 + 40.0/36, Start: 10, Finish: 11},
+				{Distance: 70, Temperature: 50, Baseline: 20 + 60.0/51, Start: 70, Finish: 71},
+			},
+		},
+		{
+			name:   "按窗口计算基线",
+			config: HotSpotConfig{Window: 10},
+			peaks:  map[int]float32{50: 40, 51: 40},
+			spots:  []HotSpot{{Distance: 50, Temperature: 40, Baseline: 20 + 40.0/11, Start: 50, Finish: 51}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spots, err := NewHotSpotDetector(test.config).Detect(hotSignal(test.peaks), zones)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(spots) != len(test.spots) {
+				t.Fatalf("检测到 %+v, 期望 %d 个热点", spots, len(test.spots))
+			}
+			for k, spot := range spots {
+				expect := test.spots[k]
+				if spot.ChannelId != 1 || spot.Distance != expect.Distance || spot.Temperature != expect.Temperature ||
+					spot.Start != expect.Start || spot.Finish != expect.Finish || math.Abs(float64(spot.Baseline-expect.Baseline)) > 0.01 {
+					t.Fatalf("第 %d 个热点 %+v, 期望 %+v", k+1, spot, expect)
+				}
+				if (spot.Zone == nil) != (expect.Zone == nil) || spot.Zone != nil && spot.Zone.Id != expect.Zone.Id {
+					t.Fatalf("第 %d 个热点的防区 %+v, 期望 %+v", k+1, spot.Zone, expect.Zone)
+				}
+			}
+		})
+	}
+	if _, err := NewHotSpotDetector(HotSpotConfig{}).Detect(ChannelSignal{ChannelId: 1, Signal: []float32{20}}, nil); err == nil {
+		t.Fatal("无效的信号应返回错误")
+	}
+}
+
+// events 将热点事件格式化为 "类型 位置",移动时为 "类型 之前的位置->位置"
+func events(list []HotSpotEvent) []string {
+	var result []string
+	for _, event := range list {
+		switch event.Type {
+		case HotSpotMoved:
+			result = append(result, fmt.Sprintf("%s %g->%g", event.Type, event.Previous.Distance, event.HotSpot.Distance))
+		default:
+			result = append(result, fmt.Sprintf("%s %g", event.Type, event.HotSpot.Distance))
+		}
+	}
+	return result
+}
+
+func TestHotSpotTrack(t *testing.T) {
+	tests := []struct {
+		name   string
+		config HotSpotConfig
+		steps  [][]int    //每次信号中单点热点的位置
+		events [][]string //每次信号的热点事件
+	}{
+		{
+			name:   "出现和消失",
+			steps:  [][]int{{30}, {30, 60}, {60}, nil},
+			events: [][]string{{"新热点 30"}, {"新热点 60"}, {"热点消失 30"}, {"热点消失 60"}},
+		},
+		{
+			name:   "移动",
+			steps:  [][]int{{30}, {33}, {37}},
+			events: [][]string{{"新热点 30"}, {"热点移动 30->33"}, {"热点移动 33->37"}},
+		},
+		{
+			name:   "超出分辨率视为新热点",
+			steps:  [][]int{{30}, {35}},
+			events: [][]string{{"新热点 30"}, {"热点消失 30", "新热点 35"}},
+		},
+		{
+			name:   "小于最小移动距离时不通知",
+			steps:  [][]int{{30}, {31}, {32}, {33}, {32}},
+			events: [][]string{{"新热点 30"}, nil, {"热点移动 31->32"}, nil, nil},
+		},
+		{
+			name:   "设置最小移动距离",
+			config: HotSpotConfig{Resolution: 4, MinMove: 1},
+			steps:  [][]int{{30}, {31}, {31}},
+			events: [][]string{{"新热点 30"}, {"热点移动 30->31"}, nil},
+		},
+		{
+			//按顺序配对时 34 先与最近的 37 配对,38 成为新热点,30 消失
+			name:   "按距离从近到远配对",
+			steps:  [][]int{{30, 37}, {34, 38}},
+			events: [][]string{{"新热点 30", "新热点 37"}, {"热点移动 30->34"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := test.config
+			if config.Resolution == 0 {
+				config.Resolution = 4
+			}
+			d := NewHotSpotDetector(config)
+			for k, step := range test.steps {
+				peaks := map[int]float32{}
+				for _, i := range step {
+					peaks[i] = 40
+				}
+				list, err := d.Track(hotSignal(peaks), nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result := events(list); !reflect.DeepEqual(result, test.events[k]) {
+					t.Fatalf("第 %d 次信号的事件 %q, 期望 %q", k+1, result, test.events[k])
+				}
+				if spots := d.HotSpots(1); len(spots) != len(step) {
+					t.Fatalf("第 %d 次信号后有 %d 个热点, 期望 %d 个", k+1, len(spots), len(step))
+				}
+			}
+		})
+	}
+}
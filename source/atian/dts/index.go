@@ -0,0 +1,332 @@
+package dts
+
+import (
+	"sort"
+	"sync"
+)
+
+const (
+	CoordinateAll       CoordinateLevel = iota //不限
+	CoordinateWarehouse                        //仓库
+	CoordinateGroup                            //组
+	CoordinateRow                              //行
+	CoordinateColumn                           //列
+	CoordinateLayer                            //层
+)
+
+type (
+	// CoordinateLevel 防区坐标的层级,依次为 仓库 > 组 > 行 > 列 > 层
+	CoordinateLevel byte
+
+	// Aggregate 一组防区的温度统计,只统计有温度的防区
+	Aggregate struct {
+		Coordinate Coordinate `json:"coordinate"` //统计范围
+		Zones      int        `json:"zones"`      //范围内的防区数
+		Count      int        `json:"count"`      //有温度的防区数
+		Max        float32    `json:"max"`        //最高温度
+		Avg        float32    `json:"avg"`        //平均温度的平均值
+		Min        float32    `json:"min"`        //最低温度
+		MaxZone    uint       `json:"max_zone"`   //最高温度所在的防区 Id
+	}
+
+	// Index 防区坐标索引,支持按坐标前缀查找,查找相邻防区及按层级统计温度
+	// 只索引有坐标的防区,通过 Sync,Apply 与防区和温度的更新保持同步
+	Index struct {
+		locker   sync.RWMutex
+		zones    map[uint]*Zone
+		prefixes map[CoordinateLevel]map[Coordinate]map[uint]struct{} //每一层级的坐标前缀对应的防区
+	}
+)
+
+// Prefix 截取坐标到指定层级,更低层级的值置空
+func (c Coordinate) Prefix(level CoordinateLevel) Coordinate {
+	switch level {
+	case CoordinateAll:
+		return Coordinate{}
+	case CoordinateWarehouse:
+		return Coordinate{Warehouse: c.Warehouse}
+	case CoordinateGroup:
+		return Coordinate{Warehouse: c.Warehouse, Group: c.Group}
+	case CoordinateRow:
+		return Coordinate{Warehouse: c.Warehouse, Group: c.Group, Row: c.Row}
+	case CoordinateColumn:
+		return Coordinate{Warehouse: c.Warehouse, Group: c.Group, Row: c.Row, Column: c.Column}
+	default:
+		return c
+	}
+}
+
+func NewIndex() *Index {
+	index := &Index{}
+	index.reset()
+	return index
+}
+
+func (i *Index) reset() {
+	i.zones = map[uint]*Zone{}
+	i.prefixes = map[CoordinateLevel]map[Coordinate]map[uint]struct{}{}
+	for level := CoordinateAll; level <= CoordinateLayer; level++ {
+		i.prefixes[level] = map[Coordinate]map[uint]struct{}{}
+	}
+}
+
+// Sync 使用防区集合重建索引,已有防区的温度保留
+func (i *Index) Sync(zones map[uint]*Zone) {
+	i.locker.Lock()
+	defer i.locker.Unlock()
+	old := i.zones
+	i.reset()
+	for _, zone := range zones {
+		if o, ok := old[zone.Id]; ok && zone.Temperature == nil {
+			zone = zone.Clone()
+			zone.Temperature = o.Temperature
+		}
+		i.put(zone)
+	}
+}
+
+// Put 添加或更新防区,防区坐标为空时从索引中删除
+func (i *Index) Put(zone *Zone) {
+	i.locker.Lock()
+	defer i.locker.Unlock()
+	if o, ok := i.zones[zone.Id]; ok && zone.Temperature == nil {
+		zone = zone.Clone()
+		zone.Temperature = o.Temperature
+	}
+	i.remove(zone.Id)
+	i.put(zone)
+}
+
+// Remove 从索引中删除防区
+func (i *Index) Remove(id uint) {
+	i.locker.Lock()
+	defer i.locker.Unlock()
+	i.remove(id)
+}
+
+// Temp 更新防区温度,未索引的防区忽略
+func (i *Index) Temp(zones Zones) {
+	i.locker.Lock()
+	defer i.locker.Unlock()
+	for _, zone := range zones {
+		if z, ok := i.zones[zone.Id]; ok && zone.Temperature != nil {
+			temperature := *zone.Temperature
+			z.Temperature = &temperature
+		}
+	}
+}
+
+// Apply 根据订阅的数据更新索引,处理 ZoneChange 和 ZonesTemp,其他类型忽略
+func (i *Index) Apply(value interface{}) {
+	switch v := value.(type) {
+	case ZoneChange:
+		if v.Type == ZoneRemoved {
+			i.Remove(v.Zone.Id)
+		} else {
+			i.Put(v.Zone)
+		}
+	case ZonesTemp:
+		i.Temp(v.Zones)
+	}
+}
+
+// Zone 获取索引中的防区
+func (i *Index) Zone(id uint) *Zone {
+	i.locker.RLock()
+	defer i.locker.RUnlock()
+	return i.zones[id].Clone()
+}
+
+// Find 查找坐标前缀匹配的防区,level 为前缀的层级,按坐标排序
+func (i *Index) Find(c Coordinate, level CoordinateLevel) Zones {
+	i.locker.RLock()
+	defer i.locker.RUnlock()
+	return i.find(c, level)
+}
+
+// Neighbours 查找与防区相邻的防区,即同一仓库和组中行,列或层相差 1 且其他坐标相同的防区
+func (i *Index) Neighbours(id uint) Zones {
+	i.locker.RLock()
+	defer i.locker.RUnlock()
+	zone, ok := i.zones[id]
+	if !ok {
+		return nil
+	}
+	c := *zone.Coordinate
+	var candidates []Coordinate
+	for _, d := range []int{-1, 1} {
+		if row := int(c.Row) + d; row > 0 {
+			n := c
+			n.Row = uint16(row)
+			candidates = append(candidates, n)
+		}
+		if column := int(c.Column) + d; column > 0 {
+			n := c
+			n.Column = uint16(column)
+			candidates = append(candidates, n)
+		}
+		if layer := int(c.Layer) + d; layer > 0 {
+			n := c
+			n.Layer = uint16(layer)
+			candidates = append(candidates, n)
+		}
+	}
+	var zones Zones
+	for _, candidate := range candidates {
+		zones = append(zones, i.find(candidate, CoordinateLayer)...)
+	}
+	sortByCoordinate(zones)
+	return zones
+}
+
+// Aggregate 统计坐标前缀匹配的防区温度,没有匹配的防区时返回 false
+func (i *Index) Aggregate(c Coordinate, level CoordinateLevel) (Aggregate, bool) {
+	i.locker.RLock()
+	defer i.locker.RUnlock()
+	ids, ok := i.prefixes[level][c.Prefix(level)]
+	if !ok {
+		return Aggregate{}, false
+	}
+	aggregate := Aggregate{Coordinate: c.Prefix(level)}
+	i.aggregate(&aggregate, ids)
+	return aggregate, true
+}
+
+// Aggregates 统计坐标前缀匹配的防区温度,按 by 层级分组
+// 如前缀为组,by 为层时统计该组每一层的温度,分组的坐标只包含前缀和 by 层级的值
+func (i *Index) Aggregates(c Coordinate, level, by CoordinateLevel) []Aggregate {
+	i.locker.RLock()
+	defer i.locker.RUnlock()
+	prefix := c.Prefix(level)
+	groups := map[Coordinate]map[uint]struct{}{}
+	//仓库和组为上下级,分组的坐标保留所属的仓库和组
+	base := by
+	if base > CoordinateGroup {
+		base = CoordinateGroup
+	}
+	if level > base {
+		base = level
+	}
+	for id := range i.prefixes[level][prefix] {
+		key := i.zones[id].Coordinate.Prefix(base)
+		if by > base {
+			set(&key, *i.zones[id].Coordinate, by)
+		}
+		if groups[key] == nil {
+			groups[key] = map[uint]struct{}{}
+		}
+		groups[key][id] = struct{}{}
+	}
+	aggregates := make([]Aggregate, 0, len(groups))
+	for key, ids := range groups {
+		aggregate := Aggregate{Coordinate: key}
+		i.aggregate(&aggregate, ids)
+		aggregates = append(aggregates, aggregate)
+	}
+	sort.Slice(aggregates, func(x, y int) bool {
+		return lessCoordinate(aggregates[x].Coordinate, aggregates[y].Coordinate)
+	})
+	return aggregates
+}
+
+func (i *Index) aggregate(aggregate *Aggregate, ids map[uint]struct{}) {
+	var sum float64
+	aggregate.Zones = len(ids)
+	for id := range ids {
+		t := i.zones[id].Temperature
+		if t == nil {
+			continue
+		}
+		if aggregate.Count == 0 || t.Max > aggregate.Max {
+			aggregate.Max, aggregate.MaxZone = t.Max, id
+		}
+		if aggregate.Count == 0 || t.Min < aggregate.Min {
+			aggregate.Min = t.Min
+		}
+		sum += float64(t.Avg)
+		aggregate.Count++
+	}
+	if aggregate.Count > 0 {
+		aggregate.Avg = float32(sum / float64(aggregate.Count))
+	}
+}
+
+func (i *Index) find(c Coordinate, level CoordinateLevel) Zones {
+	ids := i.prefixes[level][c.Prefix(level)]
+	zones := make(Zones, 0, len(ids))
+	for id := range ids {
+		zones = append(zones, i.zones[id].Clone())
+	}
+	sortByCoordinate(zones)
+	return zones
+}
+
+func (i *Index) put(zone *Zone) {
+	if zone.Coordinate == nil {
+		return
+	}
+	zone = zone.Clone()
+	i.zones[zone.Id] = zone
+	for level := CoordinateAll; level <= CoordinateLayer; level++ {
+		key := zone.Coordinate.Prefix(level)
+		if i.prefixes[level][key] == nil {
+			i.prefixes[level][key] = map[uint]struct{}{}
+		}
+		i.prefixes[level][key][zone.Id] = struct{}{}
+	}
+}
+
+func (i *Index) remove(id uint) {
+	zone, ok := i.zones[id]
+	if !ok {
+		return
+	}
+	delete(i.zones, id)
+	for level := CoordinateAll; level <= CoordinateLayer; level++ {
+		key := zone.Coordinate.Prefix(level)
+		delete(i.prefixes[level][key], id)
+		if len(i.prefixes[level][key]) == 0 {
+			delete(i.prefixes[level], key)
+		}
+	}
+}
+
+// set 将坐标 c 在 level 层级的值设置到 key
+func set(key *Coordinate, c Coordinate, level CoordinateLevel) {
+	switch level {
+	case CoordinateWarehouse:
+		key.Warehouse = c.Warehouse
+	case CoordinateGroup:
+		key.Group = c.Group
+	case CoordinateRow:
+		key.Row = c.Row
+	case CoordinateColumn:
+		key.Column = c.Column
+	case CoordinateLayer:
+		key.Layer = c.Layer
+	}
+}
+
+func lessCoordinate(a, b Coordinate) bool {
+	switch {
+	case a.Warehouse != b.Warehouse:
+		return a.Warehouse < b.Warehouse
+	case a.Group != b.Group:
+		return a.Group < b.Group
+	case a.Row != b.Row:
+		return a.Row < b.Row
+	case a.Column != b.Column:
+		return a.Column < b.Column
+	default:
+		return a.Layer < b.Layer
+	}
+}
+
+func sortByCoordinate(zones Zones) {
+	sort.SliceStable(zones, func(x, y int) bool {
+		if *zones[x].Coordinate == *zones[y].Coordinate {
+			return zones[x].Id < zones[y].Id
+		}
+		return lessCoordinate(*zones[x].Coordinate, *zones[y].Coordinate)
+	})
+}
@@ -0,0 +1,62 @@
+package dts
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/zing-dev/atian-tools/source/device"
+	"time"
+)
+
+const (
+	// BackoffMin 自动模式初始化失败后的最小重试间隔
+	BackoffMin = time.Second
+	// BackoffMax 自动模式初始化失败后的最大重试间隔
+	BackoffMax = time.Second * 30
+)
+
+// WithAuto 自动模式,每次连接成功后自动同步防区并注册 CallTypes 中的回调,使用者只需读取数据通道
+func WithAuto() Option {
+	return func(a *App) {
+		a.auto = true
+	}
+}
+
+// lifecycle 等待连接成功的信号,每次(重新)连接后进行初始化
+func (a *App) lifecycle() {
+	for {
+		select {
+		case <-a.Context.Done():
+			return
+		case <-a.connected:
+			a.setup()
+		}
+	}
+}
+
+// setup 同步防区并注册回调,失败后按指数退避重试,直到成功,断开连接或关闭
+func (a *App) setup() {
+	backoff := BackoffMin
+	for i := 1; ; i++ {
+		err := a.SyncZones()
+		if err == nil {
+			err = a.Register()
+		}
+		if err == nil {
+			a.setMessage(fmt.Sprintf("主机为 %s 的 dts 同步防区并注册回调成功", a.DTS.Host), logrus.InfoLevel)
+			return
+		}
+		a.setMessage(fmt.Sprintf("主机为 %s 的 dts 第 %d 次初始化失败: %s, %s 后重试", a.DTS.Host, i, err, backoff), logrus.ErrorLevel)
+		select {
+		case <-a.Context.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if a.Status() != device.Connected {
+			a.setMessage(fmt.Sprintf("主机为 %s 的 dts 已断开,等待重新连接后初始化", a.DTS.Host), logrus.WarnLevel)
+			return
+		}
+		if backoff *= 2; backoff > BackoffMax {
+			backoff = BackoffMax
+		}
+	}
+}
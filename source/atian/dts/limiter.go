@@ -0,0 +1,99 @@
+package dts
+
+import (
+	"github.com/Atian-OE/DTSSDK_Golang/dtssdk/model"
+	"sync"
+	"time"
+)
+
+const (
+	LevelNormal AlarmLevel = iota //正常
+	LevelWarn                     //预警
+	LevelAlarm                    //报警
+)
+
+type (
+	// AlarmLevel 报警等级,用于比较防区报警状态的严重程度
+	AlarmLevel byte
+
+	// AlarmLimiter 防区报警限流器,按防区和报警状态限流,报警升级时立即放行
+	AlarmLimiter struct {
+		locker     sync.Mutex
+		interval   func() time.Duration
+		states     map[uint]map[model.DefenceAreaState]time.Time //防区每个报警状态最近一次放行的时间
+		last       map[uint]model.DefenceAreaState               //防区最近一次放行的报警状态
+		suppressed map[uint]uint64                               //防区被抑制的次数
+		total      uint64
+	}
+)
+
+// GetAlarmLevel 获取报警状态的等级
+func GetAlarmLevel(state model.DefenceAreaState) AlarmLevel {
+	switch state {
+	case model.DefenceAreaState_Normal:
+		return LevelNormal
+	case model.DefenceAreaState_WarnDiffer, model.DefenceAreaState_WarnUp,
+		model.DefenceAreaState_WarnTemp, model.DefenceAreaState_WarnLowTemp:
+		return LevelWarn
+	default:
+		return LevelAlarm
+	}
+}
+
+// NewAlarmLimiter 实例化报警限流器,interval 返回同一防区同一报警状态的最小间隔
+func NewAlarmLimiter(interval func() time.Duration) *AlarmLimiter {
+	return &AlarmLimiter{
+		interval:   interval,
+		states:     map[uint]map[model.DefenceAreaState]time.Time{},
+		last:       map[uint]model.DefenceAreaState{},
+		suppressed: map[uint]uint64{},
+	}
+}
+
+// Allow 判断防区的当前报警是否放行
+// 报警等级高于上一次放行的等级时立即放行,否则同一报警状态在间隔内只放行一次
+func (l *AlarmLimiter) Allow(id uint, state model.DefenceAreaState, at time.Time) bool {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	last, ok := l.last[id]
+	escalate := !ok || GetAlarmLevel(state) > GetAlarmLevel(last)
+	if !escalate {
+		if t, ok := l.states[id][state]; ok && at.Sub(t) < l.interval() {
+			l.suppressed[id]++
+			l.total++
+			return false
+		}
+	}
+	if l.states[id] == nil {
+		l.states[id] = map[model.DefenceAreaState]time.Time{}
+	}
+	l.states[id][state] = at
+	l.last[id] = state
+	return true
+}
+
+// Reset 清除防区的限流记录,防区恢复正常后调用
+func (l *AlarmLimiter) Reset(id uint) {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	delete(l.states, id)
+	delete(l.last, id)
+}
+
+// Suppressed 获取被抑制的报警总数
+func (l *AlarmLimiter) Suppressed() uint64 {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	return l.total
+}
+
+// SuppressedZones 获取每个防区被抑制的报警数
+func (l *AlarmLimiter) SuppressedZones() map[uint]uint64 {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	zones := make(map[uint]uint64, len(l.suppressed))
+	for id, n := range l.suppressed {
+		zones[id] = n
+	}
+	return zones
+}
@@ -0,0 +1,472 @@
+package linkage
+
+import (
+	"context"
+	"fmt"
+	"github.com/Atian-OE/DTSSDK_Golang/dtssdk/model"
+	"github.com/zing-dev/atian-tools/log"
+	"github.com/zing-dev/atian-tools/source/atian/dts"
+	"github.com/zing-dev/atian-tools/source/device"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	_           Action = iota
+	ActionFire         //打开继电器路数
+	ActionReset        //复位继电器路数
+)
+
+const (
+	// DefaultLogSize 默认保留的联动记录数
+	DefaultLogSize = 256
+	// MaxBranch 继电器的最大路数
+	MaxBranch = 32
+)
+
+type (
+	// Action 联动动作
+	Action byte
+
+	// Relay 联动的继电器,device.Relay 和 device.ModbusRelay 实现该接口
+	Relay interface {
+		Alarms(branch string) error //打开多路,以逗号分隔
+		Reset(branch string) error  //复位一路
+	}
+
+	// Resolver 根据继电器标签获取继电器,未找到时返回 nil
+	Resolver func(tag string) Relay
+
+	// Source 报警事件来源,*dts.App,*dts.Fleet 和 *dts.ManagerSource 均实现该接口
+	// 设备管理器中的 App 可能被重启策略重建,应使用 dts.NewManagerSource,App 关闭时其订阅随之关闭
+	Source interface {
+		Subscribe(t dts.CallType, size int, policy dts.DropPolicy) *dts.Subscription
+	}
+
+	// Config 联动配置
+	Config struct {
+		MinLevel  dts.AlarmLevel //触发联动的最低报警等级,为 LevelNormal 时按 LevelWarn 处理
+		ResetTime uint32         //打开后自动复位的时间 秒,为 0 时只在报警恢复时复位
+		LogSize   int            //保留的联动记录数,为 0 时为 DefaultLogSize
+	}
+
+	// Output 继电器的一路输出
+	Output struct {
+		Tag    string `json:"tag"`    //继电器标签,即防区继电器标签中的字母
+		Branch int    `json:"branch"` //路数
+	}
+
+	// Active 当前打开的输出
+	Active struct {
+		Output
+		Zones   []uint            `json:"zones"`    //使该路打开的防区
+		FiredAt *device.TimeLocal `json:"fired_at"` //打开时间
+	}
+
+	// Record 联动记录
+	Record struct {
+		Action   Action                 `json:"action"`
+		Tag      string                 `json:"tag"`
+		Branches string                 `json:"branches"` //路数,以逗号分隔
+		Zone     uint                   `json:"zone,omitempty"`
+		Name     string                 `json:"name,omitempty"`
+		Host     string                 `json:"host,omitempty"`
+		State    model.DefenceAreaState `json:"state"`
+		Reason   string                 `json:"reason"`
+		Error    string                 `json:"error,omitempty"`
+		At       *device.TimeLocal      `json:"at"`
+
+		fired map[Output]*output //打开时新增的输出,打开失败时撤销
+	}
+
+	output struct {
+		zones   map[uint]struct{}
+		firedAt time.Time
+	}
+
+	// Linkage 防区报警与继电器的联动
+	// 防区报警达到最低等级时打开防区继电器标签中的路数,所有相关防区恢复或超过复位时间后复位
+	Linkage struct {
+		locker   sync.Mutex
+		resolver Resolver
+		config   Config
+		outputs  map[Output]*output
+		zones    map[uint][]Output //防区打开的输出
+		records  []Record
+	}
+)
+
+var (
+	_ Relay = (*device.Relay)(nil)
+	_ Relay = (*device.ModbusRelay)(nil)
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionFire:
+		return "打开"
+	case ActionReset:
+		return "复位"
+	default:
+		return "未知动作"
+	}
+}
+
+// ParseBranches 解析防区继电器的路数,如 1,2,3,非法和超出范围的路数忽略
+func ParseBranches(branch string) []int {
+	var branches []int
+	for _, b := range strings.Split(branch, ",") {
+		i, err := strconv.Atoi(strings.TrimSpace(b))
+		if err != nil || i <= 0 || i > MaxBranch {
+			continue
+		}
+		branches = append(branches, i)
+	}
+	return branches
+}
+
+// ManagerResolver 从设备管理器中按标签查找继电器
+func ManagerResolver(m *device.Manger) Resolver {
+	return func(tag string) Relay {
+		var relay Relay
+		m.Range(func(_ string, d device.Device) {
+			if r, ok := d.(interface {
+				Relay
+				GetTag() string
+			}); ok && d.GetType() == device.TypeRelay && r.GetTag() == tag {
+				relay = r
+			}
+		})
+		return relay
+	}
+}
+
+// New 实例化联动
+func New(resolver Resolver, config Config) *Linkage {
+	return &Linkage{
+		resolver: resolver,
+		config:   config,
+		outputs:  map[Output]*output{},
+		zones:    map[uint][]Output{},
+	}
+}
+
+func (l *Linkage) GetConfig() Config {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	return l.config
+}
+
+func (l *Linkage) SetConfig(config Config) {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	l.config = config
+}
+
+// Handle 处理报警事件,返回执行的联动记录
+func (l *Linkage) Handle(event dts.AlarmEvent) []Record {
+	if event.Zone == nil {
+		return nil
+	}
+	at := time.Now()
+	if event.At != nil {
+		at = event.At.Time
+	}
+	l.locker.Lock()
+	level := l.config.MinLevel
+	if level == dts.LevelNormal {
+		level = dts.LevelWarn
+	}
+	var records []Record
+	if event.Type == dts.AlarmCleared || dts.GetAlarmLevel(event.State) < level {
+		records = l.release(event, at)
+	} else {
+		records = l.hold(event, at)
+	}
+	l.locker.Unlock()
+	return l.execute(records)
+}
+
+// Expire 复位打开时间超过复位时间的输出,返回执行的联动记录
+func (l *Linkage) Expire(now time.Time) []Record {
+	l.locker.Lock()
+	reset := time.Second * time.Duration(l.config.ResetTime)
+	if reset == 0 {
+		l.locker.Unlock()
+		return nil
+	}
+	var expired []Output
+	for o, out := range l.outputs {
+		if now.Sub(out.firedAt) >= reset {
+			expired = append(expired, o)
+		}
+	}
+	sortOutputs(expired)
+	var records []Record
+	for _, o := range expired {
+		for id := range l.outputs[o].zones {
+			l.zones[id] = removeOutput(l.zones[id], o)
+			if len(l.zones[id]) == 0 {
+				delete(l.zones, id)
+			}
+		}
+		delete(l.outputs, o)
+		//同一继电器的路数合并为一条记录
+		if n := len(records); n > 0 && records[n-1].Tag == o.Tag {
+			records[n-1].Branches += "," + strconv.Itoa(o.Branch)
+			continue
+		}
+		records = append(records, Record{
+			Action:   ActionReset,
+			Tag:      o.Tag,
+			Branches: strconv.Itoa(o.Branch),
+			Reason:   fmt.Sprintf("打开超过 %d 秒自动复位", l.config.ResetTime),
+			At:       &device.TimeLocal{Time: now},
+		})
+	}
+	l.locker.Unlock()
+	return l.execute(records)
+}
+
+// Actives 获取当前打开的输出,按标签和路数排序
+func (l *Linkage) Actives() []Active {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	actives := make([]Active, 0, len(l.outputs))
+	for o, out := range l.outputs {
+		active := Active{Output: o, FiredAt: &device.TimeLocal{Time: out.firedAt}}
+		for id := range out.zones {
+			active.Zones = append(active.Zones, id)
+		}
+		sort.Slice(active.Zones, func(i, j int) bool {
+			return active.Zones[i] < active.Zones[j]
+		})
+		actives = append(actives, active)
+	}
+	sort.Slice(actives, func(i, j int) bool {
+		return lessOutput(actives[i].Output, actives[j].Output)
+	})
+	return actives
+}
+
+// Records 获取联动记录,按时间顺序
+func (l *Linkage) Records() []Record {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	return append([]Record{}, l.records...)
+}
+
+// Run 订阅报警事件并联动,直到 ctx 结束或订阅关闭,handle 在每次联动后调用,可为空
+func (l *Linkage) Run(ctx context.Context, source Source, handle func(Record)) {
+	subscription := source.Subscribe(dts.CallAlarmEvent, 64, dts.Block)
+	defer subscription.Close()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		var records []Record
+		select {
+		case <-ctx.Done():
+			return
+		case value, ok := <-subscription.C:
+			if !ok {
+				return
+			}
+			records = l.Handle(value.(dts.AlarmEvent))
+		case now := <-ticker.C:
+			records = l.Expire(now)
+		}
+		if handle != nil {
+			for _, record := range records {
+				handle(record)
+			}
+		}
+	}
+}
+
+// hold 防区报警,打开防区继电器标签中尚未打开的路数
+func (l *Linkage) hold(event dts.AlarmEvent, at time.Time) []Record {
+	zone := event.Zone
+	held := map[Output]bool{}
+	for _, o := range l.zones[zone.Id] {
+		held[o] = true
+	}
+	tags := make([]int, 0, len(zone.Relay))
+	for tag := range zone.Relay {
+		tags = append(tags, int(tag))
+	}
+	sort.Ints(tags)
+	var records []Record
+	for _, t := range tags {
+		tag := string(rune(t))
+		var (
+			fire  []string
+			fired = map[Output]*output{}
+		)
+		for _, branch := range ParseBranches(zone.Relay[byte(t)]) {
+			o := Output{Tag: tag, Branch: branch}
+			if held[o] {
+				continue
+			}
+			held[o] = true
+			l.zones[zone.Id] = append(l.zones[zone.Id], o)
+			out, ok := l.outputs[o]
+			if !ok {
+				out = &output{zones: map[uint]struct{}{}, firedAt: time.Now()}
+				l.outputs[o] = out
+				fired[o] = out
+				fire = append(fire, strconv.Itoa(branch))
+			}
+			out.zones[zone.Id] = struct{}{}
+		}
+		if len(fire) > 0 {
+			record := l.record(ActionFire, tag, fire, event, at, fmt.Sprintf("防区%s", dts.GetAlarmTypeString(event.State)))
+			record.fired = fired
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// release 防区恢复或低于最低等级,复位不再被其他防区占用的路数
+func (l *Linkage) release(event dts.AlarmEvent, at time.Time) []Record {
+	zone := event.Zone
+	outputs, ok := l.zones[zone.Id]
+	if !ok {
+		return nil
+	}
+	delete(l.zones, zone.Id)
+	reset := map[string][]string{}
+	var tags []string
+	for _, o := range outputs {
+		out, ok := l.outputs[o]
+		if !ok {
+			continue
+		}
+		delete(out.zones, zone.Id)
+		if len(out.zones) > 0 {
+			continue
+		}
+		delete(l.outputs, o)
+		if _, ok := reset[o.Tag]; !ok {
+			tags = append(tags, o.Tag)
+		}
+		reset[o.Tag] = append(reset[o.Tag], strconv.Itoa(o.Branch))
+	}
+	sort.Strings(tags)
+	reason := "防区报警恢复"
+	if event.Type != dts.AlarmCleared {
+		reason = fmt.Sprintf("防区%s低于联动等级", dts.GetAlarmTypeString(event.State))
+	}
+	records := make([]Record, 0, len(tags))
+	for _, tag := range tags {
+		records = append(records, l.record(ActionReset, tag, reset[tag], event, at, reason))
+	}
+	return records
+}
+
+func (l *Linkage) record(action Action, tag string, branches []string, event dts.AlarmEvent, at time.Time, reason string) Record {
+	return Record{
+		Action:   action,
+		Tag:      tag,
+		Branches: strings.Join(branches, ","),
+		Zone:     event.Zone.Id,
+		Name:     event.Zone.Name,
+		Host:     event.DTS.Host,
+		State:    event.State,
+		Reason:   reason,
+		At:       &device.TimeLocal{Time: at},
+	}
+}
+
+// rollback 撤销打开失败的输出,使用该输出的防区下一次报警时重新打开
+// 输出已被复位或重新打开时不处理
+func (l *Linkage) rollback(record Record) {
+	for o, out := range record.fired {
+		if l.outputs[o] != out {
+			continue
+		}
+		for id := range out.zones {
+			l.zones[id] = removeOutput(l.zones[id], o)
+			if len(l.zones[id]) == 0 {
+				delete(l.zones, id)
+			}
+		}
+		delete(l.outputs, o)
+	}
+}
+
+// execute 在锁外调用继电器执行联动,并保存联动记录,打开失败的输出撤销后在下一次报警时重试
+func (l *Linkage) execute(records []Record) []Record {
+	for k := range records {
+		record := &records[k]
+		relay := l.resolver(record.Tag)
+		if relay == nil {
+			record.Error = fmt.Sprintf("未找到标签为 %s 的继电器", record.Tag)
+			log.L.Error(fmt.Sprintf("联动%s继电器 %s 路数 %s 失败: %s", record.Action, record.Tag, record.Branches, record.Error))
+			continue
+		}
+		var errs []string
+		switch record.Action {
+		case ActionFire:
+			if err := relay.Alarms(record.Branches); err != nil {
+				errs = append(errs, err.Error())
+			}
+		case ActionReset:
+			for _, branch := range strings.Split(record.Branches, ",") {
+				if err := relay.Reset(branch); err != nil {
+					errs = append(errs, err.Error())
+				}
+			}
+		}
+		if len(errs) > 0 {
+			record.Error = strings.Join(errs, "; ")
+			log.L.Error(fmt.Sprintf("联动%s继电器 %s 路数 %s 失败: %s", record.Action, record.Tag, record.Branches, record.Error))
+			continue
+		}
+		log.L.Info(fmt.Sprintf("联动%s继电器 %s 路数 %s: %s", record.Action, record.Tag, record.Branches, record.Reason))
+	}
+	if len(records) == 0 {
+		return records
+	}
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	for _, record := range records {
+		if record.Action == ActionFire && record.Error != "" {
+			l.rollback(record)
+		}
+	}
+	size := l.config.LogSize
+	if size <= 0 {
+		size = DefaultLogSize
+	}
+	l.records = append(l.records, records...)
+	if len(l.records) > size {
+		l.records = append([]Record{}, l.records[len(l.records)-size:]...)
+	}
+	return records
+}
+
+func removeOutput(outputs []Output, o Output) []Output {
+	for k := range outputs {
+		if outputs[k] == o {
+			return append(outputs[:k], outputs[k+1:]...)
+		}
+	}
+	return outputs
+}
+
+func lessOutput(a, b Output) bool {
+	if a.Tag == b.Tag {
+		return a.Branch < b.Branch
+	}
+	return a.Tag < b.Tag
+}
+
+func sortOutputs(outputs []Output) {
+	sort.Slice(outputs, func(i, j int) bool {
+		return lessOutput(outputs[i], outputs[j])
+	})
+}
@@ -0,0 +1,213 @@
+package linkage
+
+import (
+	"errors"
+	"github.com/Atian-OE/DTSSDK_Golang/dtssdk/model"
+	"github.com/zing-dev/atian-tools/source/atian/dts"
+	"github.com/zing-dev/atian-tools/source/device"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testRelay 记录调用的继电器,fail 时打开失败
+type testRelay struct {
+	locker sync.Mutex
+	fail   bool
+	calls  []string
+}
+
+func (r *testRelay) Alarms(branch string) error {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.calls = append(r.calls, "alarms "+branch)
+	if r.fail {
+		return errors.New("继电器连接失败")
+	}
+	return nil
+}
+
+func (r *testRelay) Reset(branch string) error {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.calls = append(r.calls, "reset "+branch)
+	return nil
+}
+
+// take 获取并清空调用记录
+func (r *testRelay) take() []string {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	calls := r.calls
+	r.calls = nil
+	return calls
+}
+
+func (r *testRelay) setFail(fail bool) {
+	r.locker.Lock()
+	r.fail = fail
+	r.locker.Unlock()
+}
+
+func newLinkage(config Config) (*Linkage, map[string]*testRelay) {
+	relays := map[string]*testRelay{"A": {}, "B": {}}
+	return New(func(tag string) Relay {
+		if relay, ok := relays[tag]; ok {
+			return relay
+		}
+		return nil
+	}, config), relays
+}
+
+func event(t dts.AlarmEventType, id uint, state model.DefenceAreaState, relay dts.Relay, at time.Time) dts.AlarmEvent {
+	return dts.AlarmEvent{
+		Type:  t,
+		DTS:   dts.DTS{Id: 1, Host: "test"},
+		Zone:  &dts.Zone{BaseZone: dts.BaseZone{Id: id, Name: "zone", Relay: relay}},
+		State: state,
+		At:    &device.TimeLocal{Time: at},
+	}
+}
+
+func outputs(actives []Active) map[Output][]uint {
+	result := map[Output][]uint{}
+	for _, active := range actives {
+		result[active.Output] = active.Zones
+	}
+	return result
+}
+
+func TestParseBranches(t *testing.T) {
+	if branches := ParseBranches(" 1,2, x,0,33,32"); !reflect.DeepEqual(branches, []int{1, 2, 32}) {
+		t.Fatalf("得到 %v", branches)
+	}
+}
+
+func TestLinkageFire(t *testing.T) {
+	l, relays := newLinkage(Config{})
+	now := time.Now()
+	records := l.Handle(event(dts.AlarmRaised, 1, model.DefenceAreaState_AlarmTemp, dts.Relay{'A': "1,2", 'B': "3"}, now))
+	if len(records) != 2 || records[0].Tag != "A" || records[0].Branches != "1,2" || records[1].Tag != "B" {
+		t.Fatalf("得到 %+v", records)
+	}
+	if calls := relays["A"].take(); !reflect.DeepEqual(calls, []string{"alarms 1,2"}) {
+		t.Fatalf("继电器 A 调用 %v", calls)
+	}
+	if calls := relays["B"].take(); !reflect.DeepEqual(calls, []string{"alarms 3"}) {
+		t.Fatalf("继电器 B 调用 %v", calls)
+	}
+	//同一防区再次报警不重复打开
+	if records := l.Handle(event(dts.AlarmUpdated, 1, model.DefenceAreaState_AlarmUp, dts.Relay{'A': "1,2", 'B': "3"}, now)); len(records) != 0 {
+		t.Fatalf("得到 %+v", records)
+	}
+	//低于联动等级的报警不打开
+	if records := l.Handle(event(dts.AlarmRaised, 2, model.DefenceAreaState_Normal, dts.Relay{'A': "4"}, now)); len(records) != 0 {
+		t.Fatalf("得到 %+v", records)
+	}
+	//报警恢复时复位
+	records = l.Handle(event(dts.AlarmCleared, 1, model.DefenceAreaState_Normal, dts.Relay{'A': "1,2", 'B': "3"}, now))
+	if len(records) != 2 || records[0].Action != ActionReset {
+		t.Fatalf("得到 %+v", records)
+	}
+	if calls := relays["A"].take(); !reflect.DeepEqual(calls, []string{"reset 1", "reset 2"}) {
+		t.Fatalf("继电器 A 调用 %v", calls)
+	}
+	if len(l.Actives()) != 0 {
+		t.Fatalf("复位后仍有打开的输出 %+v", l.Actives())
+	}
+	if len(l.Records()) != 4 {
+		t.Fatalf("得到 %d 条联动记录", len(l.Records()))
+	}
+}
+
+// TestLinkageShared 多个防区共用的路数在所有防区恢复后才复位
+func TestLinkageShared(t *testing.T) {
+	l, relays := newLinkage(Config{})
+	now := time.Now()
+	l.Handle(event(dts.AlarmRaised, 1, model.DefenceAreaState_AlarmTemp, dts.Relay{'A': "1,2"}, now))
+	records := l.Handle(event(dts.AlarmRaised, 2, model.DefenceAreaState_AlarmTemp, dts.Relay{'A': "2,3"}, now))
+	if len(records) != 1 || records[0].Branches != "3" {
+		t.Fatalf("已经打开的路数不应重复打开, 得到 %+v", records)
+	}
+	expect := map[Output][]uint{{"A", 1}: {1}, {"A", 2}: {1, 2}, {"A", 3}: {2}}
+	if actives := outputs(l.Actives()); !reflect.DeepEqual(actives, expect) {
+		t.Fatalf("得到 %v", actives)
+	}
+	relays["A"].take()
+
+	records = l.Handle(event(dts.AlarmCleared, 1, model.DefenceAreaState_Normal, dts.Relay{'A': "1,2"}, now))
+	if len(records) != 1 || records[0].Branches != "1" {
+		t.Fatalf("共用的路数不应复位, 得到 %+v", records)
+	}
+	records = l.Handle(event(dts.AlarmCleared, 2, model.DefenceAreaState_Normal, dts.Relay{'A': "2,3"}, now))
+	if len(records) != 1 || records[0].Branches != "2,3" {
+		t.Fatalf("得到 %+v", records)
+	}
+	if calls := relays["A"].take(); !reflect.DeepEqual(calls, []string{"reset 1", "reset 2", "reset 3"}) {
+		t.Fatalf("继电器 A 调用 %v", calls)
+	}
+}
+
+func TestLinkageExpire(t *testing.T) {
+	l, relays := newLinkage(Config{ResetTime: 10})
+	now := time.Now()
+	l.Handle(event(dts.AlarmRaised, 1, model.DefenceAreaState_AlarmTemp, dts.Relay{'A': "1,2", 'B': "1"}, now))
+	relays["A"].take()
+	if records := l.Expire(now.Add(5 * time.Second)); len(records) != 0 {
+		t.Fatalf("未到复位时间, 得到 %+v", records)
+	}
+	records := l.Expire(now.Add(11 * time.Second))
+	if len(records) != 2 || records[0].Tag != "A" || records[0].Branches != "1,2" || records[1].Tag != "B" {
+		t.Fatalf("得到 %+v", records)
+	}
+	if calls := relays["A"].take(); !reflect.DeepEqual(calls, []string{"reset 1", "reset 2"}) {
+		t.Fatalf("继电器 A 调用 %v", calls)
+	}
+	if len(l.Actives()) != 0 {
+		t.Fatalf("得到 %+v", l.Actives())
+	}
+	//复位后防区再次报警时重新打开
+	if records := l.Handle(event(dts.AlarmUpdated, 1, model.DefenceAreaState_AlarmUp, dts.Relay{'A': "1,2", 'B': "1"}, now)); len(records) != 2 {
+		t.Fatalf("得到 %+v", records)
+	}
+	//未设置复位时间时不自动复位
+	l.SetConfig(Config{})
+	if records := l.Expire(now.Add(time.Hour)); records != nil {
+		t.Fatalf("得到 %+v", records)
+	}
+}
+
+// TestLinkageFailure 打开失败的路数撤销,下一次报警时重试
+func TestLinkageFailure(t *testing.T) {
+	l, relays := newLinkage(Config{})
+	now := time.Now()
+	relays["A"].setFail(true)
+	records := l.Handle(event(dts.AlarmRaised, 1, model.DefenceAreaState_AlarmTemp, dts.Relay{'A': "1", 'B': "2"}, now))
+	if len(records) != 2 || records[0].Error == "" || records[1].Error != "" {
+		t.Fatalf("得到 %+v", records)
+	}
+	if actives := outputs(l.Actives()); !reflect.DeepEqual(actives, map[Output][]uint{{"B", 2}: {1}}) {
+		t.Fatalf("打开失败的路数应撤销, 得到 %v", actives)
+	}
+
+	relays["A"].setFail(false)
+	records = l.Handle(event(dts.AlarmUpdated, 1, model.DefenceAreaState_AlarmUp, dts.Relay{'A': "1", 'B': "2"}, now))
+	if len(records) != 1 || records[0].Tag != "A" || records[0].Error != "" {
+		t.Fatalf("应重试打开失败的路数, 得到 %+v", records)
+	}
+	if calls := relays["A"].take(); !reflect.DeepEqual(calls, []string{"alarms 1", "alarms 1"}) {
+		t.Fatalf("继电器 A 调用 %v", calls)
+	}
+
+	//未找到继电器时同样撤销
+	records = l.Handle(event(dts.AlarmRaised, 2, model.DefenceAreaState_AlarmTemp, dts.Relay{'C': "1"}, now))
+	if len(records) != 1 || records[0].Error == "" {
+		t.Fatalf("得到 %+v", records)
+	}
+	for _, active := range l.Actives() {
+		if active.Tag == "C" {
+			t.Fatalf("未找到继电器的路数应撤销, 得到 %+v", active)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package dts
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+)
+
+// SignalScale 通道信号相邻两点的间隔 米,信号均匀分布在 0 到 RealLength 之间
+func SignalScale(signal ChannelSignal) (float32, error) {
+	if len(signal.Signal) < 2 || signal.RealLength <= 0 {
+		return 0, errors.New(fmt.Sprintf("通道 %d 信号长度 %d 或光纤长度 %.3f 无效", signal.ChannelId, len(signal.Signal), signal.RealLength))
+	}
+	return signal.RealLength / float32(len(signal.Signal)-1), nil
+}
+
+// ZoneProfile 根据通道信号计算防区的最大,最小,平均温度及最大温度所在位置
+func ZoneProfile(signal ChannelSignal, zone *Zone) (*Temperature, error) {
+	scale, err := SignalScale(signal)
+	if err != nil {
+		return nil, err
+	}
+	s, e, err := ZoneSignRange(len(signal.Signal), zone.Start, zone.Finish, scale)
+	if err != nil {
+		return nil, err
+	}
+	if s >= e {
+		return nil, errors.New(fmt.Sprintf("防区 %s 范围 %.3f ~ %.3f 内没有信号点", zone.Name, zone.Start, zone.Finish))
+	}
+	temperature := &Temperature{
+		Max: signal.Signal[s],
+		Min: signal.Signal[s],
+		At:  signal.CreatedAt,
+	}
+	var (
+		sum  float64
+		peak = s
+	)
+	for i := s; i < e; i++ {
+		v := signal.Signal[i]
+		sum += float64(v)
+		if v > temperature.Max {
+			temperature.Max, peak = v, i
+		}
+		if v < temperature.Min {
+			temperature.Min = v
+		}
+	}
+	temperature.Avg = float32(sum / float64(e-s))
+	temperature.MaxLocation = float32(peak) * scale
+	return temperature, nil
+}
+
+// ZonesProfile 根据通道信号计算该通道所有防区的温度,返回的防区与 ZonesTemp 中的防区一致
+func ZonesProfile(signal ChannelSignal, zones Zones) (Zones, []error) {
+	var (
+		list Zones
+		errs []error
+	)
+	for _, zone := range zones {
+		if int32(zone.ChannelId) != signal.ChannelId {
+			continue
+		}
+		temperature, err := ZoneProfile(signal, zone)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		list = append(list, &Zone{
+			BaseZone:    BaseZone{Id: zone.Id, ChannelId: zone.ChannelId, Name: zone.Name},
+			Temperature: temperature,
+		})
+	}
+	return list, errs
+}
+
+// channelZones 获取通道的所有防区
+func (a *App) channelZones(channelId int32) Zones {
+	zones := make(Zones, 0)
+	for _, zone := range a.GetZones() {
+		if int32(zone.ChannelId) == channelId {
+			zones = append(zones, zone)
+		}
+	}
+	return zones
+}
+
+// profile 根据通道信号计算防区温度,以 ZonesTemp 的形式发布到 CallProfile
+func (a *App) profile(signal ChannelSignal) {
+	channel := a.channelZones(signal.ChannelId)
+	if len(channel) == 0 {
+		return
+	}
+	zones, errs := ZonesProfile(signal, channel)
+	for _, err := range errs {
+		a.setMessage(fmt.Sprintf("主机为 %s 通道 %d 计算防区温度失败: %s", a.DTS.Host, signal.ChannelId, err), logrus.WarnLevel)
+	}
+	if len(zones) == 0 {
+		return
+	}
+	OrderedBy(func(p1, p2 *Zone) bool {
+		return p1.Id < p2.Id
+	}).Sort(SortZones(zones))
+	a.bus.Publish(CallProfile, ZonesTemp{
+		DTS:       a.DTS,
+		DeviceId:  signal.DeviceId,
+		Host:      a.DTS.Host,
+		CreatedAt: signal.CreatedAt,
+		Zones:     zones,
+	})
+}
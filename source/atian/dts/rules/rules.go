@@ -0,0 +1,471 @@
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Atian-OE/DTSSDK_Golang/dtssdk/model"
+	"github.com/zing-dev/atian-tools/log"
+	"github.com/zing-dev/atian-tools/source/atian/dts"
+	"github.com/zing-dev/atian-tools/source/device"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	_             Kind = iota
+	KindThreshold      //定温,最高温度达到阈值
+	KindRise           //温升,最高温度在时间窗口内的升高达到阈值,温度更新按 Config.ZonesTempSec 间隔,窗口不应小于该间隔
+	KindDiffer         //温差,最高温度与所在通道平均温度的差达到阈值
+	KindLowTemp        //低温,最低温度低于阈值
+)
+
+type (
+	// Kind 规则类型
+	Kind byte
+
+	// Source 防区温度来源,*dts.App,*dts.Fleet 和 *dts.ManagerSource 均实现该接口
+	Source interface {
+		Subscribe(t dts.CallType, size int, policy dts.DropPolicy) *dts.Subscription
+	}
+
+	// Selector 规则适用的防区,均为空时适用所有防区
+	// 同一类型的规则按 指定防区 > 组 > 仓库 > 所有防区 的优先级选择最具体的一条
+	Selector struct {
+		Zones     []uint `json:"zones,omitempty"`     //指定防区 Id
+		Warehouse string `json:"warehouse,omitempty"` //防区坐标的仓库
+		Group     string `json:"group,omitempty"`     //防区坐标的组
+	}
+
+	// Rule 报警规则,Warn 和 Alarm 至少设置一个
+	Rule struct {
+		Name       string   `json:"name"`
+		Kind       Kind     `json:"kind"`
+		Selector   Selector `json:"selector"`
+		Warn       *float32 `json:"warn,omitempty"`       //预警阈值
+		Alarm      *float32 `json:"alarm,omitempty"`      //报警阈值
+		Hysteresis float32  `json:"hysteresis,omitempty"` //回差,温度回落超过阈值该值后才恢复
+		Duration   int      `json:"duration,omitempty"`   //持续时间 秒,条件持续满足该时间后才报警
+		Window     int      `json:"window,omitempty"`     //温升的时间窗口 秒,仅温升规则有效,最小为 Config.ZonesTempSec
+	}
+
+	// Resolver 根据防区 Id 获取完整的防区信息,温度更新中的防区不包含坐标
+	Resolver func(id uint) *dts.Zone
+
+	// state 防区在某条规则上的状态
+	state struct {
+		level   dts.AlarmLevel //当前生效的报警等级
+		pending dts.AlarmLevel //等待持续时间满足的报警等级
+		since   time.Time      //等待开始的时间
+	}
+
+	sample struct {
+		at  time.Time
+		max float32
+	}
+
+	// Engine 规则引擎,根据防区温度更新计算防区的报警状态
+	Engine struct {
+		locker   sync.Mutex
+		resolver Resolver
+		rules    []Rule
+		states   map[uint]map[int]*state         //防区在每条规则上的状态,键为规则索引
+		samples  map[uint][]sample               //温升规则使用的温度历史
+		current  map[uint]model.DefenceAreaState //防区当前的报警状态
+	}
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindThreshold:
+		return "定温"
+	case KindRise:
+		return "温升"
+	case KindDiffer:
+		return "温差"
+	case KindLowTemp:
+		return "低温"
+	default:
+		return "未知规则"
+	}
+}
+
+// State 获取规则类型在报警等级下对应的防区状态
+func (k Kind) State(level dts.AlarmLevel) model.DefenceAreaState {
+	if level == dts.LevelNormal {
+		return model.DefenceAreaState_Normal
+	}
+	warn := level == dts.LevelWarn
+	switch k {
+	case KindRise:
+		if warn {
+			return model.DefenceAreaState_WarnUp
+		}
+		return model.DefenceAreaState_AlarmUp
+	case KindDiffer:
+		if warn {
+			return model.DefenceAreaState_WarnDiffer
+		}
+		return model.DefenceAreaState_AlarmDiffer
+	case KindLowTemp:
+		if warn {
+			return model.DefenceAreaState_WarnLowTemp
+		}
+		return model.DefenceAreaState_AlarmLowTemp
+	default:
+		if warn {
+			return model.DefenceAreaState_WarnTemp
+		}
+		return model.DefenceAreaState_AlarmTemp
+	}
+}
+
+// Validate 校验规则
+func (r *Rule) Validate() error {
+	if r.Kind < KindThreshold || r.Kind > KindLowTemp {
+		return errors.New(fmt.Sprintf("规则 %s 的类型 %d 非法", r.Name, r.Kind))
+	}
+	if r.Warn == nil && r.Alarm == nil {
+		return errors.New(fmt.Sprintf("规则 %s 未设置预警或报警阈值", r.Name))
+	}
+	if r.Warn != nil && r.Alarm != nil && !r.exceed(*r.Alarm, *r.Warn) {
+		return errors.New(fmt.Sprintf("规则 %s 的报警阈值 %.2f 应比预警阈值 %.2f 更严格", r.Name, *r.Alarm, *r.Warn))
+	}
+	if r.Hysteresis < 0 || r.Duration < 0 {
+		return errors.New(fmt.Sprintf("规则 %s 的回差或持续时间不能为负数", r.Name))
+	}
+	if r.Kind == KindRise && r.Window <= 0 {
+		return errors.New(fmt.Sprintf("温升规则 %s 未设置时间窗口", r.Name))
+	}
+	return nil
+}
+
+// Match 判断规则是否适用于防区,zone 需包含坐标
+func (s *Selector) Match(zone *dts.Zone) bool {
+	if len(s.Zones) > 0 {
+		for _, id := range s.Zones {
+			if id == zone.Id {
+				return true
+			}
+		}
+		return false
+	}
+	if s.Warehouse == "" && s.Group == "" {
+		return true
+	}
+	if zone.Coordinate == nil {
+		return false
+	}
+	if s.Warehouse != "" && s.Warehouse != zone.Coordinate.Warehouse {
+		return false
+	}
+	return s.Group == "" || s.Group == zone.Coordinate.Group
+}
+
+// specificity 选择器的优先级,越大越具体
+func (s *Selector) specificity() int {
+	switch {
+	case len(s.Zones) > 0:
+		return 3
+	case s.Group != "":
+		return 2
+	case s.Warehouse != "":
+		return 1
+	default:
+		return 0
+	}
+}
+
+// exceed 判断数值是否达到阈值,低温规则为不高于阈值
+func (r *Rule) exceed(value, threshold float32) bool {
+	if r.Kind == KindLowTemp {
+		return value <= threshold
+	}
+	return value >= threshold
+}
+
+// hold 判断已生效的报警在回差范围内是否继续保持
+func (r *Rule) hold(value, threshold float32) bool {
+	if r.Kind == KindLowTemp {
+		return value <= threshold+r.Hysteresis
+	}
+	return value >= threshold-r.Hysteresis
+}
+
+func (r *Rule) threshold(level dts.AlarmLevel) *float32 {
+	if level == dts.LevelAlarm {
+		return r.Alarm
+	}
+	return r.Warn
+}
+
+// level 根据数值和当前生效的等级计算目标等级
+func (r *Rule) level(value float32, current dts.AlarmLevel) dts.AlarmLevel {
+	target := dts.LevelNormal
+	if r.Alarm != nil && r.exceed(value, *r.Alarm) {
+		target = dts.LevelAlarm
+	} else if r.Warn != nil && r.exceed(value, *r.Warn) {
+		target = dts.LevelWarn
+	}
+	for level := current; level > target; level-- {
+		if threshold := r.threshold(level); threshold != nil && r.hold(value, *threshold) {
+			return level
+		}
+	}
+	return target
+}
+
+// Load 从 json 文件加载规则
+func Load(filename string) ([]Rule, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	err = json.Unmarshal(data, &rules)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("解析规则文件 %s 失败: %s", filename, err))
+	}
+	return rules, nil
+}
+
+// AppResolver 使用 dts.App 的防区缓存获取防区信息
+func AppResolver(app *dts.App) Resolver {
+	return app.GetZone
+}
+
+// NewEngine 实例化规则引擎
+func NewEngine(resolver Resolver, rules ...Rule) (*Engine, error) {
+	e := &Engine{
+		resolver: resolver,
+		states:   map[uint]map[int]*state{},
+		samples:  map[uint][]sample{},
+		current:  map[uint]model.DefenceAreaState{},
+	}
+	if err := e.SetRules(rules); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// SetRules 替换所有规则,清空已生效的报警状态,下一次温度更新时按新规则重新计算并通知
+func (e *Engine) SetRules(rules []Rule) error {
+	for i := range rules {
+		if err := rules[i].Validate(); err != nil {
+			return err
+		}
+	}
+	e.locker.Lock()
+	defer e.locker.Unlock()
+	e.rules = append([]Rule(nil), rules...)
+	e.states = map[uint]map[int]*state{}
+	e.current = map[uint]model.DefenceAreaState{}
+	return nil
+}
+
+// Rules 获取所有规则
+func (e *Engine) Rules() []Rule {
+	e.locker.Lock()
+	defer e.locker.Unlock()
+	return append([]Rule(nil), e.rules...)
+}
+
+// States 获取所有报警中的防区状态
+func (e *Engine) States() map[uint]model.DefenceAreaState {
+	e.locker.Lock()
+	defer e.locker.Unlock()
+	states := make(map[uint]model.DefenceAreaState, len(e.current))
+	for id, s := range e.current {
+		states[id] = s
+	}
+	return states
+}
+
+// Evaluate 根据温度更新计算防区报警状态,返回状态发生变化的防区,恢复的防区状态为正常,没有变化时返回 nil
+func (e *Engine) Evaluate(temp dts.ZonesTemp) *dts.ZonesAlarm {
+	at := time.Now()
+	if temp.CreatedAt != nil {
+		at = temp.CreatedAt.Time
+	}
+	e.locker.Lock()
+	defer e.locker.Unlock()
+
+	averages := map[byte]float32{}
+	counts := map[byte]int{}
+	for _, zone := range temp.Zones {
+		if zone.Temperature != nil {
+			averages[zone.ChannelId] += zone.Temperature.Avg
+			counts[zone.ChannelId]++
+		}
+	}
+	for channel, count := range counts {
+		averages[channel] /= float32(count)
+	}
+
+	var zones dts.Zones
+	for _, zone := range temp.Zones {
+		if zone.Temperature == nil {
+			continue
+		}
+		full := zone
+		if e.resolver != nil {
+			if z := e.resolver(zone.Id); z != nil {
+				full = z
+			}
+		}
+		rise := e.rise(zone.Id, zone.Temperature.Max, at)
+		next := model.DefenceAreaState_Normal
+		highest := dts.LevelNormal
+		for _, index := range e.match(full) {
+			rule := &e.rules[index]
+			kind := rule.Kind
+			var value float32
+			switch kind {
+			case KindThreshold:
+				value = zone.Temperature.Max
+			case KindRise:
+				value = rise(rule.Window)
+			case KindDiffer:
+				value = zone.Temperature.Max - averages[zone.ChannelId]
+			case KindLowTemp:
+				value = zone.Temperature.Min
+			}
+			level := e.update(zone.Id, index, rule, value, at)
+			//等级相同时使用顺序靠前的规则
+			if level > highest {
+				highest, next = level, kind.State(level)
+			}
+		}
+
+		previous, ok := e.current[zone.Id]
+		if !ok {
+			previous = model.DefenceAreaState_Normal
+		}
+		if previous == next {
+			continue
+		}
+		if next == model.DefenceAreaState_Normal {
+			delete(e.current, zone.Id)
+		} else {
+			e.current[zone.Id] = next
+		}
+		alarm := zone.Clone()
+		alarm.Alarm = &dts.Alarm{Location: zone.Temperature.MaxLocation, At: &device.TimeLocal{Time: at}, State: next}
+		zones = append(zones, alarm)
+	}
+	if len(zones) == 0 {
+		return nil
+	}
+	return &dts.ZonesAlarm{
+		DTS:       temp.DTS,
+		DeviceId:  temp.DeviceId,
+		Host:      temp.Host,
+		CreatedAt: &device.TimeLocal{Time: at},
+		Zones:     zones,
+	}
+}
+
+// Run 订阅温度更新并计算报警,直到 ctx 结束或订阅关闭,handle 在状态变化时调用
+// 温度更新按 Config.ZonesTempSec 间隔,温升规则的窗口小于该间隔时无法计算温升
+// 设备管理器中的 App 可能被重启策略重建,应使用 dts.NewManagerSource 作为来源,App 关闭时其订阅随之关闭
+func (e *Engine) Run(ctx context.Context, source Source, handle func(alarm dts.ZonesAlarm)) {
+	if app, ok := source.(*dts.App); ok {
+		if sec := int(app.GetConfig().ZonesTempSec); sec > 0 {
+			for _, rule := range e.Rules() {
+				if rule.Kind == KindRise && rule.Window < sec {
+					log.L.Warn(fmt.Sprintf("温升规则 %s 的窗口 %d 秒小于温度更新间隔 %d 秒", rule.Name, rule.Window, sec))
+				}
+			}
+		}
+	}
+	subscription := source.Subscribe(dts.CallTemp, 30, dts.DropOldest)
+	defer subscription.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case value, ok := <-subscription.C:
+			if !ok {
+				return
+			}
+			if alarm := e.Evaluate(value.(dts.ZonesTemp)); alarm != nil {
+				handle(*alarm)
+			}
+		}
+	}
+}
+
+// match 获取适用于防区的规则,每种类型只保留最具体的一条,返回按顺序排列的规则索引
+func (e *Engine) match(zone *dts.Zone) []int {
+	best := map[Kind]int{}
+	for index := range e.rules {
+		rule := &e.rules[index]
+		if !rule.Selector.Match(zone) {
+			continue
+		}
+		if i, ok := best[rule.Kind]; ok && e.rules[i].Selector.specificity() >= rule.Selector.specificity() {
+			continue
+		}
+		best[rule.Kind] = index
+	}
+	matched := make([]int, 0, len(best))
+	for _, index := range best {
+		matched = append(matched, index)
+	}
+	sort.Ints(matched)
+	return matched
+}
+
+// update 更新防区在规则上的状态,返回当前生效的报警等级
+func (e *Engine) update(id uint, index int, rule *Rule, value float32, at time.Time) dts.AlarmLevel {
+	if e.states[id] == nil {
+		e.states[id] = map[int]*state{}
+	}
+	s, ok := e.states[id][index]
+	if !ok {
+		s = &state{}
+		e.states[id][index] = s
+	}
+	target := rule.level(value, s.level)
+	if target <= s.level {
+		s.level, s.pending = target, dts.LevelNormal
+		return s.level
+	}
+	if s.pending != target {
+		s.pending, s.since = target, at
+	}
+	if at.Sub(s.since) >= time.Second*time.Duration(rule.Duration) {
+		s.level, s.pending = target, dts.LevelNormal
+	}
+	return s.level
+}
+
+// rise 记录防区的最高温度,返回计算指定窗口内温升的函数
+func (e *Engine) rise(id uint, max float32, at time.Time) func(window int) float32 {
+	var longest int
+	for i := range e.rules {
+		if e.rules[i].Kind == KindRise && e.rules[i].Window > longest {
+			longest = e.rules[i].Window
+		}
+	}
+	samples := e.samples[id]
+	for len(samples) > 0 && at.Sub(samples[0].at) > time.Second*time.Duration(longest) {
+		samples = samples[1:]
+	}
+	samples = append(samples, sample{at: at, max: max})
+	if longest == 0 {
+		samples = nil
+	}
+	e.samples[id] = samples
+	return func(window int) float32 {
+		for _, s := range samples {
+			if at.Sub(s.at) <= time.Second*time.Duration(window) {
+				return max - s.max
+			}
+		}
+		return 0
+	}
+}
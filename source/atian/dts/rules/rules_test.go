@@ -0,0 +1,228 @@
+package rules
+
+import (
+	"github.com/Atian-OE/DTSSDK_Golang/dtssdk/model"
+	"github.com/zing-dev/atian-tools/source/atian/dts"
+	"github.com/zing-dev/atian-tools/source/device"
+	"testing"
+	"time"
+)
+
+// reading 某一时刻防区的温度
+type reading struct {
+	sec           int
+	max, avg, min float32
+	state         model.DefenceAreaState //期望的防区状态
+	changed       bool                   //期望状态发生变化
+}
+
+func value(v float32) *float32 {
+	return &v
+}
+
+func temp(at time.Time, zones ...*dts.Zone) dts.ZonesTemp {
+	return dts.ZonesTemp{CreatedAt: &device.TimeLocal{Time: at}, Zones: zones}
+}
+
+func zone(id uint, channel byte, max, avg, min float32) *dts.Zone {
+	return &dts.Zone{
+		BaseZone:    dts.BaseZone{Id: id, ChannelId: channel},
+		Temperature: &dts.Temperature{Max: max, Avg: avg, Min: min},
+	}
+}
+
+func TestEngineEvaluate(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     Rule
+		readings []reading
+	}{
+		{
+			name: "定温",
+			rule: Rule{Name: "定温", Kind: KindThreshold, Warn: value(50), Alarm: value(70)},
+			readings: []reading{
+				{sec: 0, max: 40, state: model.DefenceAreaState_Normal},
+				{sec: 1, max: 55, state: model.DefenceAreaState_WarnTemp, changed: true},
+				{sec: 2, max: 75, state: model.DefenceAreaState_AlarmTemp, changed: true},
+				{sec: 3, max: 60, state: model.DefenceAreaState_WarnTemp, changed: true},
+				{sec: 4, max: 30, state: model.DefenceAreaState_Normal, changed: true},
+			},
+		},
+		{
+			name: "温升",
+			rule: Rule{Name: "温升", Kind: KindRise, Alarm: value(10), Window: 60},
+			readings: []reading{
+				{sec: 0, max: 20, state: model.DefenceAreaState_Normal},
+				{sec: 30, max: 25, state: model.DefenceAreaState_Normal},
+				{sec: 60, max: 31, state: model.DefenceAreaState_AlarmUp, changed: true},
+				//窗口内最早的温度为 25
+				{sec: 90, max: 33, state: model.DefenceAreaState_Normal, changed: true},
+			},
+		},
+		{
+			name: "温差",
+			rule: Rule{Name: "温差", Kind: KindDiffer, Warn: value(5)},
+			readings: []reading{
+				{sec: 0, max: 24, avg: 20, state: model.DefenceAreaState_Normal},
+				{sec: 1, max: 26, avg: 20, state: model.DefenceAreaState_WarnDiffer, changed: true},
+				{sec: 2, max: 30, avg: 28, state: model.DefenceAreaState_Normal, changed: true},
+			},
+		},
+		{
+			name: "低温",
+			rule: Rule{Name: "低温", Kind: KindLowTemp, Warn: value(5), Alarm: value(0)},
+			readings: []reading{
+				{sec: 0, min: 10, state: model.DefenceAreaState_Normal},
+				{sec: 1, min: 3, state: model.DefenceAreaState_WarnLowTemp, changed: true},
+				{sec: 2, min: -1, state: model.DefenceAreaState_AlarmLowTemp, changed: true},
+				{sec: 3, min: 8, state: model.DefenceAreaState_Normal, changed: true},
+			},
+		},
+		{
+			name: "回差",
+			rule: Rule{Name: "回差", Kind: KindThreshold, Alarm: value(70), Hysteresis: 5},
+			readings: []reading{
+				{sec: 0, max: 72, state: model.DefenceAreaState_AlarmTemp, changed: true},
+				{sec: 1, max: 68, state: model.DefenceAreaState_AlarmTemp},
+				{sec: 2, max: 65, state: model.DefenceAreaState_AlarmTemp},
+				{sec: 3, max: 64, state: model.DefenceAreaState_Normal, changed: true},
+				{sec: 4, max: 68, state: model.DefenceAreaState_Normal},
+			},
+		},
+		{
+			name: "低温回差",
+			rule: Rule{Name: "低温回差", Kind: KindLowTemp, Alarm: value(0), Hysteresis: 2},
+			readings: []reading{
+				{sec: 0, min: -1, state: model.DefenceAreaState_AlarmLowTemp, changed: true},
+				{sec: 1, min: 2, state: model.DefenceAreaState_AlarmLowTemp},
+				{sec: 2, min: 3, state: model.DefenceAreaState_Normal, changed: true},
+			},
+		},
+		{
+			name: "持续时间",
+			rule: Rule{Name: "持续时间", Kind: KindThreshold, Warn: value(50), Alarm: value(70), Duration: 10},
+			readings: []reading{
+				{sec: 0, max: 75, state: model.DefenceAreaState_Normal},
+				{sec: 5, max: 75, state: model.DefenceAreaState_Normal},
+				{sec: 10, max: 75, state: model.DefenceAreaState_AlarmTemp, changed: true},
+				//降级立即生效
+				{sec: 11, max: 55, state: model.DefenceAreaState_WarnTemp, changed: true},
+				//条件中断后重新计时
+				{sec: 12, max: 75, state: model.DefenceAreaState_WarnTemp},
+				{sec: 13, max: 55, state: model.DefenceAreaState_WarnTemp},
+				{sec: 14, max: 75, state: model.DefenceAreaState_WarnTemp},
+				{sec: 24, max: 75, state: model.DefenceAreaState_AlarmTemp, changed: true},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			engine, err := NewEngine(nil, test.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			begin := time.Now()
+			for _, r := range test.readings {
+				//通道只有一个防区,通道平均温度即为 avg
+				alarm := engine.Evaluate(temp(begin.Add(time.Duration(r.sec)*time.Second), zone(1, 1, r.max, r.avg, r.min)))
+				changed := false
+				if alarm != nil {
+					for _, z := range alarm.Zones {
+						if z.Id == 1 {
+							changed = true
+							if z.Alarm.State != r.state {
+								t.Fatalf("%d 秒: 通知状态 %s, 期望 %s", r.sec, z.Alarm.State, r.state)
+							}
+						}
+					}
+				}
+				if changed != r.changed {
+					t.Fatalf("%d 秒: 状态变化 %v, 期望 %v", r.sec, changed, r.changed)
+				}
+				state, ok := engine.States()[1]
+				if !ok {
+					state = model.DefenceAreaState_Normal
+				}
+				if state != r.state {
+					t.Fatalf("%d 秒: 状态 %s, 期望 %s", r.sec, state, r.state)
+				}
+			}
+		})
+	}
+}
+
+func TestEngineSelector(t *testing.T) {
+	resolver := func(id uint) *dts.Zone {
+		z := zone(id, 1, 0, 0, 0)
+		z.Coordinate = &dts.Coordinate{Warehouse: "A", Group: "1"}
+		if id == 2 {
+			z.Coordinate.Group = "2"
+		}
+		return z
+	}
+	engine, err := NewEngine(resolver,
+		Rule{Name: "所有", Kind: KindThreshold, Alarm: value(50)},
+		Rule{Name: "组", Kind: KindThreshold, Selector: Selector{Warehouse: "A", Group: "1"}, Alarm: value(80)},
+		Rule{Name: "防区", Kind: KindThreshold, Selector: Selector{Zones: []uint{3}}, Alarm: value(90)},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.Evaluate(temp(time.Now(), zone(1, 1, 60, 0, 0), zone(2, 1, 60, 0, 0), zone(3, 1, 85, 0, 0)))
+	states := engine.States()
+	if _, ok := states[1]; ok {
+		t.Fatal("防区 1 应使用组规则")
+	}
+	if states[2] != model.DefenceAreaState_AlarmTemp {
+		t.Fatal("防区 2 应使用所有防区的规则")
+	}
+	if _, ok := states[3]; ok {
+		t.Fatal("防区 3 应使用指定防区的规则")
+	}
+}
+
+func TestEngineSetRules(t *testing.T) {
+	engine, err := NewEngine(nil, Rule{Name: "定温", Kind: KindThreshold, Alarm: value(50)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	begin := time.Now()
+	if alarm := engine.Evaluate(temp(begin, zone(1, 1, 60, 0, 0))); alarm == nil {
+		t.Fatal("期望报警")
+	}
+	if err = engine.SetRules([]Rule{{Name: "定温", Kind: KindThreshold, Alarm: value(80)}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(engine.States()) != 0 {
+		t.Fatalf("替换规则后状态应清空, 得到 %v", engine.States())
+	}
+	if alarm := engine.Evaluate(temp(begin.Add(time.Second), zone(1, 1, 85, 0, 0))); alarm == nil {
+		t.Fatal("按新规则应重新通知报警")
+	}
+	if err = engine.SetRules([]Rule{{Name: "非法", Kind: KindThreshold}}); err == nil {
+		t.Fatal("期望校验错误")
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+		ok   bool
+	}{
+		{name: "定温", rule: Rule{Kind: KindThreshold, Warn: value(50), Alarm: value(70)}, ok: true},
+		{name: "类型非法", rule: Rule{Kind: 0, Alarm: value(70)}},
+		{name: "未设置阈值", rule: Rule{Kind: KindThreshold}},
+		{name: "报警阈值低于预警阈值", rule: Rule{Kind: KindThreshold, Warn: value(70), Alarm: value(50)}},
+		{name: "低温报警阈值高于预警阈值", rule: Rule{Kind: KindLowTemp, Warn: value(0), Alarm: value(5)}},
+		{name: "回差为负数", rule: Rule{Kind: KindThreshold, Alarm: value(70), Hysteresis: -1}},
+		{name: "温升未设置窗口", rule: Rule{Kind: KindRise, Alarm: value(10)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.rule.Validate(); (err == nil) != test.ok {
+				t.Fatalf("校验结果 %v, 期望通过 %v", err, test.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Atian-OE/DTSSDK_Golang/dtssdk/model"
+	"math/rand"
+	"os"
+	"time"
+)
+
+type (
+	// Step 脚本中的一步,延迟 Delay 毫秒后推送其中非空的通知
+	Step struct {
+		Delay  uint32                   `json:"delay"`            //距上一步的延迟 毫秒
+		Temp   *model.ZoneTempNotify    `json:"temp,omitempty"`   //防区温度
+		Alarm  *model.ZoneAlarmNotify   `json:"alarm,omitempty"`  //防区报警
+		Signal *model.TempSignalNotify  `json:"signal,omitempty"` //通道温度信号
+		Event  *model.DeviceEventNotify `json:"event,omitempty"`  //通道光纤事件
+	}
+
+	// Script 推送脚本
+	Script struct {
+		Loop  bool   `json:"loop"` //是否循环播放
+		Steps []Step `json:"steps"`
+	}
+)
+
+// LoadScript 从 json 文件加载脚本
+func LoadScript(filename string) (*Script, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	script := new(Script)
+	if err := json.Unmarshal(data, script); err != nil {
+		return nil, errors.New(fmt.Sprintf("解析脚本 %s 失败: %s", filename, err))
+	}
+	return script, nil
+}
+
+// Play 按顺序推送脚本,直到脚本结束或上下文取消
+func (s *Server) Play(ctx context.Context, script *Script) error {
+	for {
+		for _, step := range script.Steps {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-s.ctx.Done():
+				return s.ctx.Err()
+			case <-time.After(time.Duration(step.Delay) * time.Millisecond):
+			}
+			if err := s.Step(step); err != nil {
+				return err
+			}
+		}
+		if !script.Loop || len(script.Steps) == 0 {
+			return nil
+		}
+	}
+}
+
+// Step 立即推送一步中的所有通知
+func (s *Server) Step(step Step) (err error) {
+	if step.Temp != nil {
+		if _, err = s.NotifyZoneTemp(step.Temp); err != nil {
+			return
+		}
+	}
+	if step.Alarm != nil {
+		if _, err = s.NotifyZoneAlarm(step.Alarm); err != nil {
+			return
+		}
+	}
+	if step.Signal != nil {
+		if _, err = s.NotifyTempSignal(step.Signal); err != nil {
+			return
+		}
+	}
+	if step.Event != nil {
+		if _, err = s.NotifyDeviceEvent(step.Event); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// NewZones 生成 channels 个通道,每个通道 num 个长度为 length 米的防区,标签包含坐标和继电器
+func NewZones(channels, num int, length float32) []*model.DefenceZone {
+	zones := make([]*model.DefenceZone, 0, channels*num)
+	for c := 1; c <= channels; c++ {
+		for i := 0; i < num; i++ {
+			id := int32(len(zones) + 1)
+			zones = append(zones, &model.DefenceZone{
+				ID:        id,
+				ChannelID: int32(c),
+				ZoneName:  fmt.Sprintf("通道%d-防区%d", c, i+1),
+				Start:     float32(i) * length,
+				Finish:    float32(i+1)*length - 1,
+				Tag: fmt.Sprintf("warehouse=w%d;group=g%d;row=%d;column=%d;layer=%d;relay=A%d",
+					c, i/10+1, i/10+1, i%10+1, 1, i%32+1),
+			})
+		}
+	}
+	return zones
+}
+
+// ZoneTemps 根据防区生成基准温度 base 附近的防区温度
+func ZoneTemps(zones []*model.DefenceZone, base float32) []*model.DefenceZone {
+	temps := make([]*model.DefenceZone, len(zones))
+	for i, zone := range zones {
+		avg := base + rand.Float32()*2 - 1
+		temps[i] = &model.DefenceZone{
+			ID:                 zone.GetID(),
+			ChannelID:          zone.GetChannelID(),
+			ZoneName:           zone.GetZoneName(),
+			Start:              zone.GetStart(),
+			Finish:             zone.GetFinish(),
+			Tag:                zone.GetTag(),
+			MaxTemperature:     avg + rand.Float32(),
+			MinTemperature:     avg - rand.Float32(),
+			AverageTemperature: avg,
+		}
+	}
+	return temps
+}
+
+// Signal 生成长度为 length 米,间隔为 scale 米,基准温度 base 附近的通道温度信号
+func Signal(length, scale, base float32) []float32 {
+	if scale <= 0 {
+		scale = 1
+	}
+	signal := make([]float32, int(length/scale)+1)
+	for i := range signal {
+		signal[i] = base + rand.Float32()*2 - 1
+	}
+	return signal
+}
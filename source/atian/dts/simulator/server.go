@@ -0,0 +1,339 @@
+package simulator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Atian-OE/DTSSDK_Golang/dtssdk/codec"
+	"github.com/Atian-OE/DTSSDK_Golang/dtssdk/model"
+	"github.com/golang/protobuf/proto"
+	"github.com/zing-dev/atian-tools/log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Port DTSSDK 客户端固定连接的端口
+	Port = 17083
+	// HeaderLength 包头长度 长度 4 字节 + 消息 Id 1 字节
+	HeaderLength = 5
+)
+
+// Config 模拟器配置
+type Config struct {
+	Addr     string               //监听地址,默认 :17083
+	DeviceId string               //模拟的设备编码
+	Zones    []*model.DefenceZone //模拟的防区
+}
+
+// Server 模拟 DTS 主机,使用 DTSSDK 的 TCP 协议与客户端通信
+type Server struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	config   Config
+	listener net.Listener
+	clients  map[*client]struct{}
+	locker   sync.Mutex
+	wg       sync.WaitGroup
+}
+
+// client 已连接的 DTSSDK 客户端
+type client struct {
+	conn   net.Conn
+	locker sync.Mutex
+	enable *model.SetDeviceRequest //客户端订阅的通知
+}
+
+func New(ctx context.Context, config Config) *Server {
+	ctx, cancel := context.WithCancel(ctx)
+	if config.Addr == "" {
+		config.Addr = fmt.Sprintf(":%d", Port)
+	}
+	if config.DeviceId == "" {
+		config.DeviceId = "DTS-SIMULATOR"
+	}
+	return &Server{
+		ctx:     ctx,
+		cancel:  cancel,
+		config:  config,
+		clients: map[*client]struct{}{},
+	}
+}
+
+// Encode 按 DTSSDK 协议编码消息 长度 4 字节 + 消息 Id 1 字节 + 包体
+// 复用 SDK 的 codec.Encode,其未处理 GetDeviceIDReply 等应答,消息 Id 以参数为准
+func Encode(id model.MsgID, message proto.Message) ([]byte, error) {
+	data, err := codec.Encode(message)
+	if err != nil {
+		return nil, err
+	}
+	data[HeaderLength-1] = byte(id)
+	return data, nil
+}
+
+// Listen 开始监听客户端连接
+func (s *Server) Listen() error {
+	listener, err := net.Listen("tcp", s.config.Addr)
+	if err != nil {
+		return err
+	}
+	s.locker.Lock()
+	s.listener = listener
+	s.locker.Unlock()
+	log.L.Info(fmt.Sprintf("DTS 模拟器 %s 开始监听 %s", s.config.DeviceId, listener.Addr()))
+	s.wg.Add(1)
+	go s.accept(listener)
+	return nil
+}
+
+// Addr 获取监听地址
+func (s *Server) Addr() net.Addr {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// DeviceId 获取模拟的设备编码
+func (s *Server) DeviceId() string {
+	return s.config.DeviceId
+}
+
+// Zones 获取模拟的防区
+func (s *Server) Zones() []*model.DefenceZone {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return s.config.Zones
+}
+
+// SetZones 替换模拟的防区,后续的防区查询将返回新的防区
+func (s *Server) SetZones(zones []*model.DefenceZone) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.config.Zones = zones
+}
+
+// Clients 获取当前连接的客户端数量
+func (s *Server) Clients() int {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return len(s.clients)
+}
+
+// Close 关闭模拟器,断开所有客户端
+func (s *Server) Close() error {
+	s.cancel()
+	s.locker.Lock()
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for c := range s.clients {
+		_ = c.conn.Close()
+	}
+	s.locker.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) accept(listener net.Listener) {
+	defer s.wg.Done()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-s.ctx.Done():
+			default:
+				log.L.Error(fmt.Sprintf("DTS 模拟器接收连接失败: %s", err))
+			}
+			return
+		}
+		c := &client{conn: conn, enable: &model.SetDeviceRequest{}}
+		s.locker.Lock()
+		s.clients[c] = struct{}{}
+		s.locker.Unlock()
+		log.L.Info(fmt.Sprintf("DTS 模拟器客户端 %s 已连接", conn.RemoteAddr()))
+		s.wg.Add(1)
+		go s.handle(c)
+	}
+}
+
+func (s *Server) handle(c *client) {
+	defer func() {
+		s.locker.Lock()
+		delete(s.clients, c)
+		s.locker.Unlock()
+		_ = c.conn.Close()
+		log.L.Info(fmt.Sprintf("DTS 模拟器客户端 %s 已断开", c.conn.RemoteAddr()))
+		s.wg.Done()
+	}()
+
+	buf := make([]byte, 1024)
+	var cache bytes.Buffer
+	for {
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			return
+		}
+		cache.Write(buf[:n])
+		for cache.Len() >= HeaderLength {
+			data := cache.Bytes()
+			length := int(data[0])<<24 | int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+			if len(data)-HeaderLength < length {
+				break
+			}
+			id, body := model.MsgID(data[4]), make([]byte, length)
+			copy(body, data[HeaderLength:HeaderLength+length])
+			cache.Next(HeaderLength + length)
+			if err := s.reply(c, id, body); err != nil {
+				log.L.Error(fmt.Sprintf("DTS 模拟器处理消息 %s 失败: %s", id, err))
+			}
+		}
+	}
+}
+
+// reply 响应客户端的请求
+func (s *Server) reply(c *client, id model.MsgID, body []byte) error {
+	switch id {
+	case model.MsgID_HeartBeatID:
+		return nil
+	case model.MsgID_SetDeviceRequestID:
+		request := new(model.SetDeviceRequest)
+		if err := proto.Unmarshal(body, request); err != nil {
+			return err
+		}
+		c.locker.Lock()
+		c.enable = request
+		c.locker.Unlock()
+		return c.write(model.MsgID_SetDeviceReplyID, &model.SetDeviceReply{Success: true})
+	case model.MsgID_GetDefenceZoneRequestID:
+		request := new(model.GetDefenceZoneRequest)
+		if err := proto.Unmarshal(body, request); err != nil {
+			return err
+		}
+		reply := &model.GetDefenceZoneReply{Success: true}
+		for _, zone := range s.Zones() {
+			if request.GetChannel() != 0 && zone.GetChannelID() != request.GetChannel() {
+				continue
+			}
+			if request.GetSearch() != "" && !strings.Contains(zone.GetZoneName(), request.GetSearch()) {
+				continue
+			}
+			reply.Rows = append(reply.Rows, zone)
+		}
+		return c.write(model.MsgID_GetDefenceZoneReplyID, reply)
+	case model.MsgID_GetDeviceIDRequestID:
+		return c.write(model.MsgID_GetDeviceIDReplyID, &model.GetDeviceIDReply{Success: true, DeviceID: s.config.DeviceId})
+	case model.MsgID_CancelSoundRequestID:
+		return c.write(model.MsgID_CancelSoundReplyID, &model.CancelSoundReply{Success: true})
+	case model.MsgID_ResetAlarmRequestID:
+		return c.write(model.MsgID_ResetAlarmReplyID, &model.ResetAlarmReply{Success: true})
+	default:
+		return errors.New(fmt.Sprintf("不支持的消息 Id %d", id))
+	}
+}
+
+func (c *client) write(id model.MsgID, message proto.Message) error {
+	data, err := Encode(id, message)
+	if err != nil {
+		return err
+	}
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(time.Second * 3))
+	_, err = c.conn.Write(data)
+	return err
+}
+
+// enabled 客户端是否订阅了当前通知
+func (c *client) enabled(id model.MsgID) bool {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	switch id {
+	case model.MsgID_ZoneTempNotifyID:
+		return c.enable.GetZoneTempNotifyEnable()
+	case model.MsgID_ZoneAlarmNotifyID:
+		return c.enable.GetZoneAlarmNotifyEnable()
+	case model.MsgID_DeviceEventNotifyID:
+		return c.enable.GetFiberStatusNotifyEnable()
+	case model.MsgID_TempSignalNotifyID:
+		return c.enable.GetTempSignalNotifyEnable()
+	default:
+		return true
+	}
+}
+
+// broadcast 向订阅了当前通知的客户端推送消息,返回推送成功的客户端数量
+func (s *Server) broadcast(id model.MsgID, message proto.Message) (n int, err error) {
+	s.locker.Lock()
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.locker.Unlock()
+	for _, c := range clients {
+		if !c.enabled(id) {
+			continue
+		}
+		if e := c.write(id, message); e != nil {
+			err = e
+			continue
+		}
+		n++
+	}
+	return
+}
+
+func (s *Server) timestamp() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// NotifyZoneTemp 推送防区温度
+func (s *Server) NotifyZoneTemp(notify *model.ZoneTempNotify) (int, error) {
+	if notify.DeviceID == "" {
+		notify.DeviceID = s.config.DeviceId
+	}
+	if notify.Timestamp == 0 {
+		notify.Timestamp = s.timestamp()
+	}
+	return s.broadcast(model.MsgID_ZoneTempNotifyID, notify)
+}
+
+// NotifyZoneAlarm 推送防区报警
+func (s *Server) NotifyZoneAlarm(notify *model.ZoneAlarmNotify) (int, error) {
+	if notify.DeviceID == "" {
+		notify.DeviceID = s.config.DeviceId
+	}
+	if notify.Timestamp == 0 {
+		notify.Timestamp = s.timestamp()
+	}
+	return s.broadcast(model.MsgID_ZoneAlarmNotifyID, notify)
+}
+
+// NotifyTempSignal 推送通道温度信号
+func (s *Server) NotifyTempSignal(notify *model.TempSignalNotify) (int, error) {
+	if notify.DeviceID == "" {
+		notify.DeviceID = s.config.DeviceId
+	}
+	if notify.Timestamp == 0 {
+		notify.Timestamp = s.timestamp()
+	}
+	return s.broadcast(model.MsgID_TempSignalNotifyID, notify)
+}
+
+// NotifyDeviceEvent 推送通道光纤事件
+func (s *Server) NotifyDeviceEvent(notify *model.DeviceEventNotify) (int, error) {
+	if notify.DeviceID == "" {
+		notify.DeviceID = s.config.DeviceId
+	}
+	if notify.Timestamp == 0 {
+		notify.Timestamp = s.timestamp()
+	}
+	return s.broadcast(model.MsgID_DeviceEventNotifyID, notify)
+}
@@ -0,0 +1,133 @@
+package dts
+
+import (
+	"github.com/zing-dev/atian-tools/source/device"
+	"sync"
+)
+
+type (
+	// ManagerSource 设备管理器中所有 App 的数据来源,将每个 App 的数据转发到同一总线
+	// 设备被添加或更新(如重启策略 Rebuild 重建 App 后通过 Update 替换)时转发新的 App,删除时停止转发
+	// 订阅 ManagerSource 不会因 App 的关闭或重建而结束,可用于 websocket.Hub.Feed,linkage.Run 和 rules.Run
+	ManagerSource struct {
+		manger     *device.Manger
+		bus        *Bus
+		hosts      map[string]*FleetHost
+		unregister []device.Unregister
+		closed     bool
+		locker     sync.Mutex
+	}
+)
+
+// NewManagerSource 实例化设备管理器的数据来源,立即转发设备管理器中已有的 App
+func NewManagerSource(manger *device.Manger) *ManagerSource {
+	s := &ManagerSource{
+		manger: manger,
+		bus:    NewBus(),
+		hosts:  map[string]*FleetHost{},
+	}
+	for _, t := range []device.EventType{device.EventAdd, device.EventUpdate} {
+		s.unregister = append(s.unregister, manger.Subscribe(t, func(event device.Event) {
+			if app, ok := event.Device.(*App); ok {
+				s.attach(app)
+			}
+		}))
+	}
+	s.unregister = append(s.unregister, manger.Subscribe(device.EventDelete, func(event device.Event) {
+		s.detach(event.Device.GetId(), event.Device)
+	}))
+	manger.Range(func(_ string, d device.Device) {
+		if app, ok := d.(*App); ok {
+			s.attach(app)
+		}
+	})
+	return s
+}
+
+// Subscribe 订阅所有 App 某一类型的数据
+func (s *ManagerSource) Subscribe(t CallType, size int, policy DropPolicy) *Subscription {
+	return s.bus.Subscribe(t, size, policy)
+}
+
+// Apps 获取正在转发的 App
+func (s *ManagerSource) Apps() []*App {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	apps := make([]*App, 0, len(s.hosts))
+	for _, h := range s.hosts {
+		apps = append(apps, h.App)
+	}
+	return apps
+}
+
+// Close 停止转发并关闭总线,不关闭 App
+func (s *ManagerSource) Close() {
+	s.locker.Lock()
+	if s.closed {
+		s.locker.Unlock()
+		return
+	}
+	s.closed = true
+	hosts := s.hosts
+	s.hosts = map[string]*FleetHost{}
+	s.locker.Unlock()
+	for _, unregister := range s.unregister {
+		unregister()
+	}
+	for _, h := range hosts {
+		s.stop(h)
+	}
+	s.bus.Close()
+}
+
+// attach 转发 App 的数据,替换相同主机之前的 App
+func (s *ManagerSource) attach(app *App) {
+	s.locker.Lock()
+	if s.closed {
+		s.locker.Unlock()
+		return
+	}
+	previous, ok := s.hosts[app.GetId()]
+	if ok && previous.App == app {
+		s.locker.Unlock()
+		return
+	}
+	host := &FleetHost{App: app}
+	for _, t := range FleetCallTypes {
+		subscription := app.Subscribe(t, 64, DropOldest)
+		host.subscriptions = append(host.subscriptions, subscription)
+		host.forwards.Add(1)
+		go func() {
+			defer host.forwards.Done()
+			for value := range subscription.C {
+				s.bus.Publish(subscription.Type, value)
+			}
+		}()
+	}
+	s.hosts[app.GetId()] = host
+	s.locker.Unlock()
+	if ok {
+		s.stop(previous)
+	}
+}
+
+// detach 停止转发被删除的设备
+func (s *ManagerSource) detach(id string, d device.Device) {
+	s.locker.Lock()
+	h, ok := s.hosts[id]
+	if !ok || h.App != d {
+		s.locker.Unlock()
+		return
+	}
+	delete(s.hosts, id)
+	s.locker.Unlock()
+	s.stop(h)
+}
+
+// stop 取消 App 的订阅并等待转发完成
+func (s *ManagerSource) stop(h *FleetHost) {
+	for _, subscription := range h.subscriptions {
+		subscription.Close()
+	}
+	h.forwards.Wait()
+}
@@ -0,0 +1,78 @@
+package dts
+
+import (
+	"context"
+	"github.com/zing-dev/atian-tools/source/device"
+	"testing"
+	"time"
+)
+
+// receive 等待订阅的主机状态,跳过其他状态
+func receive(t *testing.T, subscription *Subscription, status device.StatusType) {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case value, ok := <-subscription.C:
+			if !ok {
+				t.Fatal("订阅已关闭")
+			}
+			if value.(HostStatus).Status == status {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("未收到主机状态 %d", status)
+		}
+	}
+}
+
+func waitApps(t *testing.T, source *ManagerSource, condition func([]*App) bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition(source.Apps()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("得到 %v", source.Apps())
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+// TestManagerSourceRebuild App 被替换后订阅继续接收新 App 的数据
+func TestManagerSourceRebuild(t *testing.T) {
+	m := device.NewManger(context.Background())
+	defer m.Stop()
+	newApp := func() *App {
+		return New(context.Background(), DTS{Id: 1, Name: "test", Host: "test"}, &Config{}, WithClient(&testClient{}))
+	}
+	old := newApp()
+	m.Add(old)
+	source := NewManagerSource(m)
+	subscription := source.Subscribe(CallStatus, 16, DropOldest)
+	waitApps(t, source, func(apps []*App) bool {
+		return len(apps) == 1 && apps[0] == old
+	})
+	old.setStatus(device.Connecting)
+	receive(t, subscription, device.Connecting)
+
+	//与重启策略的 Rebuild 一致,关闭原 App 后通过 Update 替换
+	_ = old.Close()
+	app := newApp()
+	defer app.Close()
+	m.Update(app)
+	waitApps(t, source, func(apps []*App) bool {
+		return len(apps) == 1 && apps[0] == app
+	})
+	app.setStatus(device.Connected)
+	receive(t, subscription, device.Connected)
+
+	if err := m.Delete(app.GetId()); err != nil {
+		t.Fatal(err)
+	}
+	waitApps(t, source, func(apps []*App) bool {
+		return len(apps) == 0
+	})
+
+	source.Close()
+	for range subscription.C {
+	}
+}
@@ -0,0 +1,442 @@
+package dts
+
+import (
+	"fmt"
+	"github.com/zing-dev/atian-tools/log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	TagString    TagType = iota + 1 //字符串
+	TagUint                         //正整数
+	TagRelayList                    //继电器路数列表,如 A1,2,3|B4,5
+)
+
+// relayPattern 继电器路数列表,支持 1,2,3 和 1_2_3 两种形式
+var relayPattern = regexp.MustCompile("^[1-9][0-9]*([,_][1-9][0-9]*)*$")
+
+type (
+	// TagType 标签字段类型
+	TagType byte
+
+	// TagField 标签字段,Name 为编码时使用的键名,Aliases 为解析时可识别的其他键名
+	TagField struct {
+		Name    string   `json:"name"`
+		Label   string   `json:"label"`
+		Aliases []string `json:"aliases,omitempty"`
+		Type    TagType  `json:"type"`
+	}
+
+	// Schema 防区标签模式,定义坐标和继电器字段的键名,别名及类型
+	Schema struct {
+		Warehouse TagField `json:"warehouse"`
+		Group     TagField `json:"group"`
+		Row       TagField `json:"row"`
+		Column    TagField `json:"column"`
+		Layer     TagField `json:"layer"`
+		Relay     TagField `json:"relay"`
+	}
+
+	// FieldError 标签字段错误
+	FieldError struct {
+		Key     string `json:"key"`
+		Value   string `json:"value,omitempty"`
+		Message string `json:"message"`
+	}
+
+	// TagErrors 标签解析错误集合
+	TagErrors []*FieldError
+
+	// ZoneTagError 防区的标签错误
+	ZoneTagError struct {
+		Id        uint      `json:"id"`
+		Name      string    `json:"name"`
+		ChannelId byte      `json:"channel_id"`
+		Tag       string    `json:"tag"`
+		Errors    TagErrors `json:"errors"`
+	}
+)
+
+func (e *FieldError) Error() string {
+	if e.Value == "" {
+		return fmt.Sprintf("%s: %s", e.Key, e.Message)
+	}
+	return fmt.Sprintf("%s=%s: %s", e.Key, e.Value, e.Message)
+}
+
+func (e TagErrors) Error() string {
+	list := make([]string, len(e))
+	for i, err := range e {
+		list[i] = err.Error()
+	}
+	return strings.Join(list, "; ")
+}
+
+func (e *ZoneTagError) Error() string {
+	return fmt.Sprintf("通道 %d 防区 %s(%d) 标签 %q 错误: %s", e.ChannelId, e.Name, e.Id, e.Tag, e.Errors)
+}
+
+// NewTagField 根据别名列表创建字段,别名以 | 分隔,第一个为键名,空别名忽略
+func NewTagField(label, aliases string, t TagType) TagField {
+	field := TagField{Label: label, Type: t}
+	for _, alias := range strings.Split(aliases, "|") {
+		if alias == "" {
+			continue
+		}
+		if field.Name == "" {
+			field.Name = alias
+		} else {
+			field.Aliases = append(field.Aliases, alias)
+		}
+	}
+	return field
+}
+
+// DefaultSchema 默认的标签模式,与 TagWarehouse 等常量一致
+func DefaultSchema() *Schema {
+	return &Schema{
+		Warehouse: NewTagField("仓库", TagWarehouse, TagString),
+		Group:     NewTagField("组", TagGroup, TagString),
+		Row:       NewTagField("行", TagRow, TagUint),
+		Column:    NewTagField("列", TagColumn, TagUint),
+		Layer:     NewTagField("层", TagLayer, TagUint),
+		Relay:     NewTagField("继电器", TagRelay, TagRelayList),
+	}
+}
+
+// DecodeTags 解析标签,格式错误的部分记录日志后忽略
+//
+// Deprecated: 使用 ParseTags,可获取格式错误和重复的标签
+func DecodeTags(tag string) map[string]string {
+	res, err := ParseTags(tag)
+	if err != nil {
+		log.L.Error(fmt.Sprintf("解析标签 %s 失败: %s", tag, err))
+	}
+	return res
+}
+
+// ParseTags 解析标签 k1=v1;k2=v2,返回解析成功的键值,格式错误的部分以 TagErrors 返回
+func ParseTags(tag string) (Tag, error) {
+	var (
+		res  = Tag{}
+		errs TagErrors
+	)
+	for _, v := range strings.Split(tag, TagSeparator) {
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+		value := strings.SplitN(v, TagValueSeparator, 2)
+		if len(value) != 2 || value[0] == "" {
+			errs = append(errs, &FieldError{Key: v, Message: "模式不匹配 k=v"})
+			continue
+		}
+		if _, ok := res[value[0]]; ok {
+			errs = append(errs, &FieldError{Key: value[0], Value: value[1], Message: "重复的标签"})
+			continue
+		}
+		res[value[0]] = value[1]
+	}
+	if len(errs) > 0 {
+		return res, errs
+	}
+	return res, nil
+}
+
+// EncodeTags 按键名排序编码标签,结果可由 ParseTags 解析为相同的标签
+func EncodeTags(tag Tag) (string, error) {
+	keys := make([]string, 0, len(tag))
+	for k := range tag {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return encodeTags(tag, keys)
+}
+
+func encodeTags(tag Tag, keys []string) (string, error) {
+	var (
+		list = make([]string, 0, len(keys))
+		errs TagErrors
+	)
+	for _, k := range keys {
+		v := tag[k]
+		if k == "" || strings.Contains(k, TagSeparator) || strings.Contains(k, TagValueSeparator) {
+			errs = append(errs, &FieldError{Key: k, Value: v, Message: "键名不能为空或包含分隔符"})
+			continue
+		}
+		if strings.Contains(v, TagSeparator) {
+			errs = append(errs, &FieldError{Key: k, Value: v, Message: "值不能包含分隔符"})
+			continue
+		}
+		list = append(list, k+TagValueSeparator+v)
+	}
+	if len(errs) > 0 {
+		return "", errs
+	}
+	return strings.Join(list, TagSeparator), nil
+}
+
+// Fields 获取所有字段
+func (s *Schema) Fields() []*TagField {
+	return []*TagField{&s.Warehouse, &s.Group, &s.Row, &s.Column, &s.Layer, &s.Relay}
+}
+
+// Lookup 按键名和别名的顺序查找字段的值,返回匹配的键
+func (s *Schema) Lookup(tag Tag, field *TagField) (key, value string, ok bool) {
+	for _, k := range append([]string{field.Name}, field.Aliases...) {
+		if v, ok := tag[k]; ok {
+			return k, v, true
+		}
+	}
+	return "", "", false
+}
+
+// Normalize 将字段的别名替换为键名,其他标签保持不变
+func (s *Schema) Normalize(tag Tag) Tag {
+	res := Tag{}
+	for k, v := range tag {
+		res[k] = v
+	}
+	for _, field := range s.Fields() {
+		if k, v, ok := s.Lookup(tag, field); ok {
+			delete(res, k)
+			res[field.Name] = v
+		}
+	}
+	return res
+}
+
+// Validate 校验标签中字段的类型,required 为必须存在的字段
+func (s *Schema) Validate(tag Tag, required ...*TagField) TagErrors {
+	return s.validate(tag, s.Fields(), required)
+}
+
+// validate 校验标签中 fields 字段的类型
+func (s *Schema) validate(tag Tag, fields, required []*TagField) TagErrors {
+	var errs TagErrors
+	for _, field := range required {
+		if _, _, ok := s.Lookup(tag, field); !ok {
+			errs = append(errs, &FieldError{Key: field.Name, Message: fmt.Sprintf("缺少%s标签", field.Label)})
+		}
+	}
+	for _, field := range fields {
+		k, v, ok := s.Lookup(tag, field)
+		if !ok {
+			continue
+		}
+		if err := field.check(v); err != "" {
+			errs = append(errs, &FieldError{Key: k, Value: v, Message: err})
+		}
+	}
+	return errs
+}
+
+// ParseCoordinate 解析防区坐标,行,列,层为必须的正整数
+func (s *Schema) ParseCoordinate(tag Tag) (*Coordinate, error) {
+	fields := []*TagField{&s.Warehouse, &s.Group, &s.Row, &s.Column, &s.Layer}
+	if errs := s.validate(tag, fields, fields[2:]); len(errs) > 0 {
+		return nil, errs
+	}
+	c := &Coordinate{}
+	_, c.Warehouse, _ = s.Lookup(tag, &s.Warehouse)
+	_, c.Group, _ = s.Lookup(tag, &s.Group)
+	for _, v := range []struct {
+		field *TagField
+		value *uint16
+	}{{&s.Row, &c.Row}, {&s.Column, &c.Column}, {&s.Layer, &c.Layer}} {
+		_, value, _ := s.Lookup(tag, v.field)
+		n, _ := strconv.ParseUint(value, 10, 16)
+		*v.value = uint16(n)
+	}
+	return c, nil
+}
+
+// ParseRelay 解析防区继电器
+func (s *Schema) ParseRelay(tag Tag) (Relay, error) {
+	k, v, ok := s.Lookup(tag, &s.Relay)
+	if !ok {
+		return nil, TagErrors{{Key: s.Relay.Name, Message: "缺少继电器标签"}}
+	}
+	if err := s.Relay.check(v); err != "" {
+		return nil, TagErrors{{Key: k, Value: v, Message: err}}
+	}
+	relay := make(Relay)
+	for _, r := range strings.Split(v, "|") {
+		if r == "" {
+			continue
+		}
+		relay[r[0]] = strings.ReplaceAll(r[1:], "_", ",")
+	}
+	return relay, nil
+}
+
+// EncodeRelay 编码继电器,按继电器排序,如 A1,2,3|B4,5
+func EncodeRelay(relay Relay) string {
+	keys := make([]int, 0, len(relay))
+	for k := range relay {
+		keys = append(keys, int(k))
+	}
+	sort.Ints(keys)
+	list := make([]string, len(keys))
+	for i, k := range keys {
+		list[i] = string(rune(k)) + relay[uint8(k)]
+	}
+	return strings.Join(list, "|")
+}
+
+// Encode 编码防区标签,坐标和继电器使用字段的键名,防区的其他标签保留,按字段顺序排列在前
+func (s *Schema) Encode(zone *Zone) (string, error) {
+	tag := Tag{}
+	for k, v := range zone.Tag {
+		tag[k] = v
+	}
+	//删除字段的所有别名,由坐标和继电器重新生成
+	for _, field := range s.Fields() {
+		if (zone.Coordinate != nil && field != &s.Relay) || (zone.Relay != nil && field == &s.Relay) {
+			for _, k := range append([]string{field.Name}, field.Aliases...) {
+				delete(tag, k)
+			}
+		}
+	}
+	if c := zone.Coordinate; c != nil {
+		if c.Warehouse != "" {
+			tag[s.Warehouse.Name] = c.Warehouse
+		}
+		if c.Group != "" {
+			tag[s.Group.Name] = c.Group
+		}
+		tag[s.Row.Name] = strconv.Itoa(int(c.Row))
+		tag[s.Column.Name] = strconv.Itoa(int(c.Column))
+		tag[s.Layer.Name] = strconv.Itoa(int(c.Layer))
+	}
+	if zone.Relay != nil {
+		tag[s.Relay.Name] = EncodeRelay(zone.Relay)
+	}
+	var keys, rest []string
+	for _, field := range s.Fields() {
+		if _, ok := tag[field.Name]; ok {
+			keys = append(keys, field.Name)
+		}
+	}
+	for k := range tag {
+		if s.field(k) == nil {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	return encodeTags(tag, append(keys, rest...))
+}
+
+// Decode 解析防区标签,设置防区的 Tag,坐标和继电器,返回防区的所有标签错误
+func (s *Schema) Decode(zone *Zone, tag string, coordinate, relay bool) *ZoneTagError {
+	e := &ZoneTagError{Id: zone.Id, Name: zone.Name, ChannelId: zone.ChannelId, Tag: tag}
+	if tag == "" {
+		if coordinate || relay {
+			e.Errors = append(e.Errors, &FieldError{Key: "tag", Message: "标签为空"})
+			return e
+		}
+		return nil
+	}
+	t, err := ParseTags(tag)
+	if err != nil {
+		e.Errors = append(e.Errors, err.(TagErrors)...)
+	}
+	zone.Tag = t
+	if relay {
+		r, err := s.ParseRelay(t)
+		if err != nil {
+			e.Errors = append(e.Errors, err.(TagErrors)...)
+		} else {
+			zone.Relay = r
+		}
+	}
+	if coordinate {
+		c, err := s.ParseCoordinate(t)
+		if err != nil {
+			e.Errors = append(e.Errors, err.(TagErrors)...)
+		} else {
+			zone.Coordinate = c
+		}
+	}
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (s *Schema) field(key string) *TagField {
+	for _, field := range s.Fields() {
+		if field.Name == key {
+			return field
+		}
+		for _, alias := range field.Aliases {
+			if alias == key {
+				return field
+			}
+		}
+	}
+	return nil
+}
+
+// check 校验字段的值,返回错误信息
+func (f *TagField) check(value string) string {
+	switch f.Type {
+	case TagUint:
+		n, err := strconv.ParseUint(value, 10, 16)
+		if err != nil || n == 0 {
+			return fmt.Sprintf("%s必须为正整数", f.Label)
+		}
+	case TagRelayList:
+		count := 0
+		for _, r := range strings.Split(value, "|") {
+			if r == "" {
+				continue
+			}
+			if len(r) < 2 || !(r[0] >= 'A' && r[0] <= 'Z' || r[0] >= 'a' && r[0] <= 'z') || !relayPattern.MatchString(r[1:]) {
+				return fmt.Sprintf("%s %s 模式不匹配,必须如A1,2,3,4", f.Label, r)
+			}
+			count++
+		}
+		if count == 0 {
+			return fmt.Sprintf("%s不能为空", f.Label)
+		}
+	default:
+		if value == "" {
+			return fmt.Sprintf("%s不能为空", f.Label)
+		}
+	}
+	return ""
+}
+
+// NewRelay 使用默认标签模式解析继电器标签
+func NewRelay(tag map[string]string) (Relay, error) {
+	return DefaultSchema().ParseRelay(tag)
+}
+
+// NewCoordinate 使用默认标签模式解析防区空间坐标
+func NewCoordinate(tag map[string]string) (*Coordinate, error) {
+	return DefaultSchema().ParseCoordinate(tag)
+}
+
+// WithSchema 使用指定的标签模式解析防区标签
+func WithSchema(schema *Schema) Option {
+	return func(a *App) {
+		a.schema = schema
+	}
+}
+
+// TagErrors 获取最近一次同步防区时的标签错误,按防区 Id 排序
+func (a *App) TagErrors() []*ZoneTagError {
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	var list []*ZoneTagError
+	for _, errs := range a.tagErrors {
+		list = append(list, errs...)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Id < list[j].Id
+	})
+	return list
+}
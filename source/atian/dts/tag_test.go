@@ -0,0 +1,93 @@
+package dts
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEncodeTagsRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  Tag
+	}{
+		{name: "空", tag: Tag{}},
+		{name: "单个", tag: Tag{"row": "1"}},
+		{name: "多个", tag: Tag{"warehouse": "w01", "group": "g001", "row": "1", "column": "2", "layer": "3"}},
+		{name: "中文", tag: Tag{"库": "一号库", "继电器": "A1,2,3|B4"}},
+		{name: "空值", tag: Tag{"note": ""}},
+		{name: "值包含等号", tag: Tag{"expr": "a=b"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoded, err := EncodeTags(test.tag)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := ParseTags(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decoded, test.tag) {
+				t.Fatalf("%q 解析为 %v, 期望 %v", encoded, decoded, test.tag)
+			}
+			if deprecated := DecodeTags(encoded); !reflect.DeepEqual(Tag(deprecated), test.tag) {
+				t.Fatalf("DecodeTags %q 得到 %v, 期望 %v", encoded, deprecated, test.tag)
+			}
+		})
+	}
+}
+
+func TestEncodeTagsOrder(t *testing.T) {
+	encoded, err := EncodeTags(Tag{"row": "1", "column": "2", "group": "g"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoded != "column=2;group=g;row=1" {
+		t.Fatalf("得到 %q", encoded)
+	}
+}
+
+func TestEncodeTagsInvalid(t *testing.T) {
+	for _, tag := range []Tag{{"": "1"}, {"a;b": "1"}, {"a=b": "1"}, {"a": "1;2"}} {
+		if encoded, err := EncodeTags(tag); err == nil {
+			t.Fatalf("%v 应编码失败, 得到 %q", tag, encoded)
+		}
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tag, err := ParseTags("row=1;bad;row=2;;column=3;")
+	errs, ok := err.(TagErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("期望 2 个标签错误, 得到 %v", err)
+	}
+	if !reflect.DeepEqual(tag, Tag{"row": "1", "column": "3"}) {
+		t.Fatalf("得到 %v", tag)
+	}
+	//格式错误的部分忽略,正确的标签仍然返回
+	if decoded := DecodeTags("row=1;bad"); !reflect.DeepEqual(decoded, map[string]string{"row": "1"}) {
+		t.Fatalf("得到 %v", decoded)
+	}
+}
+
+func TestSchemaRoundTrip(t *testing.T) {
+	schema := DefaultSchema()
+	zone := &Zone{
+		BaseZone:   BaseZone{Tag: Tag{"note": "x"}, Relay: Relay{'A': "1,2,3"}},
+		Coordinate: &Coordinate{Warehouse: "w01", Group: "g001", Row: 1, Column: 2, Layer: 3},
+	}
+	encoded, err := schema.Encode(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &Zone{}
+	if e := schema.Decode(decoded, encoded, true, true); e != nil {
+		t.Fatal(e)
+	}
+	if !reflect.DeepEqual(decoded.Coordinate, zone.Coordinate) || !reflect.DeepEqual(decoded.Relay, zone.Relay) {
+		t.Fatalf("%q 解析为 %v %v", encoded, decoded.Coordinate, decoded.Relay)
+	}
+	if decoded.Tag["note"] != "x" {
+		t.Fatalf("%q 未保留其他标签", encoded)
+	}
+}
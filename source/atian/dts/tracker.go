@@ -0,0 +1,209 @@
+package dts
+
+import (
+	"encoding/json"
+	"github.com/Atian-OE/DTSSDK_Golang/dtssdk/model"
+	"github.com/zing-dev/atian-tools/source/device"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	_                AlarmEventType = iota
+	AlarmRaised                     //产生报警
+	AlarmEscalated                  //报警升级,如预警升级为报警
+	AlarmDeEscalated                //报警降级,如报警降级为预警
+	AlarmUpdated                    //同等级的报警类型变化,如定温预警变为温升预警
+	AlarmCleared                    //报警恢复正常
+)
+
+type (
+	// AlarmEventType 报警生命周期事件类型
+	AlarmEventType byte
+
+	// AlarmEvent 报警生命周期事件
+	AlarmEvent struct {
+		Type     AlarmEventType         `json:"type"`
+		DTS      DTS                    `json:"dts,omitempty"`
+		Zone     *Zone                  `json:"zone"`      //防区,包含当前的温度和报警信息
+		State    model.DefenceAreaState `json:"state"`     //当前报警状态
+		Previous model.DefenceAreaState `json:"previous"`  //之前的报警状态
+		RaisedAt *device.TimeLocal      `json:"raised_at"` //报警产生时间
+		At       *device.TimeLocal      `json:"at"`        //事件时间
+		Duration float64                `json:"duration"`  //报警已持续的时间 秒
+		Peak     float32                `json:"peak"`      //报警期间的最高温度
+	}
+
+	// Incident 防区当前的报警
+	Incident struct {
+		Zone      *Zone                  `json:"zone"`
+		State     model.DefenceAreaState `json:"state"`
+		RaisedAt  time.Time              `json:"raised_at"`
+		UpdatedAt time.Time              `json:"updated_at"` //最近一次报警通知的时间
+		Peak      float32                `json:"peak"`
+	}
+
+	// AlarmTracker 报警跟踪器,记录每个防区当前的报警状态,并产生报警的产生,升级,降级和恢复事件
+	AlarmTracker struct {
+		locker     sync.Mutex
+		dts        DTS
+		clearAfter func() time.Duration
+		incidents  map[uint]*Incident
+	}
+)
+
+func (t AlarmEventType) String() string {
+	switch t {
+	case AlarmRaised:
+		return "产生报警"
+	case AlarmEscalated:
+		return "报警升级"
+	case AlarmDeEscalated:
+		return "报警降级"
+	case AlarmUpdated:
+		return "报警更新"
+	case AlarmCleared:
+		return "报警恢复"
+	default:
+		return "未知事件"
+	}
+}
+
+func (e *AlarmEvent) JSON() string {
+	data, _ := json.Marshal(e)
+	return string(data)
+}
+
+// NewAlarmTracker 实例化报警跟踪器
+// clearAfter 返回报警恢复的最短时间,防区在最近一次报警通知后的该时间内温度更新为正常时才视为恢复
+func NewAlarmTracker(dts DTS, clearAfter func() time.Duration) *AlarmTracker {
+	return &AlarmTracker{
+		dts:        dts,
+		clearAfter: clearAfter,
+		incidents:  map[uint]*Incident{},
+	}
+}
+
+// Alarm 处理防区的报警通知,zone 必须包含报警信息
+func (t *AlarmTracker) Alarm(zone *Zone, at time.Time) *AlarmEvent {
+	if zone == nil || zone.Alarm == nil {
+		return nil
+	}
+	t.locker.Lock()
+	defer t.locker.Unlock()
+	incident, ok := t.incidents[zone.Id]
+	if zone.Alarm.State == model.DefenceAreaState_Normal {
+		if !ok {
+			return nil
+		}
+		return t.clear(incident, zone, at)
+	}
+	if !ok {
+		incident = &Incident{
+			Zone:      zone,
+			State:     zone.Alarm.State,
+			RaisedAt:  at,
+			UpdatedAt: at,
+		}
+		t.peak(incident, zone)
+		t.incidents[zone.Id] = incident
+		return t.event(AlarmRaised, incident, zone, model.DefenceAreaState_Normal, at)
+	}
+	incident.Zone = zone
+	incident.UpdatedAt = at
+	t.peak(incident, zone)
+	previous := incident.State
+	if previous == zone.Alarm.State {
+		return nil
+	}
+	incident.State = zone.Alarm.State
+	switch level, last := GetAlarmLevel(zone.Alarm.State), GetAlarmLevel(previous); {
+	case level > last:
+		return t.event(AlarmEscalated, incident, zone, previous, at)
+	case level < last:
+		return t.event(AlarmDeEscalated, incident, zone, previous, at)
+	default:
+		return t.event(AlarmUpdated, incident, zone, previous, at)
+	}
+}
+
+// Temp 处理防区的温度更新,更新报警期间的最高温度
+// 温度更新带有报警状态时按报警处理,否则在超过恢复时间后视为报警恢复
+func (t *AlarmTracker) Temp(zone *Zone, at time.Time) *AlarmEvent {
+	if zone == nil {
+		return nil
+	}
+	if zone.Alarm != nil && zone.Alarm.State != model.DefenceAreaState_Normal {
+		return t.Alarm(zone, at)
+	}
+	t.locker.Lock()
+	defer t.locker.Unlock()
+	incident, ok := t.incidents[zone.Id]
+	if !ok {
+		return nil
+	}
+	t.peak(incident, zone)
+	if at.Sub(incident.UpdatedAt) < t.clearAfter() {
+		return nil
+	}
+	return t.clear(incident, zone, at)
+}
+
+// Incidents 获取当前所有报警中的防区,按报警产生时间排序
+func (t *AlarmTracker) Incidents() []Incident {
+	t.locker.Lock()
+	defer t.locker.Unlock()
+	incidents := make([]Incident, 0, len(t.incidents))
+	for _, incident := range t.incidents {
+		i := *incident
+		i.Zone = incident.Zone.Clone()
+		incidents = append(incidents, i)
+	}
+	sort.Slice(incidents, func(i, j int) bool {
+		return incidents[i].RaisedAt.Before(incidents[j].RaisedAt)
+	})
+	return incidents
+}
+
+// Incident 获取防区当前的报警,未报警时返回 nil
+func (t *AlarmTracker) Incident(id uint) *Incident {
+	t.locker.Lock()
+	defer t.locker.Unlock()
+	incident, ok := t.incidents[id]
+	if !ok {
+		return nil
+	}
+	i := *incident
+	i.Zone = incident.Zone.Clone()
+	return &i
+}
+
+func (t *AlarmTracker) clear(incident *Incident, zone *Zone, at time.Time) *AlarmEvent {
+	delete(t.incidents, zone.Id)
+	previous := incident.State
+	incident.State = model.DefenceAreaState_Normal
+	zone = zone.Clone()
+	zone.Alarm = &Alarm{At: &device.TimeLocal{Time: at}, State: model.DefenceAreaState_Normal}
+	return t.event(AlarmCleared, incident, zone, previous, at)
+}
+
+func (t *AlarmTracker) peak(incident *Incident, zone *Zone) {
+	if zone.Temperature != nil && zone.Temperature.Max > incident.Peak {
+		incident.Peak = zone.Temperature.Max
+	}
+}
+
+func (t *AlarmTracker) event(eventType AlarmEventType, incident *Incident, zone *Zone, previous model.DefenceAreaState, at time.Time) *AlarmEvent {
+	return &AlarmEvent{
+		Type:     eventType,
+		DTS:      t.dts,
+		Zone:     zone.Clone(),
+		State:    incident.State,
+		Previous: previous,
+		RaisedAt: &device.TimeLocal{Time: incident.RaisedAt},
+		At:       &device.TimeLocal{Time: at},
+		Duration: at.Sub(incident.RaisedAt).Seconds(),
+		Peak:     incident.Peak,
+	}
+}
@@ -5,32 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"github.com/Atian-OE/DTSSDK_Golang/dtssdk/model"
-	"github.com/zing-dev/atian-tools/log"
 	"github.com/zing-dev/atian-tools/source/device"
 	"math"
-	"regexp"
 	"sort"
-	"strconv"
-	"strings"
 )
 
-// DecodeTags 解析标签
-func DecodeTags(tag string) (res map[string]string) {
-	res = map[string]string{}
-	if strings.HasSuffix(tag, TagSeparator) {
-		tag = tag[:len(tag)-1]
-	}
-	for _, v := range strings.Split(tag, TagSeparator) {
-		value := strings.Split(v, TagValueSeparator)
-		if len(value) != 2 {
-			log.L.Error(fmt.Sprintf("解析 %s 失败,模式不匹配 k=v", value))
-			continue
-		}
-		res[value[0]] = value[1]
-	}
-	return
-}
-
 func GetAlarmTypeMap() (m []device.Constant) {
 	for _, state := range []model.DefenceAreaState{
 		model.DefenceAreaState_Normal,
@@ -135,7 +114,7 @@ const (
 	// TagGroup 防区所属 组
 	TagGroup = "group|Group|w|W|组" //示例 group:g001
 	// TagRow 防区所属 行
-	TagRow = "row|Row|x|X|行|" //示例 row:1
+	TagRow = "row|Row|x|X|行" //示例 row:1
 	// TagColumn 防区所属 列
 	TagColumn = "column|Column|y|Y|列" //示例 column:1
 	// TagLayer 防区所属 层
@@ -154,10 +133,11 @@ type (
 
 	// Temperature 温度信息
 	Temperature struct {
-		Max float32           `json:"max"`          //最大温度
-		Avg float32           `json:"avg"`          //平均温度
-		Min float32           `json:"min"`          //最小温度
-		At  *device.TimeLocal `json:"at,omitempty"` //产生温度的时间
+		Max         float32           `json:"max"`                    //最大温度
+		Avg         float32           `json:"avg"`                    //平均温度
+		Min         float32           `json:"min"`                    //最小温度
+		MaxLocation float32           `json:"max_location,omitempty"` //最大温度所在位置 米,仅由通道信号计算时有效
+		At          *device.TimeLocal `json:"at,omitempty"`           //产生温度的时间
 	}
 
 	// Alarm 报警防区信息
@@ -346,6 +326,39 @@ func (z *Zone) String() (str string) {
 	return
 }
 
+// Clone 复制防区,温度和报警信息为独立副本
+func (z *Zone) Clone() *Zone {
+	if z == nil {
+		return nil
+	}
+	zone := *z
+	if z.Coordinate != nil {
+		coordinate := *z.Coordinate
+		zone.Coordinate = &coordinate
+	}
+	if z.Temperature != nil {
+		temperature := *z.Temperature
+		zone.Temperature = &temperature
+	}
+	if z.Alarm != nil {
+		alarm := *z.Alarm
+		zone.Alarm = &alarm
+	}
+	return &zone
+}
+
+// Clone 复制防区集合
+func (zones Zones) Clone() Zones {
+	if zones == nil {
+		return nil
+	}
+	list := make(Zones, len(zones))
+	for i, zone := range zones {
+		list[i] = zone.Clone()
+	}
+	return list
+}
+
 func (t *ZonesTemp) JSON() string {
 	data, _ := json.Marshal(t)
 	return string(data)
@@ -371,114 +384,45 @@ func (zones SortZones) ChannelZones() []SortZones {
 
 // ZoneMapSign 防区与信号的映射
 func ZoneMapSign(sign []float32, start, end, scale float32) ([]float32, error) {
-	if start > end {
-		return nil, errors.New(fmt.Sprintf("开始位置 %.3f 大于终点位置 %.3f", start, end))
-	}
-	var s, e = 0, 0
-	if math.Mod(float64(start), float64(scale)) == 0 {
-		s = int(start / scale)
-	} else {
-		s = int(start/scale) + 1
-	}
-	if math.Mod(float64(end), float64(scale)) == 0 {
-		e = int(end/scale) + 2
-	} else {
-		e = int(end/scale) + 1
-	}
-	if len(sign) < s || len(sign) < e {
-		return nil, errors.New(fmt.Sprintf("开始位置 %.3f 索引 %d 或终点位置 %.3f 索引 %d 与温度信号长度 %d 映射失败", start, s, end, e, len(sign)))
+	s, e, err := ZoneSignRange(len(sign), start, end, scale)
+	if err != nil {
+		return nil, err
 	}
 	return sign[s:e], nil
 }
 
-// Id 设备Id和防区Id绑定
-func Id(deviceId, zoneId uint) uint {
-	return deviceId*1e6 + zoneId
-}
-
-type D3 struct {
-	name  string
-	tag   string
-	value uint16
-}
-
-// NewRelay 解析继电器标签
-func NewRelay(tag map[string]string) (Relay, error) {
-	relay := make(Relay)
-	for _, t := range strings.Split(TagRelay, "|") {
-		if r, ok := tag[t]; !ok {
-			continue
-		} else if len(r) < 2 {
-			return nil, errors.New("继电器标签字符值至少两位,例如A1")
-		} else if strings.Contains(r, "|") { //支持多继电器多路数
-			for _, v := range strings.Split(r, "|") {
-				if len(v) < 2 {
-					continue
-				} else if ok, _ := regexp.MatchString("^([1-9]*[1-9][0-9]*_)+[1-9]*[1-9][0-9]*$", v[1:]); ok {
-					//兼容这种形式 A1_2_3_4
-					relay[v[0]] = strings.ReplaceAll(v[1:], "_", ",")
-				} else if ok, _ := regexp.MatchString("^([1-9]*[1-9][0-9]*,)+[1-9]*[1-9][0-9]*$", v[1:]); ok {
-					relay[v[0]] = v[1:]
-				}
-			}
-		} else if ok, _ := regexp.MatchString("^([1-9]*[1-9][0-9]*_)+[1-9]*[1-9][0-9]*$", r[1:]); ok {
-			//兼容这种形式 A1_2_3_4
-			relay[r[0]] = strings.ReplaceAll(r[1:], "_", ",")
-			break
-		} else if ok, _ := regexp.MatchString("^([1-9]*[1-9][0-9]*,)+[1-9]*[1-9][0-9]*$", r[1:]); ok {
-			relay[r[0]] = r[1:]
-			break
-		}
+// ZoneSignRange 防区在信号中的索引范围 [s, e),scale 为信号相邻两点的间隔 米
+// 包含位置在 [start, end] 内的所有点,end 恰为 scale 整数倍时包含终点处的点,不再多取下一个点
+func ZoneSignRange(length int, start, end, scale float32) (s, e int, err error) {
+	if start > end {
+		return 0, 0, errors.New(fmt.Sprintf("开始位置 %.3f 大于终点位置 %.3f", start, end))
 	}
-	if len(relay) == 0 {
-		return nil, errors.New("继电器标签模式不匹配,必须如A1,2,3,4")
+	if scale <= 0 {
+		return 0, 0, errors.New(fmt.Sprintf("非法的信号间隔 %.3f", scale))
 	}
-	return relay, nil
-}
-
-// NewCoordinate 解析防区空间坐标
-func NewCoordinate(tag map[string]string) (*Coordinate, error) {
-	var (
-		w, g = "", ""
-		attr = []*D3{
-			{name: "行", tag: TagRow},
-			{name: "列", tag: TagColumn},
-			{name: "层", tag: TagLayer},
-		}
-	)
-	for i, s := range attr {
-		for _, r := range strings.Split(s.tag, "|") {
-			if v, ok := tag[r]; ok {
-				value, err := strconv.Atoi(v)
-				if err != nil {
-					return nil, errors.New(fmt.Sprintf("解析 %s 错误: %s", s.name, err))
-				}
-				attr[i].value = uint16(value)
-				break
-			}
-		}
-		if attr[i].value == 0 {
-			return nil, errors.New(fmt.Sprintf("解析 %s 错误,无匹配的标签 %s", s.name, s.tag))
-		}
+	s = signIndex(start, scale, true)
+	e = signIndex(end, scale, false) + 1
+	if length < s || length < e {
+		return 0, 0, errors.New(fmt.Sprintf("开始位置 %.3f 索引 %d 或终点位置 %.3f 索引 %d 与温度信号长度 %d 映射失败", start, s, end, e, length))
 	}
+	return s, e, nil
+}
 
-	for _, r := range strings.Split(TagWarehouse, "|") {
-		if v, ok := tag[r]; ok {
-			w = v
-			break
-		}
+// signIndex 位置在信号中的索引,与整数倍相差在 signEpsilon 内时视为整数倍,避免 float32 的舍入误差
+// ceil 为 true 时向上取整,否则向下取整
+func signIndex(position, scale float32, ceil bool) int {
+	const signEpsilon = 1e-4
+	index := float64(position) / float64(scale)
+	if round := math.Round(index); math.Abs(index-round) < signEpsilon {
+		return int(round)
 	}
-	for _, r := range strings.Split(TagGroup, "|") {
-		if v, ok := tag[r]; ok {
-			g = v
-			break
-		}
+	if ceil {
+		return int(math.Ceil(index))
 	}
-	return &Coordinate{
-		Warehouse: w,
-		Group:     g,
-		Row:       attr[0].value,
-		Column:    attr[1].value,
-		Layer:     attr[2].value,
-	}, nil
+	return int(math.Floor(index))
+}
+
+// Id 设备Id和防区Id绑定
+func Id(deviceId, zoneId uint) uint {
+	return deviceId*1e6 + zoneId
 }
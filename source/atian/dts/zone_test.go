@@ -0,0 +1,59 @@
+package dts
+
+import (
+	"testing"
+)
+
+func TestZoneSignRange(t *testing.T) {
+	tests := []struct {
+		name              string
+		length            int
+		start, end, scale float32
+		s, e              int
+		err               bool
+	}{
+		{name: "整数倍", length: 100, start: 2, end: 10, scale: 1, s: 2, e: 11},
+		{name: "非整数倍", length: 100, start: 2.5, end: 10.5, scale: 1, s: 3, e: 11},
+		{name: "起点非整数倍终点整数倍", length: 100, start: 2.2, end: 10, scale: 0.5, s: 5, e: 21},
+		{name: "单点", length: 100, start: 3, end: 3, scale: 1, s: 3, e: 4},
+		{name: "float32 舍入 0.1", length: 200, start: 10, end: 10, scale: 0.1, s: 100, e: 101},
+		{name: "float32 舍入 0.1 范围", length: 200, start: 0.3, end: 10, scale: 0.1, s: 3, e: 101},
+		{name: "float32 舍入 0.25", length: 100, start: 0.75, end: 10, scale: 0.25, s: 3, e: 41},
+		{name: "float32 舍入 0.4", length: 100, start: 1.2, end: 2.8, scale: 0.4, s: 3, e: 8},
+		{name: "恰好到信号末尾", length: 11, start: 0, end: 10, scale: 1, s: 0, e: 11},
+		{name: "超出信号长度", length: 10, start: 0, end: 10, scale: 1, err: true},
+		{name: "起点大于终点", length: 100, start: 10, end: 2, scale: 1, err: true},
+		{name: "非法间隔", length: 100, start: 2, end: 10, scale: 0, err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, e, err := ZoneSignRange(test.length, test.start, test.end, test.scale)
+			if test.err {
+				if err == nil {
+					t.Fatalf("期望错误, 得到 [%d, %d)", s, e)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s != test.s || e != test.e {
+				t.Fatalf("得到 [%d, %d), 期望 [%d, %d)", s, e, test.s, test.e)
+			}
+		})
+	}
+}
+
+func TestZoneMapSign(t *testing.T) {
+	sign := make([]float32, 50)
+	for k := range sign {
+		sign[k] = float32(k)
+	}
+	values, err := ZoneMapSign(sign, 1, 2, 0.1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 11 || values[0] != 10 || values[10] != 20 {
+		t.Fatalf("得到 %v", values)
+	}
+}
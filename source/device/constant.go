@@ -37,6 +37,7 @@ const (
 	EventUpdate
 	EventClose
 	EventDelete
+	EventStatus //设备状态变化
 )
 const (
 	_          StatusType = iota
@@ -74,6 +75,27 @@ func (s *StatusType) String() string {
 	}
 }
 
+func (e *EventType) String() string {
+	switch *e {
+	case EventError:
+		return "错误"
+	case EventAdd:
+		return "添加"
+	case EventRun:
+		return "运行"
+	case EventUpdate:
+		return "更新"
+	case EventClose:
+		return "关闭"
+	case EventDelete:
+		return "删除"
+	case EventStatus:
+		return "状态变化"
+	default:
+		return "未知事件"
+	}
+}
+
 func GetConnectMap() []Constant {
 	a1, a2, a3, a4 := UnConnect, Connecting, Connected, Disconnect
 	return []Constant{
@@ -152,4 +174,5 @@ type (
 
 var (
 	NotFoundDeviceError = errors.New("未找到当前设备")
+	ExistsDeviceError   = errors.New("设备已经存在")
 )
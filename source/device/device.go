@@ -2,9 +2,12 @@ package device
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/robfig/cron/v3"
 	"github.com/zing-dev/atian-tools/log"
 	"sync"
+	"time"
 )
 
 type (
@@ -16,9 +19,33 @@ type (
 
 	Listener func(Device)
 
+	// Handler 事件处理函数,可获取完整的事件
+	Handler func(Event)
+
+	// Unregister 取消注册的事件
+	Unregister func()
+
+	// Rebuilder 根据设备重建新的设备,用于重启关闭后不能再次运行的设备
+	Rebuilder func(Device) (Device, error)
+
 	Event struct {
 		Device    Device
 		EventType EventType
+		Cause     EventType  //EventError 时出错的事件类型
+		Err       error      //EventError 时的错误
+		Status    StatusType //EventStatus 时的当前状态
+		Previous  StatusType //EventStatus 时之前的状态
+	}
+
+	subscriber struct {
+		id     uint64
+		handle Handler
+	}
+
+	// queue 单个设备待处理的事件,同一设备的事件按顺序处理
+	queue struct {
+		events  []Event
+		running bool
 	}
 
 	Device interface {
@@ -38,6 +65,9 @@ var (
 	manger *Manger
 )
 
+// DefaultQueueSize 单个设备默认的最大待处理事件数
+const DefaultQueueSize = 30
+
 type Manger struct {
 	Context context.Context
 	Cancel  context.CancelFunc
@@ -45,32 +75,122 @@ type Manger struct {
 	locker  sync.Mutex
 	Cron    *cron.Cron
 
-	listeners sync.Map
-	event     chan Event
+	QueueSize   int //单个设备的最大待处理事件数,超出时事件丢失并通知 EventError
+	listeners   map[EventType][]*subscriber
+	queues      map[string]*queue
+	sequence    uint64
+	eventLocker sync.Mutex
+	started     bool               //已经启动,处理事件和执行定时任务
+	warned      bool               //已经提示未启动时事件在队列中等待
+	stop        context.CancelFunc //停止设备状态轮询
+	workers     sync.WaitGroup     //后台协程,如设备状态轮询
+
+	statusInterval  time.Duration
+	policies        map[Type]RestartPolicy
+	rebuilder       Rebuilder
+	supervised      map[string]*supervised
+	superviseLocker sync.Mutex
 }
 
-// NewManger 实例化设备管理器
+// NewManger 实例化并启动设备管理器,每次调用返回新的实例
 func NewManger(ctx context.Context) *Manger {
+	m := New(ctx)
+	m.Start()
+	return m
+}
+
+// New 实例化设备管理器但不启动,可在 Start 之前注册监听,添加设备和设置重启策略
+func New(ctx context.Context) *Manger {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Manger{
+		Context:        ctx,
+		Cancel:         cancel,
+		devices:        sync.Map{},
+		locker:         sync.Mutex{},
+		Cron:           cron.New(cron.WithSeconds()),
+		QueueSize:      DefaultQueueSize,
+		listeners:      map[EventType][]*subscriber{},
+		queues:         map[string]*queue{},
+		policies:       map[Type]RestartPolicy{},
+		supervised:     map[string]*supervised{},
+		statusInterval: DefaultStatusInterval,
+	}
+}
+
+// Default 获取默认的设备管理器,首次调用时实例化并启动
+func Default() *Manger {
 	once.Do(func() {
-		ctx, cancel := context.WithCancel(ctx)
-		manger = &Manger{
-			Context:   ctx,
-			Cancel:    cancel,
-			devices:   sync.Map{},
-			locker:    sync.Mutex{},
-			Cron:      cron.New(cron.WithSeconds()),
-			listeners: sync.Map{},
-			event:     make(chan Event, 30),
-		}
+		manger = NewManger(context.Background())
 	})
-
-	go manger.run()
 	return manger
 }
 
-// Register 注册事件
-func (m *Manger) Register(eventType EventType, lister Listener) {
-	m.listeners.Store(eventType, lister)
+// Start 启动设备管理器,开始处理事件,执行定时任务和轮询设备状态,重复调用无效
+// Start 之前的事件在队列中等待,启动后按顺序处理
+func (m *Manger) Start() {
+	m.eventLocker.Lock()
+	defer m.eventLocker.Unlock()
+	if m.started {
+		return
+	}
+	m.started = true
+	ctx, cancel := context.WithCancel(m.Context)
+	m.stop = cancel
+	m.Cron.Start()
+	m.workers.Add(1)
+	go func() {
+		defer m.workers.Done()
+		m.monitor(ctx)
+	}()
+	for id, q := range m.queues {
+		if !q.running && len(q.events) > 0 {
+			q.running = true
+			go m.drain(id, q)
+		}
+	}
+}
+
+// Stop 停止处理事件,执行定时任务和轮询设备状态,等待正在执行的定时任务结束,可再次 Start
+// 正在处理的事件处理完成后,之后的事件在队列中等待
+func (m *Manger) Stop() {
+	m.eventLocker.Lock()
+	if !m.started {
+		m.eventLocker.Unlock()
+		return
+	}
+	m.started = false
+	m.stop()
+	m.eventLocker.Unlock()
+	<-m.Cron.Stop().Done()
+	m.workers.Wait()
+}
+
+// Register 注册事件,同一事件可注册多个监听,按注册顺序调用,返回取消注册的函数
+func (m *Manger) Register(eventType EventType, lister Listener) Unregister {
+	return m.Subscribe(eventType, func(event Event) {
+		lister(event.Device)
+	})
+}
+
+// Subscribe 注册事件,handler 可获取完整的事件,如 EventError 的错误,返回取消注册的函数
+func (m *Manger) Subscribe(eventType EventType, handler Handler) Unregister {
+	m.eventLocker.Lock()
+	defer m.eventLocker.Unlock()
+	m.sequence++
+	id := m.sequence
+	m.listeners[eventType] = append(m.listeners[eventType], &subscriber{id: id, handle: handler})
+	return func() {
+		m.eventLocker.Lock()
+		defer m.eventLocker.Unlock()
+		list := m.listeners[eventType]
+		for k, sub := range list {
+			if sub.id == id {
+				//复制后删除,不影响正在分发的事件
+				m.listeners[eventType] = append(append([]*subscriber{}, list[:k]...), list[k+1:]...)
+				return
+			}
+		}
+	}
 }
 
 // Adds 批量添加设备
@@ -95,6 +215,19 @@ func (m *Manger) Add(device Device) {
 	})
 }
 
+// AddIfAbsent 添加设备,相同 Id 的设备已经存在时不添加并返回 ExistsDeviceError
+func (m *Manger) AddIfAbsent(device Device) error {
+	if _, loaded := m.devices.LoadOrStore(device.GetId(), device); loaded {
+		return ExistsDeviceError
+	}
+	device.SetCron(m.Cron) //定时任务
+	m.emit(Event{
+		Device:    device,
+		EventType: EventAdd,
+	})
+	return nil
+}
+
 // Run 运行设备
 func (m *Manger) Run(id string) error {
 	if value, ok := m.devices.Load(id); ok {
@@ -157,13 +290,11 @@ func (m *Manger) Range(f func(string, Device)) {
 	})
 }
 
-// Devices 数组形式获取设备
+// Devices 数组形式获取设备,遍历时并发添加的设备可能不包含在内
 func (m *Manger) Devices() []Device {
-	devices := make([]Device, m.Length())
-	i := 0
+	devices := make([]Device, 0)
 	m.devices.Range(func(key, value interface{}) bool {
-		devices[i] = value.(Device)
-		i++
+		devices = append(devices, value.(Device))
 		return true
 	})
 	return devices
@@ -173,16 +304,14 @@ func (m *Manger) Devices() []Device {
 func (m *Manger) GetStatus() []Status {
 	m.locker.Lock()
 	defer m.locker.Unlock()
-	status := make([]Status, m.Length())
-	i := 0
+	status := make([]Status, 0)
 	m.devices.Range(func(key, value interface{}) bool {
 		device := value.(Device)
-		status[i] = Status{
+		status = append(status, Status{
 			Id:     device.GetId(),
 			Type:   device.GetType(),
 			Status: device.GetStatus(),
-		}
-		i++
+		})
 		return true
 	})
 	return status
@@ -196,23 +325,99 @@ func (m *Manger) Length() (length int) {
 	return
 }
 
+// emit 将事件加入设备的事件队列,同一设备的事件按顺序处理,不同设备的事件并发处理
 func (m *Manger) emit(event Event) {
-	select {
-	case m.event <- event:
-	default:
-		log.L.Error("event lost")
+	if err := m.Context.Err(); err != nil {
+		m.fail(event, errors.New(fmt.Sprintf("设备管理器已经关闭: %s", err)))
+		return
+	}
+	m.watch(event)
+	id := event.Device.GetId()
+	m.eventLocker.Lock()
+	q, ok := m.queues[id]
+	if !ok {
+		q = &queue{}
+		m.queues[id] = q
+	}
+	size := m.QueueSize
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	if len(q.events) >= size {
+		m.eventLocker.Unlock()
+		m.fail(event, errors.New(fmt.Sprintf("设备 %s 待处理的事件超过 %d 个", id, size)))
+		return
+	}
+	q.events = append(q.events, event)
+	if !q.running && m.started {
+		q.running = true
+		go m.drain(id, q)
+	}
+	//从未启动时提示一次,Stop 之后事件等待再次 Start 属于正常情况
+	warn := !m.started && m.stop == nil && !m.warned
+	if warn {
+		m.warned = true
+	}
+	m.eventLocker.Unlock()
+	if warn {
+		log.L.Warn(fmt.Sprintf("设备管理器未启动, 设备 %s 的事件 %s 在队列中等待, 请调用 Start", id, event.EventType.String()))
 	}
 }
 
-func (m *Manger) run() {
+// drain 按顺序处理设备的事件,队列为空时退出,停止后保留未处理的事件
+func (m *Manger) drain(id string, q *queue) {
 	for {
-		select {
-		case event := <-m.event:
-			if call, ok := manger.listeners.Load(event.EventType); ok {
-				go call.(Listener)(event.Device)
-			}
-		case <-m.Context.Done():
+		m.eventLocker.Lock()
+		if len(q.events) == 0 || m.Context.Err() != nil {
+			q.running = false
+			q.events = nil
+			delete(m.queues, id)
+			m.eventLocker.Unlock()
 			return
 		}
+		if !m.started {
+			q.running = false
+			m.eventLocker.Unlock()
+			return
+		}
+		event := q.events[0]
+		q.events = q.events[1:]
+		m.eventLocker.Unlock()
+		m.dispatch(event)
+	}
+}
+
+// dispatch 按注册顺序调用事件的监听,监听 panic 时通知 EventError
+func (m *Manger) dispatch(event Event) {
+	m.eventLocker.Lock()
+	subscribers := m.listeners[event.EventType]
+	m.eventLocker.Unlock()
+	for _, sub := range subscribers {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					m.fail(event, errors.New(fmt.Sprintf("处理设备 %s 事件异常: %v", event.Device.GetId(), r)))
+				}
+			}()
+			sub.handle(event)
+		}()
 	}
 }
+
+// fail 通知事件处理失败,EventError 的监听出错时只记录日志,EventRun 失败时设备按重启策略重启
+func (m *Manger) fail(event Event, err error) {
+	t := event.EventType
+	log.L.Error(fmt.Sprintf("设备事件 %s 处理失败: %s", t.String(), err))
+	if event.EventType == EventError {
+		return
+	}
+	if event.EventType == EventRun {
+		m.failed(event.Device, err)
+	}
+	go m.dispatch(Event{
+		Device:    event.Device,
+		EventType: EventError,
+		Cause:     event.EventType,
+		Err:       err,
+	})
+}
@@ -0,0 +1,54 @@
+package device
+
+import (
+	"context"
+	"testing"
+)
+
+// TestNewMangerStarted NewManger 返回已启动的设备管理器,事件立即处理
+func TestNewMangerStarted(t *testing.T) {
+	m := NewManger(context.Background())
+	defer m.Stop()
+	added := make(chan string, 1)
+	m.Register(EventAdd, func(d Device) {
+		added <- d.GetId()
+	})
+	d := &relayDevice{}
+	m.Add(d)
+	waitFor(t, "NewManger 未启动, 事件未处理", func() bool {
+		return len(added) == 1
+	})
+}
+
+// TestNewStart New 返回未启动的设备管理器,事件在 Start 之后处理
+func TestNewStart(t *testing.T) {
+	m := New(context.Background())
+	added := make(chan string, 1)
+	m.Register(EventAdd, func(d Device) {
+		added <- d.GetId()
+	})
+	d := &relayDevice{}
+	m.Add(d)
+	if len(added) != 0 {
+		t.Fatal("未启动时不应处理事件")
+	}
+	m.Start()
+	defer m.Stop()
+	waitFor(t, "Start 后未处理队列中的事件", func() bool {
+		return len(added) == 1
+	})
+}
+
+func TestAddIfAbsent(t *testing.T) {
+	m := New(context.Background())
+	d := &relayDevice{}
+	if err := m.AddIfAbsent(d); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddIfAbsent(&relayDevice{}); err != ExistsDeviceError {
+		t.Fatalf("相同 Id 的设备应返回 ExistsDeviceError, 得到 %v", err)
+	}
+	if m.GetDevice(d.GetId()) != d {
+		t.Fatal("已经存在的设备被替换")
+	}
+}
@@ -0,0 +1,224 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"github.com/zing-dev/atian-tools/log"
+	"github.com/zing-dev/atian-tools/protocol/modbus"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ModbusRelayBranches Modbus 继电器的默认路数
+const ModbusRelayBranches = 32
+
+// ModbusRelay Modbus 继电器,每一路对应一个线圈,第 n 路的线圈地址为 Offset + n - 1
+// 与 Relay 的打开和复位一致,ResetTime 不为空时打开后定时复位
+type ModbusRelay struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	ResetTime string        //自动复位时间 秒,为空时不自动复位
+	Tag       string        //继电器标签
+	Config    modbus.Config //Modbus 通信配置
+	Offset    uint16        //第 1 路的线圈地址
+	Branches  int           //路数
+	Cron      *cron.Cron
+	CronId    cron.EntryID
+	client    *modbus.Client
+	timers    map[int]*time.Timer //每一路的定时复位
+	locker    sync.Mutex
+	status    StatusType
+}
+
+func NewModbusRelay(ctx context.Context, tag string, config modbus.Config, reset string) *ModbusRelay {
+	ctx, cancel := context.WithCancel(ctx)
+	return &ModbusRelay{
+		ctx:       ctx,
+		cancel:    cancel,
+		ResetTime: reset,
+		Tag:       tag,
+		Config:    config,
+		Branches:  ModbusRelayBranches,
+		client:    modbus.NewClient(config),
+		timers:    map[int]*time.Timer{},
+		status:    UnConnect,
+	}
+}
+
+func (r *ModbusRelay) GetId() string {
+	return fmt.Sprintf("relay-%s", r.Tag)
+}
+
+func (r *ModbusRelay) GetTag() string {
+	return r.Tag
+}
+
+func (r *ModbusRelay) GetType() Type {
+	return TypeRelay
+}
+
+func (r *ModbusRelay) SetCron(cron *cron.Cron) {
+	r.Cron = cron
+}
+
+func (r *ModbusRelay) setStatus(t StatusType) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.status = t
+}
+
+func (r *ModbusRelay) GetStatus() StatusType {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	return r.status
+}
+
+// result 根据通信结果更新状态,返回带有继电器和操作信息的错误
+func (r *ModbusRelay) result(action string, err error) error {
+	if err == nil {
+		r.setStatus(Connected)
+		return nil
+	}
+	//从站返回异常说明通信正常
+	var exception *modbus.Exception
+	if errors.As(err, &exception) {
+		r.setStatus(Connected)
+	} else {
+		r.setStatus(Disconnect)
+	}
+	err = errors.New(fmt.Sprintf("Modbus 继电器 %s %s失败: %s", r.Tag, action, err))
+	log.L.Error(err.Error())
+	return err
+}
+
+// branch 解析路数,返回线圈地址
+func (r *ModbusRelay) branch(branch string) (int, uint16, bool) {
+	i, err := strconv.Atoi(strings.TrimSpace(branch))
+	if err != nil || i <= 0 || i > r.Branches {
+		return 0, 0, false
+	}
+	return i, r.Offset + uint16(i-1), true
+}
+
+// Reset 复位一路,branch 为空时复位所有路
+func (r *ModbusRelay) Reset(branch string) error {
+	if branch == "" {
+		r.stop(0)
+		return r.result("复位所有路", r.client.WriteMultipleCoils(r.Offset, make([]bool, r.Branches)))
+	}
+	i, address, ok := r.branch(branch)
+	if !ok {
+		return errors.New(fmt.Sprintf("Modbus 继电器 %s 非法的路数 %s", r.Tag, branch))
+	}
+	r.stop(i)
+	return r.result(fmt.Sprintf("复位第 %d 路", i), r.client.WriteSingleCoil(address, false))
+}
+
+// Alarms 打开多路,以逗号分隔,非法和超出范围的路数忽略,返回所有失败的路数的错误
+func (r *ModbusRelay) Alarms(branch string) error {
+	var errs []string
+	for _, b := range strings.Split(branch, ",") {
+		if _, _, ok := r.branch(b); !ok {
+			continue
+		}
+		if err := r.Alarm(b); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Alarm 打开一路,ResetTime 不为空时定时复位,重复打开时重新计时
+func (r *ModbusRelay) Alarm(branch string) error {
+	i, address, ok := r.branch(branch)
+	if !ok {
+		return errors.New(fmt.Sprintf("Modbus 继电器 %s 非法的路数 %s", r.Tag, branch))
+	}
+	if err := r.result(fmt.Sprintf("打开第 %d 路", i), r.client.WriteSingleCoil(address, true)); err != nil {
+		return err
+	}
+	if r.ResetTime == "" {
+		return nil
+	}
+	sec, err := strconv.Atoi(r.ResetTime)
+	if err != nil || sec <= 0 {
+		return nil
+	}
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if timer, ok := r.timers[i]; ok {
+		timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(time.Second*time.Duration(sec), func() {
+		r.locker.Lock()
+		if r.timers[i] != timer {
+			r.locker.Unlock()
+			return
+		}
+		delete(r.timers, i)
+		r.locker.Unlock()
+		_ = r.result(fmt.Sprintf("定时复位第 %d 路", i), r.client.WriteSingleCoil(address, false))
+	})
+	r.timers[i] = timer
+	return nil
+}
+
+// Coils 读取所有路的状态
+func (r *ModbusRelay) Coils() ([]bool, error) {
+	coils, err := r.client.ReadCoils(r.Offset, uint16(r.Branches))
+	_ = r.result("读取状态", err)
+	return coils, err
+}
+
+// stop 停止一路的定时复位,i 为 0 时停止所有
+func (r *ModbusRelay) stop(i int) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	for k, timer := range r.timers {
+		if i == 0 || k == i {
+			timer.Stop()
+			delete(r.timers, k)
+		}
+	}
+}
+
+// ping 读取第 1 路的线圈检查通信
+func (r *ModbusRelay) ping() {
+	_, err := r.client.ReadCoils(r.Offset, 1)
+	_ = r.result("通信检查", err)
+}
+
+func (r *ModbusRelay) Run() error {
+	r.setStatus(Connecting)
+	if err := r.client.Connect(); err != nil {
+		r.setStatus(Disconnect)
+		log.L.Error(fmt.Sprintf("Modbus 继电器 %s 连接 %s 失败: %s", r.Tag, r.Config.Addr, err))
+	} else {
+		r.ping()
+	}
+	id, err := r.Cron.AddFunc("0 */1 * * * *", r.ping)
+	if err != nil {
+		return err
+	}
+	r.CronId = id
+	return nil
+}
+
+func (r *ModbusRelay) Close() error {
+	r.cancel()
+	r.stop(0)
+	if r.Cron != nil {
+		r.Cron.Remove(r.CronId)
+	}
+	r.setStatus(UnConnect)
+	return r.client.Close()
+}
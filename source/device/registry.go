@@ -0,0 +1,295 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/zing-dev/atian-tools/log"
+	"github.com/zing-dev/atian-tools/protocol/modbus"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+type (
+	// Factory 设备工厂,按设备类型注册到 Registry,负责设备与构造参数的相互转换
+	Factory interface {
+		Params(Device) (interface{}, error)                     //获取设备的构造参数
+		New(ctx context.Context, params []byte) (Device, error) //根据构造参数创建设备
+	}
+
+	// Record 持久化的设备记录
+	Record struct {
+		Type   Type            `json:"type"`
+		Id     string          `json:"id"`
+		Params json.RawMessage `json:"params"` //设备的构造参数
+	}
+
+	// Registry 设备注册表,将设备管理器中的设备保存到文件,启动时通过工厂重建
+	// Watch 后设备的添加,更新和删除会立即写入文件
+	Registry struct {
+		Filename string
+
+		manger     *Manger
+		factories  map[Type]Factory
+		pending    map[string]Record //未能重建的设备记录,保存时原样写回
+		loading    bool              //正在加载,不写入文件
+		locker     sync.Mutex
+		saveLocker sync.Mutex //保证同一时间只有一个写入
+	}
+
+	// RelayParams 继电器的构造参数,Modbus 不为空时为 Modbus 继电器
+	RelayParams struct {
+		Tag       string         `json:"tag"`
+		URL       string         `json:"url,omitempty"`
+		ResetTime string         `json:"reset_time,omitempty"` //自动复位时间 秒
+		Modbus    *modbus.Config `json:"modbus,omitempty"`
+		Offset    uint16         `json:"offset,omitempty"`   //Modbus 第 1 路的线圈地址
+		Branches  int            `json:"branches,omitempty"` //Modbus 路数
+	}
+
+	// RelayFactory 继电器工厂,支持 Relay 和 ModbusRelay
+	RelayFactory struct{}
+)
+
+// NewRegistry 实例化设备注册表,默认注册继电器工厂
+func NewRegistry(manger *Manger, filename string) *Registry {
+	r := &Registry{
+		Filename:  filename,
+		manger:    manger,
+		factories: map[Type]Factory{},
+		pending:   map[string]Record{},
+	}
+	r.Register(TypeRelay, RelayFactory{})
+	return r
+}
+
+// Register 注册设备类型的工厂,同一类型重复注册时替换
+func (r *Registry) Register(t Type, factory Factory) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.factories[t] = factory
+}
+
+func (r *Registry) factory(t Type) (Factory, bool) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	factory, ok := r.factories[t]
+	return factory, ok
+}
+
+// Load 从文件读取设备记录,通过工厂重建设备并添加到设备管理器,文件不存在时不加载
+// 未注册工厂或重建失败的记录保留在文件中,返回重建成功的设备
+func (r *Registry) Load(ctx context.Context) ([]Device, error) {
+	data, err := os.ReadFile(r.Filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	if err = json.Unmarshal(data, &records); err != nil {
+		return nil, errors.New(fmt.Sprintf("解析设备文件 %s 失败: %s", r.Filename, err))
+	}
+	r.locker.Lock()
+	r.loading = true
+	r.locker.Unlock()
+	defer func() {
+		r.locker.Lock()
+		r.loading = false
+		r.locker.Unlock()
+	}()
+	devices := make([]Device, 0, len(records))
+	for _, record := range records {
+		device, err := r.build(ctx, record)
+		if err != nil {
+			log.L.Error(fmt.Sprintf("重建设备 %s 失败: %s", record.Id, err))
+			r.locker.Lock()
+			r.pending[record.Id] = record
+			r.locker.Unlock()
+			continue
+		}
+		r.manger.Add(device)
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+// Build 通过设备类型的工厂创建设备,不添加到设备管理器
+func (r *Registry) Build(ctx context.Context, t Type, params []byte) (Device, error) {
+	factory, ok := r.factory(t)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("设备类型 %s 未注册工厂", t.String()))
+	}
+	return factory.New(ctx, params)
+}
+
+// Rebuild 通过设备类型的工厂根据设备的构造参数重建设备,可用于 Manger.SetRebuilder
+func (r *Registry) Rebuild(device Device) (Device, error) {
+	t := device.GetType()
+	factory, ok := r.factory(t)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("设备类型 %s 未注册工厂", t.String()))
+	}
+	params, err := factory.Params(device)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	return r.build(r.manger.Context, Record{Type: t, Id: device.GetId(), Params: data})
+}
+
+// build 根据记录重建设备
+func (r *Registry) build(ctx context.Context, record Record) (Device, error) {
+	device, err := r.Build(ctx, record.Type, record.Params)
+	if err != nil {
+		return nil, err
+	}
+	if device.GetId() != record.Id {
+		return nil, errors.New(fmt.Sprintf("重建的设备 Id %s 与记录不一致", device.GetId()))
+	}
+	return device, nil
+}
+
+// Records 获取设备管理器中所有设备的记录,按 Id 排序
+func (r *Registry) Records() ([]Record, error) {
+	r.locker.Lock()
+	records := make(map[string]Record, len(r.pending))
+	for id, record := range r.pending {
+		records[id] = record
+	}
+	r.locker.Unlock()
+	var err error
+	r.manger.Range(func(id string, device Device) {
+		if err != nil {
+			return
+		}
+		t := device.GetType()
+		factory, ok := r.factory(t)
+		if !ok {
+			log.L.Warn(fmt.Sprintf("设备类型 %s 未注册工厂, 不保存设备 %s", t.String(), id))
+			return
+		}
+		var params interface{}
+		params, err = factory.Params(device)
+		if err != nil {
+			err = errors.New(fmt.Sprintf("获取设备 %s 的构造参数失败: %s", id, err))
+			return
+		}
+		var data []byte
+		data, err = json.Marshal(params)
+		if err != nil {
+			return
+		}
+		records[id] = Record{Type: t, Id: id, Params: data}
+	})
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Record, 0, len(records))
+	for _, record := range records {
+		list = append(list, record)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Id < list[j].Id
+	})
+	return list, nil
+}
+
+// Save 将设备管理器中的设备写入文件,先写入临时文件再替换
+func (r *Registry) Save() error {
+	r.saveLocker.Lock()
+	defer r.saveLocker.Unlock()
+	records, err := r.Records()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(r.Filename); dir != "" {
+		if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+			return err
+		}
+	}
+	temp := r.Filename + ".tmp"
+	if err = os.WriteFile(temp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp, r.Filename)
+}
+
+// Watch 监听设备的添加,更新和删除并写入文件,返回取消监听的函数
+func (r *Registry) Watch() Unregister {
+	handler := func(event Event) {
+		r.locker.Lock()
+		loading := r.loading
+		if event.EventType == EventDelete {
+			delete(r.pending, event.Device.GetId())
+		}
+		r.locker.Unlock()
+		if loading {
+			return
+		}
+		if err := r.Save(); err != nil {
+			log.L.Error(fmt.Sprintf("保存设备文件 %s 失败: %s", r.Filename, err))
+		}
+	}
+	unregisters := []Unregister{
+		r.manger.Subscribe(EventAdd, handler),
+		r.manger.Subscribe(EventUpdate, handler),
+		r.manger.Subscribe(EventDelete, handler),
+	}
+	return func() {
+		for _, unregister := range unregisters {
+			unregister()
+		}
+	}
+}
+
+func (RelayFactory) Params(device Device) (interface{}, error) {
+	switch relay := device.(type) {
+	case *Relay:
+		return RelayParams{Tag: relay.Tag, URL: relay.URL, ResetTime: relay.ResetTime}, nil
+	case *ModbusRelay:
+		config := relay.Config
+		return RelayParams{
+			Tag:       relay.Tag,
+			ResetTime: relay.ResetTime,
+			Modbus:    &config,
+			Offset:    relay.Offset,
+			Branches:  relay.Branches,
+		}, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("不支持的继电器 %T", device))
+	}
+}
+
+func (RelayFactory) New(ctx context.Context, data []byte) (Device, error) {
+	var params RelayParams
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, err
+	}
+	if params.Tag == "" {
+		return nil, errors.New("继电器标签为空")
+	}
+	if params.Modbus == nil {
+		if params.URL == "" {
+			return nil, errors.New(fmt.Sprintf("继电器 %s 地址为空", params.Tag))
+		}
+		return NewRelay(ctx, params.Tag, params.URL, params.ResetTime), nil
+	}
+	relay := NewModbusRelay(ctx, params.Tag, *params.Modbus, params.ResetTime)
+	relay.Offset = params.Offset
+	if params.Branches > 0 {
+		relay.Branches = params.Branches
+	}
+	return relay, nil
+}
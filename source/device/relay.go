@@ -2,9 +2,11 @@ package device
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/robfig/cron/v3"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -50,6 +52,10 @@ func (r *Relay) GetId() string {
 	return fmt.Sprintf("relay-%s", r.Tag)
 }
 
+func (r *Relay) GetTag() string {
+	return r.Tag
+}
+
 func (r *Relay) GetType() Type {
 	return TypeRelay
 }
@@ -70,24 +76,22 @@ func (r *Relay) GetStatus() StatusType {
 	return r.status
 }
 
-func (r *Relay) Reset(branch string) {
+// Reset 复位一路,branch 为空时复位所有路
+func (r *Relay) Reset(branch string) error {
 	url := fmt.Sprintf("%s/api/off/%s", r.URL, branch)
 	if branch == "" {
 		url = fmt.Sprintf("%s/api/off-all", r.URL)
 	}
-	resp, err := r.Client.Get(url)
-	if err != nil {
-		r.setStatus(Disconnect)
-		return
-	}
-	if resp.StatusCode != http.StatusOK {
-		r.setStatus(Disconnect)
-		return
-	}
-	r.setStatus(Connected)
+	return r.get(url)
 }
 
-func (r *Relay) Alarms(branch string) {
+// Alarms 并发打开多路,以逗号分隔,非法和超出范围的路数忽略,返回所有失败的路数的错误
+func (r *Relay) Alarms(branch string) error {
+	var (
+		wg     sync.WaitGroup
+		locker sync.Mutex
+		errs   []string
+	)
 	for _, b := range strings.Split(branch, ",") {
 		i, err := strconv.Atoi(b)
 		if err != nil {
@@ -96,25 +100,47 @@ func (r *Relay) Alarms(branch string) {
 		if i <= 0 || i > 32 {
 			continue
 		}
-		go r.Alarm(b)
+		wg.Add(1)
+		go func(b string) {
+			defer wg.Done()
+			if err := r.Alarm(b); err != nil {
+				locker.Lock()
+				errs = append(errs, err.Error())
+				locker.Unlock()
+			}
+		}(b)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return errors.New(strings.Join(errs, "; "))
 	}
+	return nil
 }
 
-func (r *Relay) Alarm(branch string) {
+// Alarm 打开一路,ResetTime 不为空时定时复位
+func (r *Relay) Alarm(branch string) error {
 	host := fmt.Sprintf("%s/api/on/%s", r.URL, branch)
 	if r.ResetTime != "" {
 		host = fmt.Sprintf("%s/api/on-point/%s/%s000", r.URL, branch, r.ResetTime)
 	}
-	resp, err := r.Client.Get(host)
+	return r.get(host)
+}
+
+// get 请求继电器接口并根据结果更新状态
+func (r *Relay) get(url string) error {
+	resp, err := r.Client.Get(url)
 	if err != nil {
 		r.setStatus(Disconnect)
-		return
+		return errors.New(fmt.Sprintf("继电器 %s 请求 %s 失败: %s", r.Tag, url, err))
 	}
+	_ = resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		r.setStatus(Disconnect)
-		return
+		return errors.New(fmt.Sprintf("继电器 %s 请求 %s 失败: 状态码 %d", r.Tag, url, resp.StatusCode))
 	}
 	r.setStatus(Connected)
+	return nil
 }
 
 func (r *Relay) ping() {
@@ -0,0 +1,228 @@
+package simulator
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/zing-dev/atian-tools/log"
+	"github.com/zing-dev/atian-tools/protocol/modbus"
+	"net"
+	"sync"
+	"time"
+)
+
+type (
+	// ModbusConfig Modbus 继电器模拟器配置
+	ModbusConfig struct {
+		Addr    string      //监听地址,默认 :5020
+		Mode    modbus.Mode //通信方式,支持 tcp 和 rtu-tcp,默认 tcp
+		SlaveId byte        //从站地址,为 0 时响应所有从站地址
+		Coils   int         //线圈数量,默认 32
+	}
+
+	// Modbus 模拟 Modbus 继电器从站,支持读线圈,写单个线圈和写多个线圈
+	Modbus struct {
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		config    ModbusConfig
+		coils     []bool
+		writes    uint64 //写线圈的次数
+		latency   time.Duration
+		exception byte //不为 0 时所有请求返回该异常
+		silent    bool //不响应请求
+		listener  net.Listener
+		conns     map[net.Conn]struct{}
+		locker    sync.Mutex
+		wg        sync.WaitGroup
+	}
+)
+
+func NewModbus(ctx context.Context, config ModbusConfig) *Modbus {
+	ctx, cancel := context.WithCancel(ctx)
+	if config.Addr == "" {
+		config.Addr = ":5020"
+	}
+	if config.Mode == "" {
+		config.Mode = modbus.ModeTCP
+	}
+	if config.Coils <= 0 {
+		config.Coils = DefaultBranches
+	}
+	return &Modbus{
+		ctx:    ctx,
+		cancel: cancel,
+		config: config,
+		coils:  make([]bool, config.Coils),
+		conns:  map[net.Conn]struct{}{},
+	}
+}
+
+// Listen 开始监听
+func (m *Modbus) Listen() error {
+	listener, err := net.Listen("tcp", m.config.Addr)
+	if err != nil {
+		return err
+	}
+	m.locker.Lock()
+	m.listener = listener
+	m.locker.Unlock()
+	log.L.Info(fmt.Sprintf("Modbus 模拟器开始监听 %s, 通信方式 %s, 线圈 %d", listener.Addr(), m.config.Mode, m.config.Coils))
+	m.wg.Add(1)
+	go m.accept(listener)
+	go func() {
+		<-m.ctx.Done()
+		_ = m.Close()
+	}()
+	return nil
+}
+
+// Addr 获取监听地址
+func (m *Modbus) Addr() net.Addr {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	if m.listener == nil {
+		return nil
+	}
+	return m.listener.Addr()
+}
+
+// Close 关闭模拟器,断开所有连接
+func (m *Modbus) Close() error {
+	m.cancel()
+	m.locker.Lock()
+	var err error
+	if m.listener != nil {
+		err = m.listener.Close()
+		m.listener = nil
+	}
+	for conn := range m.conns {
+		_ = conn.Close()
+	}
+	m.locker.Unlock()
+	m.wg.Wait()
+	return err
+}
+
+// Coils 获取所有线圈的状态
+func (m *Modbus) Coils() []bool {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	return append([]bool{}, m.coils...)
+}
+
+// Writes 获取写线圈的次数
+func (m *Modbus) Writes() uint64 {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	return m.writes
+}
+
+// SetLatency 设置响应延迟
+func (m *Modbus) SetLatency(latency time.Duration) {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	m.latency = latency
+}
+
+// SetException 设置所有请求返回的异常码,为 0 时恢复正常
+func (m *Modbus) SetException(code byte) {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	m.exception = code
+}
+
+// SetSilent 设置是否不响应请求
+func (m *Modbus) SetSilent(silent bool) {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	m.silent = silent
+}
+
+func (m *Modbus) accept(listener net.Listener) {
+	defer m.wg.Done()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-m.ctx.Done():
+			default:
+				log.L.Error(fmt.Sprintf("Modbus 模拟器接收连接失败: %s", err))
+			}
+			return
+		}
+		m.locker.Lock()
+		m.conns[conn] = struct{}{}
+		m.locker.Unlock()
+		m.wg.Add(1)
+		go func() {
+			defer func() {
+				m.locker.Lock()
+				delete(m.conns, conn)
+				m.locker.Unlock()
+				_ = conn.Close()
+				m.wg.Done()
+			}()
+			_ = modbus.Serve(conn, m.config.Mode, m.handle)
+		}()
+	}
+}
+
+// handle 处理请求 PDU
+func (m *Modbus) handle(unit byte, pdu []byte) []byte {
+	if m.config.SlaveId != 0 && unit != m.config.SlaveId {
+		return nil
+	}
+	m.locker.Lock()
+	latency, exception, silent := m.latency, m.exception, m.silent
+	m.locker.Unlock()
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	if silent {
+		return nil
+	}
+	function := pdu[0]
+	if exception != 0 {
+		return modbus.ExceptionResponse(function, exception)
+	}
+	if len(pdu) < 5 {
+		return modbus.ExceptionResponse(function, modbus.ExceptionIllegalValue)
+	}
+	address := int(binary.BigEndian.Uint16(pdu[1:]))
+	value := binary.BigEndian.Uint16(pdu[3:])
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	switch function {
+	case modbus.FuncReadCoils:
+		quantity := int(value)
+		if quantity == 0 || address+quantity > len(m.coils) {
+			return modbus.ExceptionResponse(function, modbus.ExceptionIllegalAddress)
+		}
+		data := modbus.EncodeCoils(m.coils[address : address+quantity])
+		return append([]byte{function, byte(len(data))}, data...)
+	case modbus.FuncWriteSingleCoil:
+		if address >= len(m.coils) {
+			return modbus.ExceptionResponse(function, modbus.ExceptionIllegalAddress)
+		}
+		if value != 0xFF00 && value != 0 {
+			return modbus.ExceptionResponse(function, modbus.ExceptionIllegalValue)
+		}
+		m.coils[address] = value == 0xFF00
+		m.writes++
+		return pdu[:5]
+	case modbus.FuncWriteMultipleCoils:
+		quantity := int(value)
+		if quantity == 0 || address+quantity > len(m.coils) {
+			return modbus.ExceptionResponse(function, modbus.ExceptionIllegalAddress)
+		}
+		if len(pdu) < 6 || len(pdu)-6 != int(pdu[5]) || int(pdu[5]) != (quantity+7)/8 {
+			return modbus.ExceptionResponse(function, modbus.ExceptionIllegalValue)
+		}
+		copy(m.coils[address:], modbus.DecodeCoils(pdu[6:], quantity))
+		m.writes++
+		return pdu[:5]
+	default:
+		return modbus.ExceptionResponse(function, modbus.ExceptionIllegalFunction)
+	}
+}
@@ -0,0 +1,188 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"github.com/zing-dev/atian-tools/protocol/modbus"
+	"github.com/zing-dev/atian-tools/source/device"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, message string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 5)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+// newModbusRelay 启动 Modbus 模拟器,返回连接模拟器的继电器,第 1 路对应线圈 2
+func newModbusRelay(t *testing.T, mode modbus.Mode, reset string) (*Modbus, *device.ModbusRelay) {
+	t.Helper()
+	sim := NewModbus(context.Background(), ModbusConfig{Addr: "127.0.0.1:0", Mode: mode, SlaveId: 3, Coils: 16})
+	if err := sim.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = sim.Close()
+	})
+	relay := device.NewModbusRelay(context.Background(), "modbus", modbus.Config{
+		Mode:    mode,
+		Addr:    sim.Addr().String(),
+		SlaveId: 3,
+		Timeout: 200,
+	}, reset)
+	relay.Offset = 2
+	relay.Branches = 8
+	t.Cleanup(func() {
+		_ = relay.Close()
+	})
+	return sim, relay
+}
+
+func on(coils []bool) []int {
+	var result []int
+	for k, v := range coils {
+		if v {
+			result = append(result, k)
+		}
+	}
+	return result
+}
+
+func TestModbusRelay(t *testing.T) {
+	for _, mode := range []modbus.Mode{modbus.ModeTCP, modbus.ModeRTUTCP} {
+		t.Run(string(mode), func(t *testing.T) {
+			sim, relay := newModbusRelay(t, mode, "")
+			if err := relay.Alarm("1"); err != nil {
+				t.Fatal(err)
+			}
+			if err := relay.Alarms("3, x,9,8"); err != nil {
+				t.Fatal(err)
+			}
+			if coils := on(sim.Coils()); !reflect.DeepEqual(coils, []int{2, 4, 9}) {
+				t.Fatalf("打开的线圈 %v, 期望 [2 4 9]", coils)
+			}
+			if relay.GetStatus() != device.Connected {
+				t.Fatalf("状态 %d", relay.GetStatus())
+			}
+			coils, err := relay.Coils()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(coils, []bool{true, false, true, false, false, false, false, true}) {
+				t.Fatalf("读取到 %v", coils)
+			}
+			if err := relay.Alarm("9"); err == nil {
+				t.Fatal("超出范围的路数应返回错误")
+			}
+
+			if err := relay.Reset("3"); err != nil {
+				t.Fatal(err)
+			}
+			if coils := on(sim.Coils()); !reflect.DeepEqual(coils, []int{2, 9}) {
+				t.Fatalf("复位第 3 路后打开的线圈 %v", coils)
+			}
+			if err := relay.Reset(""); err != nil {
+				t.Fatal(err)
+			}
+			if coils := on(sim.Coils()); coils != nil {
+				t.Fatalf("复位所有路后打开的线圈 %v", coils)
+			}
+			if writes := sim.Writes(); writes != 5 {
+				t.Fatalf("写线圈 %d 次, 期望 5 次", writes)
+			}
+		})
+	}
+}
+
+// TestModbusRelayResetTime 打开后按 ResetTime 定时复位,手动复位后不再定时复位
+func TestModbusRelayResetTime(t *testing.T) {
+	sim, relay := newModbusRelay(t, modbus.ModeTCP, "1")
+	if err := relay.Alarms("1,2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := relay.Reset("2"); err != nil {
+		t.Fatal(err)
+	}
+	if coils := on(sim.Coils()); !reflect.DeepEqual(coils, []int{2}) {
+		t.Fatalf("打开的线圈 %v", coils)
+	}
+	waitFor(t, "未定时复位", func() bool {
+		return on(sim.Coils()) == nil
+	})
+	//打开 2 路, 复位 1 路, 定时复位 1 路
+	time.Sleep(time.Millisecond * 100)
+	if writes := sim.Writes(); writes != 4 {
+		t.Fatalf("写线圈 %d 次, 期望 4 次", writes)
+	}
+}
+
+// TestModbusRelayException 从站返回异常时返回 *modbus.Exception,状态仍为已连接
+func TestModbusRelayException(t *testing.T) {
+	sim, relay := newModbusRelay(t, modbus.ModeRTUTCP, "")
+	sim.SetException(modbus.ExceptionDeviceFailure)
+	err := relay.Alarm("1")
+	var exception *modbus.Exception
+	if err == nil || !strings.Contains(err.Error(), "从站设备故障") {
+		t.Fatalf("得到 %v", err)
+	}
+	if _, err := relay.Coils(); !errors.As(err, &exception) || exception.Code != modbus.ExceptionDeviceFailure {
+		t.Fatalf("得到 %v", err)
+	}
+	if relay.GetStatus() != device.Connected {
+		t.Fatalf("从站异常时状态 %d, 期望已连接", relay.GetStatus())
+	}
+	if sim.Writes() != 0 {
+		t.Fatalf("写线圈 %d 次", sim.Writes())
+	}
+
+	//超出从站线圈范围
+	sim.SetException(0)
+	relay.Branches = 20
+	if err := relay.Alarm("20"); err == nil || !strings.Contains(err.Error(), "非法数据地址") {
+		t.Fatalf("得到 %v", err)
+	}
+}
+
+// TestModbusRelayTimeout 从站不响应时超时并断开,恢复后重新连接
+func TestModbusRelayTimeout(t *testing.T) {
+	for _, mode := range []modbus.Mode{modbus.ModeTCP, modbus.ModeRTUTCP} {
+		t.Run(string(mode), func(t *testing.T) {
+			sim, relay := newModbusRelay(t, mode, "")
+			sim.SetSilent(true)
+			if err := relay.Alarm("1"); err == nil {
+				t.Fatal("从站不响应时应返回错误")
+			}
+			if relay.GetStatus() != device.Disconnect {
+				t.Fatalf("超时后状态 %d, 期望断开", relay.GetStatus())
+			}
+			sim.SetSilent(false)
+			if err := relay.Alarm("1"); err != nil {
+				t.Fatal(err)
+			}
+			if relay.GetStatus() != device.Connected {
+				t.Fatalf("恢复后状态 %d", relay.GetStatus())
+			}
+
+			//响应延迟超过超时时间
+			sim.SetLatency(time.Millisecond * 300)
+			if err := relay.Reset("1"); err == nil {
+				t.Fatal("响应超时应返回错误")
+			}
+			sim.SetLatency(0)
+			if err := relay.Reset("1"); err != nil {
+				t.Fatal(err)
+			}
+			if coils := on(sim.Coils()); coils != nil {
+				t.Fatalf("打开的线圈 %v", coils)
+			}
+		})
+	}
+}
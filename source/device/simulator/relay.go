@@ -0,0 +1,448 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/zing-dev/atian-tools/log"
+	"github.com/zing-dev/atian-tools/source/device"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBranches 继电器的默认路数
+	DefaultBranches = 32
+	// ApiState 查看模拟器状态的接口
+	ApiState = "/sim/state"
+	// ApiFault 设置故障注入的接口
+	ApiFault = "/sim/fault"
+)
+
+type (
+	// Config 继电器模拟器配置
+	Config struct {
+		Addr     string //监听地址,默认 :8090
+		Branches int    //路数,默认 32
+		Version  string //版本号
+	}
+
+	// Branch 一路继电器的状态
+	Branch struct {
+		Id       int               `json:"id"`
+		On       bool              `json:"on"`
+		OnAt     *device.TimeLocal `json:"on_at,omitempty"`  //最近一次打开的时间
+		OffAt    *device.TimeLocal `json:"off_at,omitempty"` //自动关闭的时间,仅定时打开时有效
+		Switches uint64            `json:"switches"`         //打开的次数
+
+		timer *time.Timer
+	}
+
+	// Fault 注入的故障,匹配路径前缀的请求先延迟 Latency,再按 Status 或 Drop 响应
+	Fault struct {
+		Path    string `json:"path"`              //请求路径前缀,为空时匹配所有接口
+		Latency int    `json:"latency,omitempty"` //响应延迟 毫秒
+		Status  int    `json:"status,omitempty"`  //响应的状态码,为 0 时正常处理
+		Drop    bool   `json:"drop,omitempty"`    //不响应直接断开连接
+		Count   int    `json:"count,omitempty"`   //生效的次数,为 0 时一直生效
+	}
+
+	// Request 模拟器收到的请求记录
+	Request struct {
+		Path   string           `json:"path"`
+		Status int              `json:"status"`
+		At     device.TimeLocal `json:"at"`
+	}
+
+	// State 模拟器的状态
+	State struct {
+		Branches []Branch  `json:"branches"`
+		Faults   []Fault   `json:"faults"`
+		Requests []Request `json:"requests"` //最近的请求
+	}
+
+	// Relay 模拟继电器的 HTTP 接口,与 device.Relay 配合测试
+	Relay struct {
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		config   Config
+		branches []*Branch
+		faults   []*Fault
+		requests []Request
+		server   *http.Server
+		listener net.Listener
+		locker   sync.Mutex
+	}
+)
+
+// maxRequests 保留的请求记录数
+const maxRequests = 100
+
+func NewRelay(ctx context.Context, config Config) *Relay {
+	ctx, cancel := context.WithCancel(ctx)
+	if config.Addr == "" {
+		config.Addr = ":8090"
+	}
+	if config.Branches <= 0 {
+		config.Branches = DefaultBranches
+	}
+	if config.Version == "" {
+		config.Version = "relay-simulator"
+	}
+	r := &Relay{
+		ctx:      ctx,
+		cancel:   cancel,
+		config:   config,
+		branches: make([]*Branch, config.Branches),
+	}
+	for i := range r.branches {
+		r.branches[i] = &Branch{Id: i + 1}
+	}
+	return r
+}
+
+// Handler 模拟器的 HTTP 处理器,可直接用于 httptest
+func (r *Relay) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/on/", r.wrap(r.on))
+	mux.HandleFunc("/api/on-point/", r.wrap(r.onPoint))
+	mux.HandleFunc("/api/off/", r.wrap(r.off))
+	mux.HandleFunc("/api/off-all", r.wrap(r.offAll))
+	mux.HandleFunc(device.ApiPing, r.wrap(func(w http.ResponseWriter, _ *http.Request) {
+		r.reply(w, http.StatusOK, "pong")
+	}))
+	mux.HandleFunc(device.ApiVersion, r.wrap(func(w http.ResponseWriter, _ *http.Request) {
+		r.reply(w, http.StatusOK, r.config.Version)
+	}))
+	mux.HandleFunc(ApiState, func(w http.ResponseWriter, _ *http.Request) {
+		r.reply(w, http.StatusOK, r.State())
+	})
+	mux.HandleFunc(ApiFault, r.fault)
+	return mux
+}
+
+// Listen 开始监听
+func (r *Relay) Listen() error {
+	listener, err := net.Listen("tcp", r.config.Addr)
+	if err != nil {
+		return err
+	}
+	r.locker.Lock()
+	r.listener = listener
+	r.server = &http.Server{Handler: r.Handler()}
+	r.locker.Unlock()
+	log.L.Info(fmt.Sprintf("继电器模拟器开始监听 %s, 路数 %d", listener.Addr(), r.config.Branches))
+	go func() {
+		if err := r.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.L.Error(fmt.Sprintf("继电器模拟器服务失败: %s", err))
+		}
+	}()
+	//上级 ctx 结束时自动关闭
+	go func() {
+		<-r.ctx.Done()
+		_ = r.Close()
+	}()
+	return nil
+}
+
+// Addr 获取监听地址
+func (r *Relay) Addr() net.Addr {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if r.listener == nil {
+		return nil
+	}
+	return r.listener.Addr()
+}
+
+// URL 获取 device.Relay 使用的地址
+func (r *Relay) URL() string {
+	addr := r.Addr()
+	if addr == nil {
+		return ""
+	}
+	return fmt.Sprintf("http://%s", addr)
+}
+
+// Close 关闭模拟器,停止所有定时关闭
+func (r *Relay) Close() error {
+	r.cancel()
+	r.locker.Lock()
+	server := r.server
+	for _, b := range r.branches {
+		if b.timer != nil {
+			b.timer.Stop()
+		}
+	}
+	r.locker.Unlock()
+	if server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
+
+// Branch 获取一路继电器的状态,id 从 1 开始
+func (r *Relay) Branch(id int) (Branch, bool) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if id <= 0 || id > len(r.branches) {
+		return Branch{}, false
+	}
+	return r.branches[id-1].copy(), true
+}
+
+// State 获取模拟器的状态
+func (r *Relay) State() State {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	state := State{
+		Branches: make([]Branch, len(r.branches)),
+		Faults:   make([]Fault, len(r.faults)),
+		Requests: append([]Request{}, r.requests...),
+	}
+	for k, b := range r.branches {
+		state.Branches[k] = b.copy()
+	}
+	for k, f := range r.faults {
+		state.Faults[k] = *f
+	}
+	return state
+}
+
+// AddFault 注入故障,多个故障匹配时使用最先添加的
+func (r *Relay) AddFault(fault Fault) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.faults = append(r.faults, &fault)
+}
+
+// ClearFaults 清除所有故障
+func (r *Relay) ClearFaults() {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.faults = nil
+}
+
+// Switch 打开或关闭一路继电器,duration 大于 0 时打开后定时关闭
+func (r *Relay) Switch(id int, on bool, duration time.Duration) error {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if id <= 0 || id > len(r.branches) {
+		return errors.New(fmt.Sprintf("路数 %d 超出范围 1-%d", id, len(r.branches)))
+	}
+	r.set(r.branches[id-1], on, duration)
+	return nil
+}
+
+func (r *Relay) set(b *Branch, on bool, duration time.Duration) {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.OffAt = nil
+	if !on {
+		b.On = false
+		return
+	}
+	now := time.Now()
+	if !b.On {
+		b.Switches++
+	}
+	b.On = true
+	b.OnAt = &device.TimeLocal{Time: now}
+	if duration <= 0 {
+		return
+	}
+	b.OffAt = &device.TimeLocal{Time: now.Add(duration)}
+	var timer *time.Timer
+	timer = time.AfterFunc(duration, func() {
+		r.locker.Lock()
+		defer r.locker.Unlock()
+		//定时器已被新的操作替换
+		if b.timer != timer {
+			return
+		}
+		b.timer, b.On, b.OffAt = nil, false, nil
+		log.L.Info(fmt.Sprintf("继电器模拟器第 %d 路定时关闭", b.Id))
+	})
+	b.timer = timer
+}
+
+func (r *Relay) on(w http.ResponseWriter, req *http.Request) {
+	id, err := r.branch(strings.TrimPrefix(req.URL.Path, "/api/on/"))
+	if err != nil {
+		r.reply(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	_ = r.Switch(id, true, 0)
+	r.reply(w, http.StatusOK, "ok")
+}
+
+func (r *Relay) onPoint(w http.ResponseWriter, req *http.Request) {
+	params := strings.Split(strings.TrimPrefix(req.URL.Path, "/api/on-point/"), "/")
+	if len(params) != 2 {
+		r.reply(w, http.StatusBadRequest, "请求格式为 /api/on-point/{branch}/{ms}")
+		return
+	}
+	id, err := r.branch(params[0])
+	if err != nil {
+		r.reply(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ms, err := strconv.Atoi(params[1])
+	if err != nil || ms <= 0 {
+		r.reply(w, http.StatusBadRequest, fmt.Sprintf("时间 %s 必须为正整数 毫秒", params[1]))
+		return
+	}
+	_ = r.Switch(id, true, time.Millisecond*time.Duration(ms))
+	r.reply(w, http.StatusOK, "ok")
+}
+
+func (r *Relay) off(w http.ResponseWriter, req *http.Request) {
+	id, err := r.branch(strings.TrimPrefix(req.URL.Path, "/api/off/"))
+	if err != nil {
+		r.reply(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	_ = r.Switch(id, false, 0)
+	r.reply(w, http.StatusOK, "ok")
+}
+
+func (r *Relay) offAll(w http.ResponseWriter, _ *http.Request) {
+	r.locker.Lock()
+	for _, b := range r.branches {
+		r.set(b, false, 0)
+	}
+	r.locker.Unlock()
+	r.reply(w, http.StatusOK, "ok")
+}
+
+// fault GET 查看故障,POST 添加故障,DELETE 清除故障
+func (r *Relay) fault(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		r.reply(w, http.StatusOK, r.State().Faults)
+	case http.MethodPost:
+		var fault Fault
+		if err := json.NewDecoder(req.Body).Decode(&fault); err != nil {
+			r.reply(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		r.AddFault(fault)
+		r.reply(w, http.StatusOK, "ok")
+	case http.MethodDelete:
+		r.ClearFaults()
+		r.reply(w, http.StatusOK, "ok")
+	default:
+		r.reply(w, http.StatusMethodNotAllowed, req.Method)
+	}
+}
+
+func (r *Relay) branch(value string) (int, error) {
+	id, err := strconv.Atoi(value)
+	if err != nil || id <= 0 || id > r.config.Branches {
+		return 0, errors.New(fmt.Sprintf("路数 %s 超出范围 1-%d", value, r.config.Branches))
+	}
+	return id, nil
+}
+
+// wrap 记录请求并注入故障
+func (r *Relay) wrap(handle http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		recorder := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			r.record(req.URL.Path, recorder.status)
+		}()
+		fault, ok := r.match(req.URL.Path)
+		if !ok {
+			handle(recorder, req)
+			return
+		}
+		if fault.Latency > 0 {
+			select {
+			case <-time.After(time.Millisecond * time.Duration(fault.Latency)):
+			case <-req.Context().Done():
+				recorder.status = 0
+				return
+			}
+		}
+		switch {
+		case fault.Drop:
+			recorder.status = 0
+			if hijacker, ok := w.(http.Hijacker); ok {
+				if conn, _, err := hijacker.Hijack(); err == nil {
+					_ = conn.Close()
+					return
+				}
+			}
+			panic(http.ErrAbortHandler)
+		case fault.Status != 0:
+			r.reply(recorder, fault.Status, "fault")
+		default:
+			handle(recorder, req)
+		}
+	}
+}
+
+// match 获取匹配请求路径的故障,并减少故障的剩余次数
+func (r *Relay) match(path string) (Fault, bool) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	for k, f := range r.faults {
+		if !strings.HasPrefix(path, f.Path) {
+			continue
+		}
+		fault := *f
+		if f.Count > 0 {
+			f.Count--
+			if f.Count == 0 {
+				r.faults = append(r.faults[:k], r.faults[k+1:]...)
+			}
+		}
+		return fault, true
+	}
+	return Fault{}, false
+}
+
+func (r *Relay) record(path string, status int) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.requests = append(r.requests, Request{Path: path, Status: status, At: device.TimeLocal{Time: time.Now()}})
+	if len(r.requests) > maxRequests {
+		r.requests = append([]Request{}, r.requests[len(r.requests)-maxRequests:]...)
+	}
+}
+
+func (r *Relay) reply(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": status == http.StatusOK,
+		"data":    data,
+	})
+}
+
+func (b *Branch) copy() Branch {
+	c := *b
+	c.timer = nil
+	return c
+}
+
+// statusWriter 记录响应的状态码
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
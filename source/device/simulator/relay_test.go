@@ -0,0 +1,190 @@
+package simulator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/robfig/cron/v3"
+	"github.com/zing-dev/atian-tools/source/device"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newRelay 启动继电器模拟器,返回连接模拟器并已运行的继电器
+func newRelay(t *testing.T, reset string) (*Relay, *device.Relay) {
+	t.Helper()
+	sim := NewRelay(context.Background(), Config{Addr: "127.0.0.1:0", Branches: 8})
+	if err := sim.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = sim.Close()
+	})
+	relay := device.NewRelay(context.Background(), "sim", sim.URL(), reset)
+	relay.Client.Timeout = time.Millisecond * 300
+	relay.SetCron(cron.New(cron.WithSeconds()))
+	if err := relay.Run(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = relay.Close()
+	})
+	return sim, relay
+}
+
+func branches(sim *Relay) []int {
+	var result []int
+	for _, b := range sim.State().Branches {
+		if b.On {
+			result = append(result, b.Id)
+		}
+	}
+	return result
+}
+
+func TestRelay(t *testing.T) {
+	sim, relay := newRelay(t, "")
+	if relay.GetStatus() != device.Connected {
+		t.Fatalf("运行后状态 %d, 期望已连接", relay.GetStatus())
+	}
+	if err := relay.Alarms("1,3,x,40"); err != nil {
+		t.Fatal(err)
+	}
+	if err := relay.Alarm("3"); err != nil {
+		t.Fatal(err)
+	}
+	if on := branches(sim); !reflect.DeepEqual(on, []int{1, 3}) {
+		t.Fatalf("打开的路数 %v, 期望 [1 3]", on)
+	}
+	if b, _ := sim.Branch(3); b.Switches != 1 || b.OffAt != nil {
+		t.Fatalf("重复打开时不应重新计数, 得到 %+v", b)
+	}
+	if err := relay.Reset("1"); err != nil {
+		t.Fatal(err)
+	}
+	if on := branches(sim); !reflect.DeepEqual(on, []int{3}) {
+		t.Fatalf("复位第 1 路后打开的路数 %v", on)
+	}
+	if err := relay.Reset(""); err != nil {
+		t.Fatal(err)
+	}
+	if on := branches(sim); on != nil {
+		t.Fatalf("复位所有路后打开的路数 %v", on)
+	}
+	//超出模拟器路数
+	if err := relay.Alarm("9"); err == nil || !strings.Contains(err.Error(), "状态码 400") {
+		t.Fatalf("得到 %v", err)
+	}
+	requests := sim.State().Requests
+	if len(requests) == 0 || requests[0].Path != device.ApiPing || requests[len(requests)-1].Status != http.StatusBadRequest {
+		t.Fatalf("请求记录 %+v", requests)
+	}
+}
+
+// TestRelayOnPoint ResetTime 不为空时通过 on-point 打开,模拟器到时自动关闭
+func TestRelayOnPoint(t *testing.T) {
+	sim, relay := newRelay(t, "1")
+	start := time.Now()
+	if err := relay.Alarms("2,5"); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := sim.Branch(2)
+	if !b.On || b.OffAt == nil || b.OffAt.Sub(b.OnAt.Time) != time.Second {
+		t.Fatalf("得到 %+v", b)
+	}
+	waitFor(t, "未自动关闭", func() bool {
+		return branches(sim) == nil
+	})
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("%s 后关闭, 期望 1s", elapsed)
+	}
+	if b, _ := sim.Branch(5); b.OffAt != nil || b.Switches != 1 {
+		t.Fatalf("得到 %+v", b)
+	}
+}
+
+// TestRelayFault 注入的故障使请求失败并断开,清除故障后恢复
+func TestRelayFault(t *testing.T) {
+	sim, relay := newRelay(t, "")
+	tests := []struct {
+		name  string
+		fault Fault
+	}{
+		{name: "状态码", fault: Fault{Path: "/api/on/", Status: http.StatusInternalServerError}},
+		{name: "断开连接", fault: Fault{Path: "/api/on/", Drop: true}},
+		{name: "超时", fault: Fault{Path: "/api/on/", Latency: 500}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sim.AddFault(test.fault)
+			if err := relay.Alarm("1"); err == nil {
+				t.Fatal("请求应失败")
+			}
+			if relay.GetStatus() != device.Disconnect {
+				t.Fatalf("失败后状态 %d, 期望断开", relay.GetStatus())
+			}
+			if on := branches(sim); on != nil {
+				t.Fatalf("失败的请求不应打开, 得到 %v", on)
+			}
+			sim.ClearFaults()
+			if err := relay.Alarm("1"); err != nil {
+				t.Fatal(err)
+			}
+			if relay.GetStatus() != device.Connected {
+				t.Fatalf("恢复后状态 %d", relay.GetStatus())
+			}
+			if err := relay.Reset(""); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	//故障次数用完后自动清除
+	sim.AddFault(Fault{Path: "/api/off/", Status: http.StatusServiceUnavailable, Count: 1})
+	if err := relay.Reset("1"); err == nil || !strings.Contains(err.Error(), "状态码 503") {
+		t.Fatalf("得到 %v", err)
+	}
+	if faults := sim.State().Faults; len(faults) != 0 {
+		t.Fatalf("次数用完的故障应清除, 得到 %+v", faults)
+	}
+	if err := relay.Reset("1"); err != nil {
+		t.Fatal(err)
+	}
+
+	//延迟小于超时时间时正常打开
+	sim.AddFault(Fault{Path: "/api/on/", Latency: 100})
+	start := time.Now()
+	err := relay.Alarms("1,3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*100 {
+		t.Fatalf("%s 后返回, 期望延迟 100ms", elapsed)
+	}
+	//通过接口清除延迟并注入第 2 路的故障,多路中失败的路数返回错误
+	request, _ := http.NewRequest(http.MethodDelete, sim.URL()+ApiFault, nil)
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	body, _ := json.Marshal(Fault{Path: "/api/on/2", Status: http.StatusInternalServerError})
+	resp, err = http.Post(sim.URL()+ApiFault, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if faults := sim.State().Faults; len(faults) != 1 || faults[0].Path != "/api/on/2" {
+		t.Fatalf("得到 %+v", faults)
+	}
+	err = relay.Alarms("2,4")
+	if err == nil || !strings.Contains(err.Error(), "/api/on/2") || strings.Contains(err.Error(), "/api/on/4") {
+		t.Fatalf("得到 %v", err)
+	}
+	if on := branches(sim); !reflect.DeepEqual(on, []int{1, 3, 4}) {
+		t.Fatalf("打开的路数 %v, 期望 [1 3 4]", on)
+	}
+}
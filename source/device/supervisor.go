@@ -0,0 +1,313 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/zing-dev/atian-tools/log"
+	"sort"
+	"time"
+)
+
+// DefaultStatusInterval 默认的设备状态轮询间隔
+const DefaultStatusInterval = time.Second
+
+type (
+	// RestartPolicy 设备的重启策略,按设备类型配置,未配置的类型不自动重启
+	// 重启即先 Close 再 Run,Rebuild 时关闭后通过 SetRebuilder 设置的函数重建设备,替换后运行新的设备
+	// dts.App 关闭后不能再次运行,必须设置 Rebuild
+	RestartPolicy struct {
+		MinBackoff      time.Duration //首次重启前的等待时间
+		MaxBackoff      time.Duration //重启等待时间的上限
+		Factor          float64       //每次重启失败后等待时间的倍数
+		DisconnectAfter time.Duration //断开持续该时间后重启
+		MaxRestarts     uint64        //连续重启的最大次数,为 0 时不限
+		Rebuild         bool          //重启时重建设备
+	}
+
+	// Supervised 设备的监控状态
+	Supervised struct {
+		Id        string     `json:"id"`
+		Type      Type       `json:"type"`
+		Status    StatusType `json:"status"`
+		Restarts  uint64     `json:"restarts"`             //累计重启次数
+		Failures  uint64     `json:"failures"`             //连续重启未恢复的次数
+		LastError string     `json:"last_error,omitempty"` //最近一次运行失败的原因
+		Next      *TimeLocal `json:"next,omitempty"`       //下一次允许重启的时间
+	}
+
+	// supervised 设备的监控状态
+	supervised struct {
+		device     Device
+		status     StatusType
+		since      time.Time //当前状态开始的时间
+		active     bool      //设备已运行,关闭和删除后不再重启
+		failed     bool      //设备运行失败,等待重启
+		restarting bool
+		restarts   uint64
+		failures   uint64
+		lastError  string
+		next       time.Time
+	}
+)
+
+// DefaultRestartPolicy 默认的重启策略,断开 30 秒后重启,等待时间从 5 秒开始翻倍,最长 5 分钟
+func DefaultRestartPolicy() RestartPolicy {
+	return RestartPolicy{
+		MinBackoff:      time.Second * 5,
+		MaxBackoff:      time.Minute * 5,
+		Factor:          2,
+		DisconnectAfter: time.Second * 30,
+	}
+}
+
+// backoff 第 n 次连续重启后的等待时间
+func (p RestartPolicy) backoff(n uint64) time.Duration {
+	backoff := float64(p.MinBackoff)
+	factor := p.Factor
+	if factor < 1 {
+		factor = 1
+	}
+	for i := uint64(1); i < n; i++ {
+		backoff *= factor
+		if p.MaxBackoff > 0 && backoff >= float64(p.MaxBackoff) {
+			return p.MaxBackoff
+		}
+	}
+	return time.Duration(backoff)
+}
+
+// SetStatusInterval 设置设备状态的轮询间隔,默认 DefaultStatusInterval,在下一次轮询后生效
+func (m *Manger) SetStatusInterval(interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultStatusInterval
+	}
+	m.superviseLocker.Lock()
+	defer m.superviseLocker.Unlock()
+	m.statusInterval = interval
+}
+
+// SetRebuilder 设置重启时重建设备的函数,如 Registry.Rebuild
+func (m *Manger) SetRebuilder(rebuilder Rebuilder) {
+	m.superviseLocker.Lock()
+	defer m.superviseLocker.Unlock()
+	m.rebuilder = rebuilder
+}
+
+// SetRestartPolicy 设置设备类型的重启策略
+// 关闭后不能再次运行的类型未设置 Rebuild,或设置 Rebuild 但未设置重建函数时返回错误
+func (m *Manger) SetRestartPolicy(t Type, policy RestartPolicy) error {
+	m.superviseLocker.Lock()
+	defer m.superviseLocker.Unlock()
+	if t == TypeDTS && !policy.Rebuild {
+		return errors.New(fmt.Sprintf("设备类型 %s 关闭后不能再次运行, 重启策略需设置 Rebuild", t.String()))
+	}
+	if policy.Rebuild && m.rebuilder == nil {
+		return errors.New(fmt.Sprintf("设备类型 %s 的重启策略需要重建设备, 未设置重建函数", t.String()))
+	}
+	m.policies[t] = policy
+	return nil
+}
+
+// RemoveRestartPolicy 删除设备类型的重启策略,该类型的设备不再自动重启
+func (m *Manger) RemoveRestartPolicy(t Type) {
+	m.superviseLocker.Lock()
+	defer m.superviseLocker.Unlock()
+	delete(m.policies, t)
+}
+
+// RunDevice EventRun 的处理函数,运行设备并在 Run 返回错误时记录失败,设备按重启策略重启
+// 使用 m.Subscribe(EventRun, m.RunDevice) 注册
+func (m *Manger) RunDevice(event Event) {
+	if err := event.Device.Run(); err != nil {
+		m.fail(event, errors.New(fmt.Sprintf("运行设备 %s 失败: %s", event.Device.GetId(), err)))
+	}
+}
+
+// Failed 报告设备运行失败,设备按重启策略重启
+// 使用 RunDevice,或 EventRun 的监听 panic,事件丢失时自动视为运行失败,不需要调用
+func (m *Manger) Failed(id string, err error) {
+	device := m.GetDevice(id)
+	if device == nil {
+		return
+	}
+	m.fail(Event{Device: device, EventType: EventRun}, err)
+}
+
+// failed 记录设备运行失败,等待下一次检查时重启
+func (m *Manger) failed(device Device, err error) {
+	m.superviseLocker.Lock()
+	defer m.superviseLocker.Unlock()
+	s := m.supervise(device)
+	s.active, s.failed = true, true
+	if err != nil {
+		s.lastError = err.Error()
+	}
+}
+
+// Restarts 获取设备的累计重启次数
+func (m *Manger) Restarts(id string) uint64 {
+	m.superviseLocker.Lock()
+	defer m.superviseLocker.Unlock()
+	if s, ok := m.supervised[id]; ok {
+		return s.restarts
+	}
+	return 0
+}
+
+// Supervised 获取所有设备的监控状态,按 Id 排序
+func (m *Manger) Supervised() []Supervised {
+	m.superviseLocker.Lock()
+	defer m.superviseLocker.Unlock()
+	list := make([]Supervised, 0, len(m.supervised))
+	for id, s := range m.supervised {
+		item := Supervised{
+			Id:        id,
+			Type:      s.device.GetType(),
+			Status:    s.status,
+			Restarts:  s.restarts,
+			Failures:  s.failures,
+			LastError: s.lastError,
+		}
+		if !s.next.IsZero() {
+			item.Next = &TimeLocal{Time: s.next}
+		}
+		list = append(list, item)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Id < list[j].Id
+	})
+	return list
+}
+
+// watch 根据设备事件更新监控状态
+func (m *Manger) watch(event Event) {
+	m.superviseLocker.Lock()
+	defer m.superviseLocker.Unlock()
+	id := event.Device.GetId()
+	switch event.EventType {
+	case EventRun:
+		s := m.supervise(event.Device)
+		s.active = true
+	case EventUpdate:
+		m.supervise(event.Device).device = event.Device
+	case EventClose:
+		if s, ok := m.supervised[id]; ok {
+			s.active, s.failed, s.failures, s.next = false, false, 0, time.Time{}
+		}
+	case EventDelete:
+		delete(m.supervised, id)
+	}
+}
+
+// supervise 获取设备的监控状态,不存在时创建
+func (m *Manger) supervise(device Device) *supervised {
+	s, ok := m.supervised[device.GetId()]
+	if !ok {
+		s = &supervised{device: device, status: device.GetStatus(), since: time.Now()}
+		m.supervised[device.GetId()] = s
+	}
+	return s
+}
+
+// monitor 定时轮询设备状态,发布 EventStatus 并按重启策略重启设备
+func (m *Manger) monitor(ctx context.Context) {
+	for {
+		m.superviseLocker.Lock()
+		interval := m.statusInterval
+		m.superviseLocker.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-time.After(interval):
+			m.check(now)
+		}
+	}
+}
+
+// check 检查所有设备的状态
+func (m *Manger) check(now time.Time) {
+	type task struct {
+		s       *supervised
+		device  Device
+		n       uint64
+		rebuild bool
+	}
+	var (
+		events []Event
+		tasks  []task
+	)
+	m.superviseLocker.Lock()
+	m.Range(func(id string, device Device) {
+		s := m.supervise(device)
+		status := device.GetStatus()
+		if status != s.status {
+			events = append(events, Event{Device: device, EventType: EventStatus, Status: status, Previous: s.status})
+			s.status, s.since = status, now
+			if status == Connected {
+				s.failures, s.failed, s.next = 0, false, time.Time{}
+			}
+		}
+		policy, ok := m.policies[device.GetType()]
+		if !ok || !s.active || s.restarting || now.Before(s.next) {
+			return
+		}
+		if policy.MaxRestarts > 0 && s.failures >= policy.MaxRestarts {
+			return
+		}
+		if s.failed || (status == Disconnect && now.Sub(s.since) >= policy.DisconnectAfter) {
+			s.restarting, s.failed = true, false
+			s.restarts++
+			s.failures++
+			s.next = now.Add(policy.backoff(s.failures))
+			tasks = append(tasks, task{s: s, device: device, n: s.failures, rebuild: policy.Rebuild})
+		}
+	})
+	m.superviseLocker.Unlock()
+	for _, event := range events {
+		m.emit(event)
+	}
+	for _, t := range tasks {
+		go m.restart(t.s, t.device, t.n, t.rebuild)
+	}
+}
+
+// restart 关闭后重新运行设备,rebuild 时重建设备并替换后运行,n 为连续重启的次数
+func (m *Manger) restart(s *supervised, device Device, n uint64, rebuild bool) {
+	log.L.Warn(fmt.Sprintf("重启设备 %s, 连续第 %d 次", device.GetId(), n))
+	_ = device.Close()
+	err := m.rerun(device, rebuild)
+	m.superviseLocker.Lock()
+	s.restarting = false
+	s.since = time.Now()
+	m.superviseLocker.Unlock()
+	if err != nil {
+		m.fail(Event{Device: device, EventType: EventRun}, errors.New(fmt.Sprintf("重启设备 %s 失败: %s", device.GetId(), err)))
+	}
+}
+
+// rerun 运行关闭后的设备,rebuild 时重建设备并通过 Update 替换,设备已被删除或替换时不再运行
+func (m *Manger) rerun(device Device, rebuild bool) error {
+	if !rebuild {
+		return device.Run()
+	}
+	m.superviseLocker.Lock()
+	rebuilder := m.rebuilder
+	m.superviseLocker.Unlock()
+	if rebuilder == nil {
+		return errors.New("未设置重建函数")
+	}
+	if m.GetDevice(device.GetId()) != device {
+		return nil
+	}
+	d, err := rebuilder(device)
+	if err != nil {
+		return errors.New(fmt.Sprintf("重建设备失败: %s", err))
+	}
+	if d.GetId() != device.GetId() {
+		_ = d.Close()
+		return errors.New(fmt.Sprintf("重建的设备 Id %s 与原设备不一致", d.GetId()))
+	}
+	m.Update(d)
+	return d.Run()
+}
@@ -0,0 +1,221 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// onceDevice 关闭后不能再次运行的设备,与 dts.App 一致
+type onceDevice struct {
+	id     string
+	fail   bool //Run 返回错误
+	locker sync.Mutex
+	runs   int
+	closed bool
+	status StatusType
+}
+
+type onceFactory struct {
+	built uint64
+}
+
+func (d *onceDevice) GetId() string      { return d.id }
+func (d *onceDevice) GetType() Type      { return TypeDTS }
+func (d *onceDevice) SetCron(*cron.Cron) {}
+
+func (d *onceDevice) GetStatus() StatusType {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	return d.status
+}
+
+func (d *onceDevice) Run() error {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	if d.closed {
+		return errors.New(fmt.Sprintf("设备 %s 已经关闭", d.id))
+	}
+	d.runs++
+	if d.fail {
+		d.status = Disconnect
+		return errors.New("连接失败")
+	}
+	d.status = Connected
+	return nil
+}
+
+func (d *onceDevice) Close() error {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	d.closed, d.status = true, UnConnect
+	return nil
+}
+
+func (f *onceFactory) Params(d Device) (interface{}, error) {
+	return map[string]string{"id": d.GetId()}, nil
+}
+
+func (f *onceFactory) New(_ context.Context, data []byte) (Device, error) {
+	var params map[string]string
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, err
+	}
+	atomic.AddUint64(&f.built, 1)
+	return &onceDevice{id: params["id"]}, nil
+}
+
+// waitFor 等待条件满足
+func waitFor(t *testing.T, message string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSetRestartPolicyRebuild(t *testing.T) {
+	m := New(context.Background())
+	if err := m.SetRestartPolicy(TypeDTS, DefaultRestartPolicy()); err == nil {
+		t.Fatal("关闭后不能再次运行的类型未设置 Rebuild 时应返回错误")
+	}
+	policy := DefaultRestartPolicy()
+	policy.Rebuild = true
+	if err := m.SetRestartPolicy(TypeRelay, policy); err == nil {
+		t.Fatal("未设置重建函数时应返回错误")
+	}
+	m.SetRebuilder(NewRegistry(m, "").Rebuild)
+	if err := m.SetRestartPolicy(TypeDTS, policy); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetRestartPolicy(TypeRelay, DefaultRestartPolicy()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRestartRebuild 运行失败的设备自动记录,重启时通过注册表重建并替换
+func TestRestartRebuild(t *testing.T) {
+	m := New(context.Background())
+	m.SetStatusInterval(10 * time.Millisecond)
+	factory := &onceFactory{}
+	registry := NewRegistry(m, "")
+	registry.Register(TypeDTS, factory)
+	m.SetRebuilder(registry.Rebuild)
+	if err := m.SetRestartPolicy(TypeDTS, RestartPolicy{MinBackoff: 10 * time.Millisecond, Factor: 1, Rebuild: true}); err != nil {
+		t.Fatal(err)
+	}
+	m.Subscribe(EventRun, m.RunDevice)
+	m.Start()
+	defer m.Stop()
+
+	old := &onceDevice{id: "dts-1", fail: true}
+	m.Add(old)
+	if err := m.Run(old.GetId()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "设备未被重建并运行", func() bool {
+		d, ok := m.GetDevice(old.GetId()).(*onceDevice)
+		return ok && d != old && d.GetStatus() == Connected
+	})
+	if atomic.LoadUint64(&factory.built) != 1 {
+		t.Fatalf("重建 %d 次, 期望 1 次", factory.built)
+	}
+	if m.Restarts(old.GetId()) != 1 {
+		t.Fatalf("重启 %d 次, 期望 1 次", m.Restarts(old.GetId()))
+	}
+	if !old.closed {
+		t.Fatal("原设备应被关闭")
+	}
+	supervised := m.Supervised()
+	if len(supervised) != 1 || supervised[0].LastError == "" {
+		t.Fatalf("应自动记录运行失败的原因, 得到 %+v", supervised)
+	}
+}
+
+// TestRestartWithoutRebuild 未重建时重启即先 Close 再 Run
+func TestRestartWithoutRebuild(t *testing.T) {
+	m := New(context.Background())
+	m.SetStatusInterval(10 * time.Millisecond)
+	if err := m.SetRestartPolicy(TypeRelay, RestartPolicy{MinBackoff: 10 * time.Millisecond, Factor: 1, MaxRestarts: 3}); err != nil {
+		t.Fatal(err)
+	}
+	m.Start()
+	defer m.Stop()
+
+	d := &relayDevice{}
+	m.Add(d)
+	m.Failed(d.GetId(), errors.New("运行失败"))
+	waitFor(t, "设备未重启", func() bool {
+		return d.runs() == 1
+	})
+	if m.Restarts(d.GetId()) != 1 {
+		t.Fatalf("重启 %d 次, 期望 1 次", m.Restarts(d.GetId()))
+	}
+}
+
+// TestRunPanicFailed EventRun 的监听 panic 时自动记录运行失败
+func TestRunPanicFailed(t *testing.T) {
+	m := New(context.Background())
+	m.Subscribe(EventRun, func(Event) {
+		panic("运行失败")
+	})
+	m.Start()
+	defer m.Stop()
+
+	d := &relayDevice{}
+	m.Add(d)
+	if err := m.Run(d.GetId()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "未记录运行失败", func() bool {
+		supervised := m.Supervised()
+		return len(supervised) == 1 && supervised[0].LastError != ""
+	})
+}
+
+// relayDevice 可以再次运行的设备
+type relayDevice struct {
+	locker sync.Mutex
+	count  int
+	status StatusType
+}
+
+func (d *relayDevice) GetId() string      { return "relay-test" }
+func (d *relayDevice) GetType() Type      { return TypeRelay }
+func (d *relayDevice) SetCron(*cron.Cron) {}
+
+func (d *relayDevice) GetStatus() StatusType {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	return d.status
+}
+
+func (d *relayDevice) Run() error {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	d.count++
+	d.status = Connected
+	return nil
+}
+
+func (d *relayDevice) Close() error {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	d.status = UnConnect
+	return nil
+}
+
+func (d *relayDevice) runs() int {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	return d.count
+}